@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	_ "github.com/jackc/pgx/v4/stdlib"
+
+	"github.com/alexkopcak/shortener/internal/config"
+	"github.com/alexkopcak/shortener/internal/storage"
+	"github.com/alexkopcak/shortener/internal/storage/migrations"
+)
+
+// Command line errors.
+var (
+	ErrUnknownCommand      = errors.New("unknown command")                             // command is not supported
+	ErrNoDatabase          = errors.New("database connection string is empty")         // command requires postgres storage
+	ErrNoFileStorage       = errors.New("file storage path is empty")                  // command requires file storage
+	ErrDatabaseUnavailable = errors.New("database is not available")                   // postgres storage can not be opened
+	ErrUnknownEndpoint     = errors.New("storage must be file:<path> or postgres://")  // migration storage is not supported
+	ErrCheckpointMismatch  = errors.New("checkpoint belongs to another migration")     // checkpoint file is left by other storages
+	ErrVerificationFailed  = errors.New("migrated links differ from the source links") // verification found lost or changed links
+)
+
+const (
+	migrationCheckpointConst = "migrate-data.checkpoint" // default checkpoint file name of the data migration
+	migrationBatchSizeConst  = 500
+	migrationSamplesConst    = 100
+)
+
+// type migrationCheckpoint is the data migration progress, the next run continues after the last copied short URL value.
+// the storages are kept as a hash, the connection string may contain a password.
+type migrationCheckpoint struct {
+	Migration string `json:"migration"`
+	After     string `json:"after"`
+	Saved     int    `json:"saved"`
+	Skipped   int    `json:"skipped"`
+}
+
+// func runCommand executes the service command, args are the command line arguments without flags.
+// usage: shortener [flags] migrate up|down|status
+//
+//	shortener [flags] compact
+//	shortener [flags] fsck [repair]
+//	shortener [flags] migrate-data --from file:<path>|postgres://<dsn> --to file:<path>|postgres://<dsn>
+func runCommand(cfg config.Config, args []string) error {
+	switch args[0] {
+	case "migrate":
+		return runMigrate(cfg, args[1:])
+	case "compact":
+		return runCompact(cfg)
+	case "fsck":
+		return runFsck(cfg, args[1:])
+	case "migrate-data":
+		return runMigrateData(cfg, args[1:])
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownCommand, args[0])
+	}
+}
+
+// func runMigrate applies, rolls back or shows the postgres schema migrations.
+func runMigrate(cfg config.Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%w: usage migrate up|down|status", ErrUnknownCommand)
+	}
+
+	if strings.TrimSpace(cfg.DBConnectionString) == "" {
+		return ErrNoDatabase
+	}
+
+	db, err := sql.Open("pgx", cfg.DBConnectionString)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := migrations.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("database schema is up to date")
+		}
+		for _, m := range applied {
+			fmt.Printf("applied %04d %s\n", m.Version, m.Name)
+		}
+	case "down":
+		m, err := migrator.Down(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("rolled back %04d %s\n", m.Version, m.Name)
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, v := range status {
+			appliedAt := "pending"
+			if v.AppliedAt != nil {
+				appliedAt = v.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			fmt.Printf("%04d %-20s %s\n", v.Version, v.Name, appliedAt)
+		}
+	default:
+		return fmt.Errorf("%w: migrate %s", ErrUnknownCommand, args[0])
+	}
+	return nil
+}
+
+// func runCompact writes the snapshot of the file storage and truncates its log.
+// the service must be stopped, the command is the only writer of the file.
+func runCompact(cfg config.Config) error {
+	if strings.TrimSpace(cfg.FileStoragePath) == "" {
+		return ErrNoFileStorage
+	}
+
+	cfg.ExpiredReapInterval = 0
+	cfg.CompactInterval = 0
+
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+
+	repo, err := storage.NewDictionary(cfg, wg)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	records, err := repo.(storage.Compactor).Compact(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("compacted %s: %d records in the snapshot\n", cfg.FileStoragePath, records)
+	return nil
+}
+
+// func runFsck checks the file storage log and its snapshot, with repair argument the corrupted records are removed.
+// the service must be stopped, the command is the only writer of the files.
+func runFsck(cfg config.Config, args []string) error {
+	if strings.TrimSpace(cfg.FileStoragePath) == "" {
+		return ErrNoFileStorage
+	}
+
+	repair := false
+	switch {
+	case len(args) == 1 && args[0] == "repair":
+		repair = true
+	case len(args) != 0:
+		return fmt.Errorf("%w: usage fsck [repair]", ErrUnknownCommand)
+	}
+
+	corrupted := false
+	for _, filename := range []string{storage.SnapshotPath(cfg.FileStoragePath), cfg.FileStoragePath} {
+		if _, err := os.Stat(filename); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		check := storage.CheckLog
+		if repair {
+			check = storage.RepairLog
+		}
+		report, err := check(filename)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s: %d bytes, %d records, %d without checksum, %d corrupted\n",
+			filename, report.Size, report.Records, report.Legacy, report.Corrupted)
+		if report.Corrupted == 0 {
+			continue
+		}
+		if repair {
+			fmt.Printf("%s: repaired, %d corrupted records removed\n", filename, report.Corrupted)
+			continue
+		}
+		corrupted = true
+	}
+
+	if corrupted {
+		return fmt.Errorf("%w: run fsck repair", storage.ErrCorruptedLog)
+	}
+	return nil
+}
+
+// func runMigrateData copies the links with their owners, deletion state and history from one storage to another.
+// the links are copied by batches, the checkpoint file keeps the progress, so the interrupted migration continues
+// from the last batch. The copy is followed by the verification of the counts and of the random sample of links.
+// the service must be stopped, the command is the only writer of the storages.
+func runMigrateData(cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("migrate-data", flag.ContinueOnError)
+	from := flags.String("from", "", "source storage: file:<path> or postgres://<dsn>")
+	to := flags.String("to", "", "target storage: file:<path> or postgres://<dsn>")
+	batchSize := flags.Int("batch-size", migrationBatchSizeConst, "count of links copied by one batch")
+	samples := flags.Int("samples", migrationSamplesConst, "count of links compared by the verification")
+	checkpointPath := flags.String("checkpoint", migrationCheckpointConst, "file of the migration progress")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", ErrUnknownCommand, err)
+	}
+	if *from == "" || *to == "" || flags.NArg() != 0 {
+		return fmt.Errorf("%w: usage migrate-data --from <storage> --to <storage>", ErrUnknownCommand)
+	}
+	if *from == *to {
+		return fmt.Errorf("%w: the source and the target are the same", ErrUnknownCommand)
+	}
+	if *batchSize <= 0 || *samples < 0 {
+		return fmt.Errorf("%w: batch size must be positive, samples must not be negative", ErrUnknownCommand)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+
+	source, err := openMigrationStorage(cfg, *from, wg)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := openMigrationStorage(cfg, *to, wg)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	migration := sha256.Sum256([]byte(*from + "\n" + *to))
+	checkpoint, err := loadCheckpoint(*checkpointPath, hex.EncodeToString(migration[:]))
+	if err != nil {
+		return err
+	}
+	if checkpoint.After != "" {
+		fmt.Printf("continue after %s: %d links saved, %d skipped\n", checkpoint.After, checkpoint.Saved, checkpoint.Skipped)
+	}
+
+	err = storage.CopyLinks(ctx, source, target, checkpoint.After, *batchSize, func(last string, saved int, skipped int) error {
+		checkpoint.After = last
+		checkpoint.Saved += saved
+		checkpoint.Skipped += skipped
+		fmt.Printf("copied up to %s: %d links saved, %d skipped\n", last, checkpoint.Saved, checkpoint.Skipped)
+		return saveCheckpoint(*checkpointPath, checkpoint)
+	})
+	if err != nil {
+		return err
+	}
+
+	report, err := storage.VerifyLinks(ctx, source, target, *batchSize, *samples)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("verified: %d links at the source, %d at the target, %d sampled links compared\n",
+		report.SourceCount, report.TargetCount, report.Sampled)
+	for _, v := range report.Mismatches {
+		fmt.Println(v)
+	}
+	if report.Failed() {
+		return ErrVerificationFailed
+	}
+
+	if err = os.Remove(*checkpointPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// type migrationStorage is the storage which can copy its links.
+type migrationStorage interface {
+	storage.LinkTransfer
+	Close() error
+}
+
+// func openMigrationStorage opens the storage of the data migration without background workers.
+// the postgres storage is not replaced by the memory one if the database is not available.
+func openMigrationStorage(cfg config.Config, endpoint string, wg *sync.WaitGroup) (migrationStorage, error) {
+	cfg.ExpiredReapInterval = 0
+	cfg.CompactInterval = 0
+	cfg.PurgeInterval = 0
+
+	var repo storage.Storage
+	var err error
+	switch {
+	case strings.HasPrefix(endpoint, "file:"):
+		cfg.FileStoragePath = strings.TrimPrefix(endpoint, "file:")
+		if strings.TrimSpace(cfg.FileStoragePath) == "" {
+			return nil, ErrNoFileStorage
+		}
+		repo, err = storage.NewDictionary(cfg, wg)
+	case strings.HasPrefix(endpoint, "postgres://") || strings.HasPrefix(endpoint, "postgresql://"):
+		cfg.DBConnectionString = endpoint
+		cfg.FileStoragePath = ""
+		repo, err = storage.NewPostgresStorage(cfg, wg)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrDatabaseUnavailable, err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEndpoint, endpoint)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	transfer, ok := repo.(migrationStorage)
+	if !ok {
+		repo.Close()
+		return nil, storage.ErrTransferUnsupported
+	}
+	return transfer, nil
+}
+
+// func loadCheckpoint reads the progress of the migration, missing file starts the migration from the beginning.
+func loadCheckpoint(filename string, migration string) (*migrationCheckpoint, error) {
+	checkpoint := &migrationCheckpoint{Migration: migration}
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, checkpoint); err != nil {
+		return nil, fmt.Errorf("checkpoint %s: %w", filename, err)
+	}
+	if checkpoint.Migration != migration {
+		return nil, fmt.Errorf("%w: remove %s to start the migration again", ErrCheckpointMismatch, filename)
+	}
+	return checkpoint, nil
+}
+
+// func saveCheckpoint replaces the checkpoint file, the new file is renamed over the old one.
+func saveCheckpoint(filename string, checkpoint *migrationCheckpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+
+	tmp := filename + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 
@@ -22,6 +23,14 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// service commands, example: shortener -d <dsn> migrate up
+	if flag.NArg() > 0 {
+		if err = runCommand(cfg, flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// link flags
 	fmt.Println("Build version:", buildVersion)
 	fmt.Println("Build date:", buildDate)
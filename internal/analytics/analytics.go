@@ -0,0 +1,187 @@
+// Package analytics records short URL redirects and builds click statistics.
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alexkopcak/shortener/internal/config"
+)
+
+const (
+	dateLayout        = "2006-01-02" // per-day statistics date format
+	defaultBufferSize = 4096         // clicks buffer size used if the configured one is not positive
+)
+
+type (
+	// Click represents a single short URL redirect.
+	Click struct {
+		Timestamp time.Time
+		ShortURL  string
+		Referrer  string
+		UserAgent string
+		ClientIP  string
+	}
+
+	// DailyClicks represents clicks count at the day.
+	DailyClicks struct {
+		Date   string `json:"date"`
+		Clicks int64  `json:"clicks"`
+	}
+
+	// Stats represents total and per-day clicks count of the short URL.
+	Stats struct {
+		ShortURL string        `json:"short_url"`
+		Daily    []DailyClicks `json:"daily"`
+		Total    int64         `json:"total"`
+	}
+
+	// Store represents clicks storage interface.
+	Store interface {
+		SaveClicks(ctx context.Context, clicks []Click) error
+		GetStats(ctx context.Context, shortURL string) (Stats, error)
+		Close() error
+	}
+)
+
+// InitializeStore implements the choice of clicks storage depending on the configuration.
+// the error is returned if the database is configured but not available, the clicks would be lost on restart otherwise.
+func InitializeStore(cfg config.Config) (Store, error) {
+	if strings.TrimSpace(cfg.DBConnectionString) == "" {
+		return NewMemoryStore(), nil
+	}
+
+	db, err := sql.Open("pgx", cfg.DBConnectionString)
+	if err != nil {
+		return nil, fmt.Errorf("clicks store: %w", err)
+	}
+
+	store, err := NewPostgresStore(db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("clicks store: %w", err)
+	}
+	return store, nil
+}
+
+// Recorder is an asynchronous buffered clicks pipeline.
+// Clicks are written to the store by a background worker in batches,
+// when the buffer is full new clicks are dropped, so the redirect is never slowed down.
+// The clicks recorded after Close are dropped too.
+type Recorder struct {
+	store         Store
+	clicks        chan Click
+	wg            sync.WaitGroup
+	mu            sync.RWMutex // Record holds the read lock, so Close never closes the channel under a send
+	closed        bool
+	batchSize     int
+	flushInterval time.Duration
+	dropped       uint64
+}
+
+// NewRecorder creates clicks pipeline and starts its worker.
+func NewRecorder(store Store, cfg config.Config) *Recorder {
+	r := &Recorder{
+		store:         store,
+		batchSize:     cfg.ClicksBatchSize,
+		flushInterval: cfg.ClicksFlushInterval,
+	}
+	size := cfg.ClicksBufferSize
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	r.clicks = make(chan Click, size)
+	if r.batchSize <= 0 {
+		r.batchSize = 1
+	}
+	if r.flushInterval <= 0 {
+		r.flushInterval = time.Second
+	}
+
+	r.wg.Add(1)
+	go r.worker()
+	return r
+}
+
+// Record puts the click to the pipeline buffer without blocking, returns false if the click was dropped.
+func (r *Recorder) Record(click Click) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed {
+		atomic.AddUint64(&r.dropped, 1)
+		return false
+	}
+	select {
+	case r.clicks <- click:
+		return true
+	default:
+		atomic.AddUint64(&r.dropped, 1)
+		return false
+	}
+}
+
+// Dropped returns count of clicks dropped because of the full buffer or the closed pipeline.
+func (r *Recorder) Dropped() uint64 {
+	return atomic.LoadUint64(&r.dropped)
+}
+
+// Stats returns clicks statistics of the short URL.
+func (r *Recorder) Stats(ctx context.Context, shortURL string) (Stats, error) {
+	return r.store.GetStats(ctx, shortURL)
+}
+
+// Close stops accepting clicks, flushes the buffer and closes the store.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.clicks)
+	r.mu.Unlock()
+
+	r.wg.Wait()
+	return r.store.Close()
+}
+
+func (r *Recorder) worker() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Click, 0, r.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := r.store.SaveClicks(context.Background(), batch); err != nil {
+			log.Printf("analytics: %d clicks are lost: %v", len(batch), err)
+		}
+		batch = make([]Click, 0, r.batchSize)
+	}
+
+	for {
+		select {
+		case click, ok := <-r.clicks:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, click)
+			if len(batch) >= r.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package analytics
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// MemoryStore is a memory clicks storage implementation, it keeps per-day clicks count only.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	clicks map[string]map[string]int64
+}
+
+// NewMemoryStore creates a new memory clicks storage.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		clicks: make(map[string]map[string]int64),
+	}
+}
+
+// SaveClicks adds clicks to per-day counters.
+func (m *MemoryStore) SaveClicks(ctx context.Context, clicks []Click) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, v := range clicks {
+		daily, ok := m.clicks[v.ShortURL]
+		if !ok {
+			daily = make(map[string]int64)
+			m.clicks[v.ShortURL] = daily
+		}
+		daily[v.Timestamp.UTC().Format(dateLayout)]++
+	}
+	return nil
+}
+
+// GetStats returns total and per-day clicks count of the short URL ordered by date.
+func (m *MemoryStore) GetStats(ctx context.Context, shortURL string) (Stats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stats := Stats{
+		ShortURL: shortURL,
+		Daily:    []DailyClicks{},
+	}
+	for date, cnt := range m.clicks[shortURL] {
+		stats.Daily = append(stats.Daily, DailyClicks{Date: date, Clicks: cnt})
+		stats.Total += cnt
+	}
+	sort.Slice(stats.Daily, func(i, j int) bool {
+		return stats.Daily[i].Date < stats.Daily[j].Date
+	})
+	return stats, nil
+}
+
+// Close interface plug.
+func (m *MemoryStore) Close() error {
+	return nil
+}
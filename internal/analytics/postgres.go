@@ -0,0 +1,91 @@
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	_ "github.com/jackc/pgx/v4/stdlib"
+)
+
+// ErrNoClicksTable - the clicks table is not created, postgres migrations must be applied first.
+var ErrNoClicksTable = errors.New("clicks table does not exist")
+
+// PostgresStore is a postgres clicks storage implementation.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore creates a new postgres clicks storage, the clicks table is created by the storage migrations.
+func NewPostgresStore(db *sql.DB) (*PostgresStore, error) {
+	var exists bool
+	err := db.QueryRowContext(context.Background(), "SELECT to_regclass('clicks') IS NOT NULL;").Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNoClicksTable
+	}
+
+	return &PostgresStore{db: db}, nil
+}
+
+// SaveClicks writes clicks to the postgres database in one transaction.
+func (ps *PostgresStore) SaveClicks(ctx context.Context, clicks []Click) error {
+	tx, err := ps.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx,
+		"INSERT INTO clicks (short_url, clicked_at, referrer, user_agent, client_ip) VALUES ($1, $2, $3, $4, $5);")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, v := range clicks {
+		if _, err = stmt.ExecContext(ctx, v.ShortURL, v.Timestamp, v.Referrer, v.UserAgent, v.ClientIP); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetStats returns total and per-day clicks count of the short URL ordered by date.
+func (ps *PostgresStore) GetStats(ctx context.Context, shortURL string) (Stats, error) {
+	stats := Stats{
+		ShortURL: shortURL,
+		Daily:    []DailyClicks{},
+	}
+
+	rows, err := ps.db.QueryContext(ctx,
+		"SELECT (clicked_at AT TIME ZONE 'UTC')::date AS day, COUNT(*) "+
+			"FROM clicks "+
+			"WHERE short_url = $1 "+
+			"GROUP BY day ORDER BY day;",
+		shortURL)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var day time.Time
+		item := DailyClicks{}
+		if err = rows.Scan(&day, &item.Clicks); err != nil {
+			return stats, err
+		}
+		item.Date = day.Format(dateLayout)
+		stats.Daily = append(stats.Daily, item)
+		stats.Total += item.Clicks
+	}
+	return stats, rows.Err()
+}
+
+// Close close postgres connection.
+func (ps *PostgresStore) Close() error {
+	return ps.db.Close()
+}
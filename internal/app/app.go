@@ -24,10 +24,12 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 
+	"github.com/alexkopcak/shortener/internal/analytics"
 	"github.com/alexkopcak/shortener/internal/config"
 	handlersgrpc "github.com/alexkopcak/shortener/internal/handlers/grpchandlers"
 	pb "github.com/alexkopcak/shortener/internal/handlers/grpchandlers/proto"
 	handlers "github.com/alexkopcak/shortener/internal/handlers/resthandlers"
+	"github.com/alexkopcak/shortener/internal/jobs"
 	"github.com/alexkopcak/shortener/internal/storage"
 )
 
@@ -42,7 +44,9 @@ type App struct {
 	cfg        *config.Config
 	restServer *http.Server
 	grpcServer *grpc.Server
-	dChannel   chan *storage.DeletedShortURLValues
+	deletes    *jobs.Queue
+	clicks     *analytics.Recorder
+	codes      storage.CodeGenerator // shared by the REST and gRPC handlers
 }
 
 func NewApp(conf config.Config) *App {
@@ -53,14 +57,36 @@ func NewApp(conf config.Config) *App {
 
 func (a *App) Run() error {
 	a.wg = &sync.WaitGroup{}
-	a.dChannel = make(chan *storage.DeletedShortURLValues)
 
-	// Repository
+	// Short URL values generator
 	var err error
-	a.repository, err = storage.InitializeStorage(*a.cfg, a.wg, a.dChannel)
+	a.codes, err = storage.NewCodeGenerator(a.cfg.ShortURLStrategy, a.cfg.ShortURLLength)
+	if err != nil {
+		return err
+	}
+
+	// Repository
+	a.repository, err = storage.InitializeStorage(*a.cfg, a.wg)
+	if err != nil {
+		return err
+	}
+
+	// Links deletion jobs
+	jobStore, err := jobs.InitializeStore(*a.cfg)
 	if err != nil {
+		a.repository.Close()
 		return err
 	}
+	a.deletes = jobs.NewQueue(jobStore, a.repository, *a.cfg)
+
+	// Clicks analytics
+	clickStore, err := analytics.InitializeStore(*a.cfg)
+	if err != nil {
+		a.deletes.Close()
+		a.repository.Close()
+		return err
+	}
+	a.clicks = analytics.NewRecorder(clickStore, *a.cfg)
 
 	// the channel notifying about the closure of connections
 	iddleConnsClosed := make(chan struct{})
@@ -75,14 +101,19 @@ func (a *App) Run() error {
 		close(iddleConnsClosed)
 	}()
 
+	// the servers finish the running requests before the services they use are closed
+	stopped := sync.WaitGroup{}
+	stopped.Add(2)
 	go func() {
+		defer stopped.Done()
 		<-iddleConnsClosed
-		if err = a.restServer.Shutdown(context.Background()); err != nil {
+		if err := a.restServer.Shutdown(context.Background()); err != nil {
 			log.Printf("HTTP server Shutdown: %v", err)
 		}
 	}()
 
 	go func() {
+		defer stopped.Done()
 		<-iddleConnsClosed
 		if a.grpcServer != nil {
 			a.grpcServer.GracefulStop()
@@ -91,11 +122,22 @@ func (a *App) Run() error {
 
 	// start servers and wait
 	a.start()
+	stopped.Wait()
+
+	// the running deletion attempts are finished before the repository is closed
+	if err = a.deletes.Close(); err != nil {
+		log.Printf("deletion jobs shutdown: %v", err)
+	}
+
+	if err = a.clicks.Close(); err != nil {
+		log.Printf("analytics shutdown: %v", err)
+	}
 
 	err = a.repository.Close()
+	// the repository background workers stop when it is closed
+	a.wg.Wait()
 
 	close(sigint)
-	close(a.dChannel)
 
 	log.Println("server shutdown gracefully")
 	return err
@@ -107,30 +149,32 @@ func (a *App) start() {
 			log.Fatal(err)
 		}
 	}
-	a.wg.Add(1)
+	// the servers have their own wait group, the repository workers run until the repository is closed
+	servers := sync.WaitGroup{}
+	servers.Add(1)
 	go func() {
 		err := a.startREST()
 		if err != http.ErrServerClosed {
 			log.Fatalf("HTTP server ListenAndServe: %v", err)
 		}
-		a.wg.Done()
+		servers.Done()
 	}()
-	a.wg.Add(1)
+	servers.Add(1)
 	go func() {
 		err := a.startGRPC()
 		if err != nil && err != grpc.ErrServerStopped {
 			log.Fatalf("gRPC server ListenAndServe: %v", err)
 		}
-		a.wg.Done()
+		servers.Done()
 	}()
-	a.wg.Wait()
+	servers.Wait()
 }
 
 func (a *App) startREST() error {
 	//HTTP Server
 	a.restServer = &http.Server{
 		Addr:    a.cfg.ServerAddr,
-		Handler: handlers.NewURLHandler(a.repository, *a.cfg, a.dChannel),
+		Handler: handlers.NewURLHandler(a.repository, a.codes, *a.cfg, a.deletes, a.clicks),
 	}
 	var err error
 
@@ -172,7 +216,7 @@ func (a *App) startGRPC() error {
 	)
 
 	pb.RegisterShortenerServer(a.grpcServer,
-		handlersgrpc.NewGRPCHandler(&a.repository, *a.cfg, a.dChannel))
+		handlersgrpc.NewGRPCHandler(&a.repository, a.codes, *a.cfg, a.deletes, a.clicks))
 
 	log.Printf("grpc server start on %v", a.cfg.GrpcAddr)
 	return a.grpcServer.Serve(listen)
@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/caarlos0/env"
 )
@@ -23,6 +24,44 @@ type Config struct {
 	TrustedSubnet      string `json:"trusted_subnet" env:"TRUSTED_SUBNET"`
 	GrpcAddr           string `json:"grpc__server_address" env:"GRPC_SERVER_ADDRESS"`
 	EnableHTTPS        bool   `json:"enable_https" env:"ENABLE_HTTPS"`
+
+	ShortURLStrategy string `json:"short_url_strategy" env:"SHORT_URL_STRATEGY"`
+	ShortURLLength   int    `json:"short_url_length" env:"SHORT_URL_LENGTH"`
+
+	ExpiredReapInterval time.Duration `json:"expired_reap_interval" env:"EXPIRED_REAP_INTERVAL"`
+	CompactInterval     time.Duration `json:"compact_interval" env:"COMPACT_INTERVAL"`
+	FileSyncPolicy      string        `json:"file_sync_policy" env:"FILE_SYNC_POLICY"`
+	FileSyncInterval    time.Duration `json:"file_sync_interval" env:"FILE_SYNC_INTERVAL"`
+	FileTailRecovery    bool          `json:"file_tail_recovery" env:"FILE_TAIL_RECOVERY"`
+	CacheSize           int           `json:"cache_size" env:"CACHE_SIZE"`
+	CacheTTL            time.Duration `json:"cache_ttl" env:"CACHE_TTL"`
+	CacheNegativeTTL    time.Duration `json:"cache_negative_ttl" env:"CACHE_NEGATIVE_TTL"`
+	BloomMemory         int           `json:"bloom_memory" env:"BLOOM_MEMORY"`
+	BloomFalsePositive  float64       `json:"bloom_false_positive" env:"BLOOM_FALSE_POSITIVE"`
+	// BloomRebuildInterval is the period of the Bloom filter rebuild, the rebuild makes the links
+	// created by other instances visible. The filter is not enabled for postgres without it.
+	BloomRebuildInterval time.Duration `json:"bloom_rebuild_interval" env:"BLOOM_REBUILD_INTERVAL"`
+	ClicksFlushInterval  time.Duration `json:"clicks_flush_interval" env:"CLICKS_FLUSH_INTERVAL"`
+	ClicksBufferSize     int           `json:"clicks_buffer_size" env:"CLICKS_BUFFER_SIZE"`
+	ClicksBatchSize      int           `json:"clicks_batch_size" env:"CLICKS_BATCH_SIZE"`
+	AccessFlushInterval  time.Duration `json:"access_flush_interval" env:"ACCESS_FLUSH_INTERVAL"`
+
+	DeletedRetention     time.Duration `json:"deleted_retention" env:"DELETED_RETENTION"`
+	PurgeInterval        time.Duration `json:"purge_interval" env:"PURGE_INTERVAL"`
+	PurgeBatchSize       int           `json:"purge_batch_size" env:"PURGE_BATCH_SIZE"`
+	ReusePurgedShortURLs bool          `json:"reuse_purged_short_urls" env:"REUSE_PURGED_SHORT_URLS"`
+
+	DeleteQueueSize    int           `json:"delete_queue_size" env:"DELETE_QUEUE_SIZE"`
+	DeleteWorkers      int           `json:"delete_workers" env:"DELETE_WORKERS"`
+	DeleteMaxAttempts  int           `json:"delete_max_attempts" env:"DELETE_MAX_ATTEMPTS"`
+	DeleteRetryBackoff time.Duration `json:"delete_retry_backoff" env:"DELETE_RETRY_BACKOFF"`
+	DeleteJobRetention time.Duration `json:"delete_job_retention" env:"DELETE_JOB_RETENTION"`
+
+	ImportChunkSize int `json:"import_chunk_size" env:"IMPORT_CHUNK_SIZE"`
+
+	LinkAccessTTL       time.Duration `json:"link_access_ttl" env:"LINK_ACCESS_TTL"`
+	PasswordMaxFailures int           `json:"password_max_failures" env:"PASSWORD_MAX_FAILURES"`
+	PasswordLockout     time.Duration `json:"password_lockout" env:"PASSWORD_LOCKOUT"`
 }
 
 const (
@@ -38,6 +77,36 @@ func (c *Config) SetDefaultValues() {
 	c.EnableHTTPS = false
 	c.TrustedSubnet = ""
 	c.GrpcAddr = ""
+	c.ShortURLStrategy = "random"
+	c.ShortURLLength = 5
+	c.ExpiredReapInterval = time.Minute
+	c.CompactInterval = time.Hour
+	c.FileSyncPolicy = "interval"
+	c.FileSyncInterval = 100 * time.Millisecond
+	c.FileTailRecovery = true
+	c.CacheSize = 0
+	c.CacheTTL = time.Minute
+	c.CacheNegativeTTL = 10 * time.Second
+	c.BloomMemory = 0
+	c.BloomFalsePositive = 0.01
+	c.BloomRebuildInterval = 0
+	c.ClicksFlushInterval = time.Second
+	c.ClicksBufferSize = 4096
+	c.ClicksBatchSize = 100
+	c.AccessFlushInterval = 5 * time.Second
+	c.DeletedRetention = 30 * 24 * time.Hour
+	c.PurgeInterval = time.Hour
+	c.PurgeBatchSize = 1000
+	c.ReusePurgedShortURLs = false
+	c.DeleteQueueSize = 1000
+	c.DeleteWorkers = 3
+	c.DeleteMaxAttempts = 5
+	c.DeleteRetryBackoff = time.Second
+	c.DeleteJobRetention = 24 * time.Hour
+	c.ImportChunkSize = 100
+	c.LinkAccessTTL = 10 * time.Minute
+	c.PasswordMaxFailures = 5
+	c.PasswordLockout = 15 * time.Minute
 }
 
 func NewConfig() (Config, error) {
@@ -63,6 +132,10 @@ func NewConfig() (Config, error) {
 		return cfg, err
 	}
 
+	if err = cfg.CheckQueueSizes(); err != nil {
+		return cfg, err
+	}
+
 	if err = cfg.ConfigFileExsistButNotLoaded(); err != nil {
 		return cfg, err
 	}
@@ -117,6 +190,17 @@ func (c *Config) CheckURLvalueScheme() error {
 	return nil
 }
 
+// CheckQueueSizes rejects negative buffer sizes, zero size means the default one.
+func (c *Config) CheckQueueSizes() error {
+	if c.DeleteQueueSize < 0 {
+		return fmt.Errorf("delete queue size must not be negative: %d", c.DeleteQueueSize)
+	}
+	if c.ClicksBufferSize < 0 {
+		return fmt.Errorf("clicks buffer size must not be negative: %d", c.ClicksBufferSize)
+	}
+	return nil
+}
+
 func (c *Config) GetFlagConfiguration() {
 	// flags configuration
 	flag.StringVar(&c.ServerAddr, "a", c.ServerAddr, "Server address, example ip:port")
@@ -6,13 +6,18 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"time"
 
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 
+	"github.com/alexkopcak/shortener/internal/analytics"
 	"github.com/alexkopcak/shortener/internal/config"
 	handlershelper "github.com/alexkopcak/shortener/internal/handlers"
 	pb "github.com/alexkopcak/shortener/internal/handlers/grpchandlers/proto"
+	"github.com/alexkopcak/shortener/internal/jobs"
 	"github.com/alexkopcak/shortener/internal/storage"
 )
 
@@ -20,7 +25,10 @@ type (
 	GRPCHandler struct {
 		pb.UnimplementedShortenerServer
 		trustedNet *net.IPNet
-		dChannel   chan *storage.DeletedShortURLValues
+		deletes    *jobs.Queue
+		clicks     *analytics.Recorder
+		passwords  *handlershelper.PasswordLimiter
+		codes      storage.CodeGenerator
 		repo       storage.Storage
 		cfg        *config.Config
 	}
@@ -32,13 +40,16 @@ const (
 	keyPrincipalID key = iota
 )
 
-// NewGRPCHandler create handler object.
-func NewGRPCHandler(store *storage.Storage, conf config.Config, dChan chan *storage.DeletedShortURLValues) *GRPCHandler {
+// NewGRPCHandler create handler object, codes generates the short URL values.
+func NewGRPCHandler(store *storage.Storage, codes storage.CodeGenerator, conf config.Config, deletes *jobs.Queue, clicks *analytics.Recorder) *GRPCHandler {
 	return &GRPCHandler{
 		cfg:        &conf,
 		repo:       *store,
 		trustedNet: handlershelper.SetTrustedSubnet(conf.TrustedSubnet),
-		dChannel:   dChan,
+		deletes:    deletes,
+		clicks:     clicks,
+		passwords:  handlershelper.NewPasswordLimiter(conf.PasswordMaxFailures, conf.PasswordLockout),
+		codes:      codes,
 	}
 }
 
@@ -53,10 +64,19 @@ func (g *GRPCHandler) Login(ctx context.Context, in *pb.Empty) (*pb.Token, error
 // GetURL obtains OriginalURL for ShortURL value
 func (g *GRPCHandler) GetURL(ctx context.Context, in *pb.URLRequest) (*pb.URLResponse, error) {
 	longURLValue, err := g.repo.GetURL(ctx, in.Value)
+	if errors.Is(err, storage.ErrPasswordRequired) {
+		longURLValue, err = g.checkURLPassword(ctx, in)
+	}
+	if errors.Is(err, storage.ErrClicksLimited) {
+		err = g.repo.ConsumeClick(ctx, in.Value)
+	}
 	if err != nil {
-		if errors.Is(err, storage.ErrNotExistRecord) {
+		if linkGone(err) {
 			return nil, status.Errorf(codes.NotFound, "url %s not found", in.Value)
 		}
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
 		return nil, status.Errorf(codes.Unimplemented, "")
 	}
 
@@ -65,6 +85,50 @@ func (g *GRPCHandler) GetURL(ctx context.Context, in *pb.URLRequest) (*pb.URLRes
 	}, nil
 }
 
+// checkURLPassword resolves the link protected by password, the peer address is locked out after repeated wrong passwords
+func (g *GRPCHandler) checkURLPassword(ctx context.Context, in *pb.URLRequest) (string, error) {
+	if in.Password == "" {
+		return "", status.Errorf(codes.PermissionDenied, "url %s is protected by password", in.Value)
+	}
+
+	client := peerAddress(ctx)
+	if wait := g.passwords.Locked(client, time.Now()); wait > 0 {
+		return "", status.Errorf(codes.ResourceExhausted, "too many wrong passwords, try again in %v", wait.Round(time.Second))
+	}
+
+	longURLValue, err := g.repo.CheckURLPassword(ctx, in.Value, in.Password)
+	if errors.Is(err, storage.ErrPasswordMismatch) {
+		if wait := g.passwords.Fail(client, time.Now()); wait > 0 {
+			return "", status.Errorf(codes.ResourceExhausted, "too many wrong passwords, try again in %v", wait.Round(time.Second))
+		}
+		return "", status.Errorf(codes.PermissionDenied, "%v", err)
+	}
+	if err == nil || errors.Is(err, storage.ErrClicksLimited) {
+		g.passwords.Reset(client)
+	}
+	return longURLValue, err
+}
+
+// linkGone checks that the storage error means the link was deleted, expired or used up
+func linkGone(err error) bool {
+	return errors.Is(err, storage.ErrNotExistRecord) ||
+		errors.Is(err, storage.ErrExpiredRecord) ||
+		errors.Is(err, storage.ErrClicksExhausted)
+}
+
+// peerAddress returns the host of the grpc client address
+func peerAddress(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
 // GetAllURL obtains all URLs saved by the user in the format of pairs of OriginURL and ShortURL
 func (g *GRPCHandler) GetAllURL(ctx context.Context, in *pb.Empty) (*pb.AnyURLResponse, error) {
 	userID, ok := ctx.Value(keyPrincipalID).(int32)
@@ -80,10 +144,7 @@ func (g *GRPCHandler) GetAllURL(ctx context.Context, in *pb.Empty) (*pb.AnyURLRe
 	items := make([]*pb.AnyURLResponse_ShortOriginalURLPairs, 0, len(result))
 	if len(result) > 0 {
 		for _, v := range result {
-			items = append(items, &pb.AnyURLResponse_ShortOriginalURLPairs{
-				ShortURL:    v.ShortURL,
-				OriginalURL: v.OriginalURL,
-			})
+			items = append(items, shortOriginalURLPair(v))
 		}
 	}
 
@@ -93,6 +154,43 @@ func (g *GRPCHandler) GetAllURL(ctx context.Context, in *pb.Empty) (*pb.AnyURLRe
 	}, nil
 }
 
+// ListURLs obtains a page of URLs saved by the user, sorted and filtered
+func (g *GRPCHandler) ListURLs(ctx context.Context, in *pb.ListURLsRequest) (*pb.ListURLsResponse, error) {
+	userID, ok := ctx.Value(keyPrincipalID).(int32)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "unknown user")
+	}
+	if in.Limit < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must be a non-negative number")
+	}
+
+	page, err := g.repo.ListUserURL(ctx, g.cfg.BaseURL, userID, storage.ListOptions{
+		Cursor:   in.Cursor,
+		Sort:     in.Sort,
+		Domain:   in.Domain,
+		Contains: in.Contains,
+		Limit:    int(in.Limit),
+		Desc:     in.Desc,
+	})
+	if errors.Is(err, storage.ErrListSortInvalid) || errors.Is(err, storage.ErrCursorInvalid) {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error ListUserURL func %v", err)
+	}
+
+	items := make([]*pb.AnyURLResponse_ShortOriginalURLPairs, 0, len(page.Items))
+	for _, v := range page.Items {
+		items = append(items, shortOriginalURLPair(v))
+	}
+
+	return &pb.ListURLsResponse{
+		Count:      int32(len(items)),
+		Values:     items,
+		NextCursor: page.NextCursor,
+	}, nil
+}
+
 // PostURL obtain ShortURL value for OriginalURL and save it at storage
 func (g *GRPCHandler) PostURL(ctx context.Context, in *pb.URLRequest) (*pb.URLResponse, error) {
 	userID, ok := ctx.Value(keyPrincipalID).(int32)
@@ -100,7 +198,14 @@ func (g *GRPCHandler) PostURL(ctx context.Context, in *pb.URLRequest) (*pb.URLRe
 		return nil, status.Errorf(codes.Unauthenticated, "unknown user")
 	}
 
-	result, err := g.repo.AddURL(ctx, in.Value, storage.ShortURLGenerator(), userID)
+	var err error
+	var result string
+	opts := urlOptions(in.ExpiresAt, in.Ttl, in.Password, in.MaxClicks)
+	if in.Alias != "" {
+		result, err = g.repo.AddURL(ctx, in.Value, in.Alias, userID, opts)
+	} else {
+		result, err = storage.AddGeneratedURL(ctx, g.repo, g.codes, in.Value, userID, opts)
+	}
 	if err != nil {
 		if errors.Is(err, storage.ErrDuplicateRecord) {
 			return &pb.URLResponse{
@@ -108,7 +213,7 @@ func (g *GRPCHandler) PostURL(ctx context.Context, in *pb.URLRequest) (*pb.URLRe
 			}, status.Errorf(codes.AlreadyExists, "duplicated value")
 
 		}
-		return nil, status.Errorf(codes.Internal, "internal error: %v", err)
+		return nil, shortURLError(err)
 	}
 
 	return &pb.URLResponse{
@@ -116,6 +221,35 @@ func (g *GRPCHandler) PostURL(ctx context.Context, in *pb.URLRequest) (*pb.URLRe
 	}, nil
 }
 
+// urlOptions converts grpc link expiration settings, password and click limit to storage link options
+func urlOptions(expiresAt int64, ttl int64, password string, maxClicks int64) storage.URLOptions {
+	opts := storage.URLOptions{
+		TTL:       ttl,
+		Password:  password,
+		MaxClicks: maxClicks,
+	}
+	if expiresAt != 0 {
+		value := time.Unix(expiresAt, 0)
+		opts.ExpiresAt = &value
+	}
+	return opts
+}
+
+// shortURLError converts the storage error on short URL value creation to grpc status error
+func shortURLError(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrShortURLTaken):
+		return status.Errorf(codes.AlreadyExists, "%v", err)
+	case errors.Is(err, storage.ErrAliasInvalid),
+		errors.Is(err, storage.ErrAliasReserved),
+		errors.Is(err, storage.ErrExpirationInvalid),
+		errors.Is(err, storage.ErrPasswordInvalid),
+		errors.Is(err, storage.ErrMaxClicksInvalid):
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	return status.Errorf(codes.Internal, "internal error: %v", err)
+}
+
 // PostAPIurl obtain ShortURL value for OriginalURL and save it at storage
 func (g *GRPCHandler) PostAPIurl(ctx context.Context, in *pb.URLRequest) (*pb.URLResponse, error) {
 	return g.PostURL(ctx, in)
@@ -133,13 +267,15 @@ func (g *GRPCHandler) PostAPIBatch(ctx context.Context, in *pb.BatchRequestArray
 		item := storage.BatchRequest{
 			CorrelationID: val.CorrelationId,
 			OriginalURL:   val.OriginalUrl,
+			Alias:         val.Alias,
+			URLOptions:    urlOptions(val.ExpiresAt, val.Ttl, val.Password, val.MaxClicks),
 		}
 		batchReqArray = append(batchReqArray, item)
 	}
 
-	result, err := g.repo.PostAPIBatch(ctx, &batchReqArray, g.cfg.BaseURL, userID)
+	result, err := storage.PostGeneratedBatch(ctx, g.repo, g.codes, &batchReqArray, g.cfg.BaseURL, userID)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "internal error: %v", err)
+		return nil, shortURLError(err)
 	}
 
 	responseArray := make([]*pb.BatchResponseArray_BatchResponse, 0, len(*result))
@@ -147,6 +283,8 @@ func (g *GRPCHandler) PostAPIBatch(ctx context.Context, in *pb.BatchRequestArray
 		item := pb.BatchResponseArray_BatchResponse{
 			CorrelationId: v.CorrelationID,
 			ShortUrl:      v.ShortURL,
+			Status:        v.Status,
+			Error:         v.Error,
 		}
 		responseArray = append(responseArray, &item)
 	}
@@ -157,21 +295,249 @@ func (g *GRPCHandler) PostAPIBatch(ctx context.Context, in *pb.BatchRequestArray
 	}, nil
 }
 
-// DeleteURLs delete stored URL values by ShortURL
-func (g *GRPCHandler) DeleteURLs(ctx context.Context, in *pb.AnyURLRequest) (*pb.Empty, error) {
+// DeleteURLs delete stored URL values by ShortURL, the values are deleted by the background job
+func (g *GRPCHandler) DeleteURLs(ctx context.Context, in *pb.AnyURLRequest) (*pb.DeleteJob, error) {
 	userID, ok := ctx.Value(keyPrincipalID).(int32)
 	if !ok {
 		return nil, status.Errorf(codes.Unauthenticated, "unknown user")
 	}
 
-	deletedURLs := &storage.DeletedShortURLValues{
+	job, err := g.deletes.Enqueue(ctx, &storage.DeletedShortURLValues{
 		ShortURLValues: in.Values,
 		UserIDValue:    userID,
+	})
+	if errors.Is(err, jobs.ErrQueueFull) || errors.Is(err, jobs.ErrQueueClosed) {
+		return nil, status.Errorf(codes.Unavailable, "%v", err)
 	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "delete urls: %v", err)
+	}
+	return deleteJob(job), nil
+}
 
-	g.dChannel <- deletedURLs
+// GetDeleteJob obtains the status of the user deletion job
+func (g *GRPCHandler) GetDeleteJob(ctx context.Context, in *pb.DeleteJobRequest) (*pb.DeleteJob, error) {
+	userID, ok := ctx.Value(keyPrincipalID).(int32)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "unknown user")
+	}
 
-	return &pb.Empty{}, nil
+	job, err := g.deletes.Job(ctx, in.Id, userID)
+	if errors.Is(err, jobs.ErrJobNotFound) {
+		return nil, status.Errorf(codes.NotFound, "job %s not found", in.Id)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "get delete job: %v", err)
+	}
+	return deleteJob(job), nil
+}
+
+// RestoreURLs restore deleted URL values by ShortURL
+func (g *GRPCHandler) RestoreURLs(ctx context.Context, in *pb.AnyURLRequest) (*pb.RestoreURLsResponse, error) {
+	userID, ok := ctx.Value(keyPrincipalID).(int32)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "unknown user")
+	}
+
+	restored, err := g.repo.RestoreUserURL(ctx, &storage.DeletedShortURLValues{
+		ShortURLValues: in.Values,
+		UserIDValue:    userID,
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "restore urls: %v", err)
+	}
+
+	return &pb.RestoreURLsResponse{
+		Count:  int32(len(restored)),
+		Values: restored,
+	}, nil
+}
+
+// UpdateURL point the user ShortURL value at the new OriginalURL value
+func (g *GRPCHandler) UpdateURL(ctx context.Context, in *pb.UpdateURLRequest) (*pb.URLVersion, error) {
+	userID, ok := ctx.Value(keyPrincipalID).(int32)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "unknown user")
+	}
+
+	version, err := g.repo.UpdateURL(ctx, in.ShortUrl, in.OriginalUrl, userID)
+	if err != nil {
+		return nil, linkEditError(err)
+	}
+	return urlVersion(version), nil
+}
+
+// GetURLHistory obtains the OriginalURL values history of the user ShortURL value
+func (g *GRPCHandler) GetURLHistory(ctx context.Context, in *pb.URLRequest) (*pb.URLHistoryResponse, error) {
+	userID, ok := ctx.Value(keyPrincipalID).(int32)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "unknown user")
+	}
+
+	history, err := g.repo.GetURLHistory(ctx, in.Value, userID)
+	if err != nil {
+		return nil, linkEditError(err)
+	}
+
+	result := &pb.URLHistoryResponse{
+		ShortUrl: in.Value,
+		Versions: make([]*pb.URLVersion, 0, len(history)),
+	}
+	for _, v := range history {
+		result.Versions = append(result.Versions, urlVersion(v))
+	}
+	return result, nil
+}
+
+// RollbackURL point the user ShortURL value at the OriginalURL value of the earlier version
+func (g *GRPCHandler) RollbackURL(ctx context.Context, in *pb.RollbackURLRequest) (*pb.URLVersion, error) {
+	userID, ok := ctx.Value(keyPrincipalID).(int32)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "unknown user")
+	}
+
+	version, err := storage.RollbackURL(ctx, g.repo, in.ShortUrl, int(in.Version), userID)
+	if err != nil {
+		return nil, linkEditError(err)
+	}
+	return urlVersion(version), nil
+}
+
+// shortOriginalURLPair converts the storage user link to the grpc message, unknown times are zero
+func shortOriginalURLPair(item storage.UserExportType) *pb.AnyURLResponse_ShortOriginalURLPairs {
+	return &pb.AnyURLResponse_ShortOriginalURLPairs{
+		ShortURL:       item.ShortURL,
+		OriginalURL:    item.OriginalURL,
+		CreatedAt:      unixTime(item.CreatedAt),
+		UpdatedAt:      unixTime(item.UpdatedAt),
+		LastAccessedAt: unixTime(item.LastAccessedAt),
+		ClicksLeft:     item.ClicksLeft,
+	}
+}
+
+// unixTime returns the unix timestamp in seconds, zero for the unknown time
+func unixTime(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	return t.Unix()
+}
+
+// urlVersion converts the storage link version to grpc message
+func urlVersion(version storage.URLVersion) *pb.URLVersion {
+	return &pb.URLVersion{
+		Version:     int32(version.Version),
+		OriginalUrl: version.OriginalURL,
+		EditedBy:    version.EditedBy,
+		EditedAt:    unixTime(version.EditedAt),
+	}
+}
+
+// deleteJob converts the deletion job to grpc message
+func deleteJob(job jobs.Job) *pb.DeleteJob {
+	return &pb.DeleteJob{
+		Id:        job.ID,
+		Status:    job.Status,
+		ShortUrls: job.ShortURLs,
+		Attempts:  int32(job.Attempts),
+		Error:     job.Error,
+		CreatedAt: job.CreatedAt.Unix(),
+		UpdatedAt: job.UpdatedAt.Unix(),
+	}
+}
+
+// linkEditError converts the storage error on link editing to grpc status error
+func linkEditError(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrNotExistRecord), errors.Is(err, storage.ErrVersionNotExist):
+		return status.Errorf(codes.NotFound, "%v", err)
+	case errors.Is(err, storage.ErrNotOwner):
+		return status.Errorf(codes.PermissionDenied, "%v", err)
+	case errors.Is(err, storage.ErrDuplicateRecord):
+		return status.Errorf(codes.AlreadyExists, "%v", err)
+	case errors.Is(err, storage.ErrURLInvalid):
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	return status.Errorf(codes.Internal, "internal error: %v", err)
+}
+
+// GetURLStats obtains clicks statistics of the user ShortURL value
+func (g *GRPCHandler) GetURLStats(ctx context.Context, in *pb.URLRequest) (*pb.URLStatsResponse, error) {
+	userID, ok := ctx.Value(keyPrincipalID).(int32)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "unknown user")
+	}
+
+	if g.clicks == nil {
+		return nil, status.Errorf(codes.Unimplemented, "analytics are disabled")
+	}
+
+	ownerID, err := g.repo.GetURLOwner(ctx, in.Value)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotExistRecord) {
+			return nil, status.Errorf(codes.NotFound, "url %s not found", in.Value)
+		}
+		return nil, status.Errorf(codes.Internal, "error GetURLOwner func %v", err)
+	}
+	if ownerID != userID {
+		return nil, status.Errorf(codes.PermissionDenied, "forbidden")
+	}
+
+	stats, err := g.clicks.Stats(ctx, in.Value)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "something went wrong %v", err)
+	}
+
+	daily := make([]*pb.URLStatsResponse_DailyClicks, 0, len(stats.Daily))
+	for _, v := range stats.Daily {
+		daily = append(daily, &pb.URLStatsResponse_DailyClicks{
+			Date:   v.Date,
+			Clicks: v.Clicks,
+		})
+	}
+
+	return &pb.URLStatsResponse{
+		ShortUrl: stats.ShortURL,
+		Total:    stats.Total,
+		Daily:    daily,
+	}, nil
+}
+
+// GetQRCode obtains QR code image of the full ShortURL
+func (g *GRPCHandler) GetQRCode(ctx context.Context, in *pb.QRCodeRequest) (*pb.QRCodeResponse, error) {
+	var size, margin string
+	if in.Size != 0 {
+		size = strconv.Itoa(int(in.Size))
+	}
+	if in.Margin != nil {
+		margin = strconv.Itoa(int(in.GetMargin()))
+	}
+	opts, err := handlershelper.ParseQROptions(in.Format, size, in.Level, margin)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	// rendering the code is not the link access
+	longURLValue, err := storage.PeekURL(ctx, g.repo, in.Value)
+	if errors.Is(err, storage.ErrPasswordRequired) || errors.Is(err, storage.ErrClicksLimited) {
+		// the code holds the ShortURL value, so the link protected by password keeps its password and the limited link keeps its clicks
+		err = nil
+	}
+	if err != nil && !linkGone(err) {
+		return nil, status.Errorf(codes.Internal, "error GetURL func %v", err)
+	}
+	if err != nil || longURLValue == "" {
+		return nil, status.Errorf(codes.NotFound, "url %s not found", in.Value)
+	}
+
+	image, contentType, err := handlershelper.RenderQRCode(g.cfg.BaseURL+"/"+in.Value, opts)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	return &pb.QRCodeResponse{
+		Image:       image,
+		ContentType: contentType,
+	}, nil
 }
 
 // GetInternalStats  get stats URLs and Users count
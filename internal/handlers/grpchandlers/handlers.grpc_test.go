@@ -7,15 +7,21 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 
 	"github.com/alexkopcak/shortener/client"
+	"github.com/alexkopcak/shortener/internal/analytics"
 	"github.com/alexkopcak/shortener/internal/config"
+	handlershelper "github.com/alexkopcak/shortener/internal/handlers"
 	pb "github.com/alexkopcak/shortener/internal/handlers/grpchandlers/proto"
+	"github.com/alexkopcak/shortener/internal/jobs"
 	"github.com/alexkopcak/shortener/internal/storage"
 )
 
@@ -30,8 +36,12 @@ var (
 		CookieAuthName: "id",
 		TrustedSubnet:  "10.0.0.0/8",
 		GrpcAddr:       ":8181",
+
+		PasswordMaxFailures: 2,
+		PasswordLockout:     time.Minute,
 	}
 	xRealIp = "10.0.12.3"
+	clicks  *analytics.Recorder
 )
 
 func init() {
@@ -44,15 +54,16 @@ func init() {
 	}
 	s = grpc.NewServer(opts...)
 
-	dChan := make(chan *storage.DeletedShortURLValues)
-
-	repo, err := storage.NewDictionary(cfg, &sync.WaitGroup{}, dChan)
+	repo, err := storage.NewDictionary(cfg, &sync.WaitGroup{})
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	pb.RegisterShortenerServer(s, NewGRPCHandler(&repo, cfg, dChan))
+	clicks = analytics.NewRecorder(analytics.NewMemoryStore(), cfg)
+	deletes := jobs.NewQueue(jobs.NewMemoryStore(), repo, config.Config{DeleteQueueSize: 10})
+
+	pb.RegisterShortenerServer(s, NewGRPCHandler(&repo, storage.NewRandomCodeGenerator(storage.DefaultCodeLength), cfg, deletes, clicks))
 	go func() {
 		if err := s.Serve(lis); err != nil {
 			log.Fatalf("Server exited with error: %v", err)
@@ -119,7 +130,7 @@ func TestGRPC(t *testing.T) {
 	require.EqualValues(t, 2, respRaw.Count)
 
 	postBatchRaw, err := client.PostAPIBatch(ctx, &pb.BatchRequestArray{
-		Count: 2,
+		Count: 4,
 		OriginalUrls: []*pb.BatchRequestArray_BatchRequest{
 			{
 				CorrelationId: "1",
@@ -127,28 +138,239 @@ func TestGRPC(t *testing.T) {
 			},
 			{
 				CorrelationId: "2",
-				OriginalUrl:   originalURL2,
+				OriginalUrl:   "http://original.batch.test/1",
+			},
+			{
+				CorrelationId: "3",
+				OriginalUrl:   "http://original.batch.test/2",
+			},
+			{
+				CorrelationId: "4",
+				OriginalUrl:   "not a url",
 			},
 		},
 	})
 	require.NoError(t, err)
-	require.EqualValues(t, 2, postBatchRaw.Count)
+	require.EqualValues(t, 4, postBatchRaw.Count)
+	require.Equal(t, storage.BatchStatusExists, postBatchRaw.ShortUrls[0].Status)
+	require.Equal(t, shortURLRaw.Value, postBatchRaw.ShortUrls[0].ShortUrl)
+	require.Equal(t, storage.BatchStatusCreated, postBatchRaw.ShortUrls[1].Status)
+	require.Equal(t, storage.BatchStatusCreated, postBatchRaw.ShortUrls[2].Status)
+	require.Equal(t, storage.BatchStatusInvalid, postBatchRaw.ShortUrls[3].Status)
+	require.NotEmpty(t, postBatchRaw.ShortUrls[3].Error)
 
-	_, err = client.DeleteURLs(ctx, &pb.AnyURLRequest{
+	deleteJob, err := client.DeleteURLs(ctx, &pb.AnyURLRequest{
 		Count:  1,
 		Values: []string{shortURL2},
 	})
 	require.NoError(t, err)
+	require.NotEmpty(t, deleteJob.Id)
+	require.Equal(t, []string{shortURL2}, deleteJob.ShortUrls)
+	require.Eventually(t, func() bool {
+		deleteJob, err = client.GetDeleteJob(ctx, &pb.DeleteJobRequest{Id: deleteJob.Id})
+		require.NoError(t, err)
+		return deleteJob.Status == jobs.StatusDone
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = client.GetDeleteJob(ctx, &pb.DeleteJobRequest{Id: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
 
 	respRaw2, err := client.GetAllURL(ctx, &pb.Empty{})
 	require.NoError(t, err)
-	require.EqualValues(t, 1, respRaw2.Count)
+	require.EqualValues(t, 3, respRaw2.Count)
 
 	require.NoError(t, err)
 
 	stats, err := client.GetInternalStats(ctx, &pb.Empty{})
 	require.NoError(t, err)
-	require.EqualValues(t, 2, stats.UrlsCount)
+	require.EqualValues(t, 3, stats.UrlsCount)
 	require.EqualValues(t, 1, stats.UsersCount)
+
+	// the deleted link is restored by its owner
+	require.Eventually(t, func() bool {
+		restoredRaw, err := client.RestoreURLs(ctx, &pb.AnyURLRequest{
+			Count:  1,
+			Values: []string{shortURL2},
+		})
+		return err == nil && restoredRaw.Count == 1
+	}, time.Second, 10*time.Millisecond)
+
+	respRaw2, err = client.GetAllURL(ctx, &pb.Empty{})
+	require.NoError(t, err)
+	require.EqualValues(t, 4, respRaw2.Count)
+	for _, v := range respRaw2.Values {
+		require.NotZero(t, v.CreatedAt)
+		require.GreaterOrEqual(t, v.UpdatedAt, v.CreatedAt)
+	}
+
+	// the user URLs are listed page by page
+	listed := 0
+	listRequest := &pb.ListURLsRequest{Limit: 3, Sort: "short_url"}
+	for {
+		pageRaw, err := client.ListURLs(ctx, listRequest)
+		require.NoError(t, err)
+		require.LessOrEqual(t, pageRaw.Count, listRequest.Limit)
+		listed += int(pageRaw.Count)
+		if pageRaw.NextCursor == "" {
+			break
+		}
+		listRequest.Cursor = pageRaw.NextCursor
+	}
+	require.Equal(t, 4, listed)
+
+	_, err = client.ListURLs(ctx, &pb.ListURLsRequest{Sort: "original_url"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// custom ShortURL value
+	aliasRaw, err := client.PostURL(ctx, &pb.URLRequest{
+		Value: "http://original.alias.test",
+		Alias: "grpc-alias",
+	})
+	require.NoError(t, err)
+	require.Equal(t, cfg.BaseURL+"/grpc-alias", aliasRaw.Value)
+
+	originalURLRaw, err = client.GetURL(ctx, &pb.URLRequest{
+		Value: "grpc-alias",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "http://original.alias.test", originalURLRaw.Value)
+
+	_, err = client.PostURL(ctx, &pb.URLRequest{
+		Value: "http://original.alias2.test",
+		Alias: "grpc-alias",
+	})
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	_, err = client.PostURL(ctx, &pb.URLRequest{
+		Value: "http://original.alias3.test",
+		Alias: "api",
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// the link destination is edited and rolled back by its owner
+	versionRaw, err := client.UpdateURL(ctx, &pb.UpdateURLRequest{
+		ShortUrl:    "grpc-alias",
+		OriginalUrl: "http://original.alias.edited.test",
+	})
+	require.NoError(t, err)
+	require.EqualValues(t, 2, versionRaw.Version)
+	require.NotZero(t, versionRaw.EditedAt)
+
+	_, err = client.UpdateURL(ctx, &pb.UpdateURLRequest{
+		ShortUrl:    "grpc-alias",
+		OriginalUrl: "not a url",
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.RollbackURL(ctx, &pb.RollbackURLRequest{ShortUrl: "grpc-alias", Version: 9})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	versionRaw, err = client.RollbackURL(ctx, &pb.RollbackURLRequest{ShortUrl: "grpc-alias", Version: 1})
+	require.NoError(t, err)
+	require.EqualValues(t, 3, versionRaw.Version)
+	require.Equal(t, "http://original.alias.test", versionRaw.OriginalUrl)
+
+	historyRaw, err := client.GetURLHistory(ctx, &pb.URLRequest{Value: "grpc-alias"})
+	require.NoError(t, err)
+	require.Len(t, historyRaw.Versions, 3)
+	require.Equal(t, "http://original.alias.edited.test", historyRaw.Versions[1].OriginalUrl)
+
+	// QR code image of the full ShortURL
+	qrRaw, err := client.GetQRCode(ctx, &pb.QRCodeRequest{Value: "grpc-alias"})
+	require.NoError(t, err)
+	require.Equal(t, "image/png", qrRaw.ContentType)
+	want, _, err := handlershelper.RenderQRCode(cfg.BaseURL+"/grpc-alias", handlershelper.QROptions{
+		Format: handlershelper.QRFormatPNG, Level: "M", Size: 256, Margin: 4,
+	})
+	require.NoError(t, err)
+	require.Equal(t, want, qrRaw.Image)
+
+	margin := int32(0)
+	qrRaw, err = client.GetQRCode(ctx, &pb.QRCodeRequest{Value: "grpc-alias", Format: "svg", Size: 300, Level: "H", Margin: &margin})
+	require.NoError(t, err)
+	require.Equal(t, "image/svg+xml", qrRaw.ContentType)
+	want, _, err = handlershelper.RenderQRCode(cfg.BaseURL+"/grpc-alias", handlershelper.QROptions{
+		Format: handlershelper.QRFormatSVG, Level: "H", Size: 300, Margin: 0,
+	})
+	require.NoError(t, err)
+	require.Equal(t, want, qrRaw.Image)
+
+	_, err = client.GetQRCode(ctx, &pb.QRCodeRequest{Value: "grpc-alias", Level: "X"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.GetQRCode(ctx, &pb.QRCodeRequest{Value: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// link protected by password
+	_, err = client.PostURL(ctx, &pb.URLRequest{
+		Value:    "http://protected.test",
+		Alias:    "grpc-protected",
+		Password: "open sesame",
+	})
+	require.NoError(t, err)
+
+	_, err = client.GetURL(ctx, &pb.URLRequest{Value: "grpc-protected"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	protectedRaw, err := client.GetURL(ctx, &pb.URLRequest{Value: "grpc-protected", Password: "open sesame"})
+	require.NoError(t, err)
+	require.Equal(t, "http://protected.test", protectedRaw.Value)
+
+	_, err = client.GetQRCode(ctx, &pb.QRCodeRequest{Value: "grpc-protected"})
+	require.NoError(t, err, "the code of the protected link holds the ShortURL only")
+
+	_, err = client.GetURL(ctx, &pb.URLRequest{Value: "grpc-protected", Password: "wrong"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.GetURL(ctx, &pb.URLRequest{Value: "grpc-protected", Password: "wrong"})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	_, err = client.GetURL(ctx, &pb.URLRequest{Value: "grpc-protected", Password: "open sesame"})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err), "the client is locked out")
+
+	_, err = client.PostURL(ctx, &pb.URLRequest{
+		Value:    "http://protected.long.test",
+		Password: strings.Repeat("p", 73),
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// link with max clicks
+	_, err = client.PostURL(ctx, &pb.URLRequest{
+		Value:     "http://once.test",
+		Alias:     "grpc-once",
+		MaxClicks: 1,
+	})
+	require.NoError(t, err)
+
+	onceRaw, err := client.GetURL(ctx, &pb.URLRequest{Value: "grpc-once"})
+	require.NoError(t, err)
+	require.Equal(t, "http://once.test", onceRaw.Value)
+	_, err = client.GetURL(ctx, &pb.URLRequest{Value: "grpc-once"})
+	require.Equal(t, codes.NotFound, status.Code(err), "the clicks are used up")
+
+	onceList, err := client.GetAllURL(ctx, &pb.Empty{})
+	require.NoError(t, err)
+	for _, v := range onceList.Values {
+		if v.ShortURL == cfg.BaseURL+"/grpc-once" {
+			require.NotNil(t, v.ClicksLeft)
+			require.Zero(t, v.GetClicksLeft())
+		} else {
+			require.Nil(t, v.ClicksLeft, "the link without limit has no clicks left")
+		}
+	}
+
+	_, err = client.PostURL(ctx, &pb.URLRequest{
+		Value:     "http://once.negative.test",
+		MaxClicks: -1,
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// clicks statistics
+	clicks.Record(analytics.Click{Timestamp: time.Now(), ShortURL: "grpc-alias"})
+	require.Eventually(t, func() bool {
+		urlStats, err := client.GetURLStats(ctx, &pb.URLRequest{Value: "grpc-alias"})
+		return err == nil && urlStats.Total == 1 && len(urlStats.Daily) == 1
+	}, 3*time.Second, 50*time.Millisecond)
+
+	_, err = client.GetURLStats(ctx, &pb.URLRequest{Value: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
 	s.GracefulStop()
 }
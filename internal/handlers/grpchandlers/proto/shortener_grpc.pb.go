@@ -28,16 +28,32 @@ type ShortenerClient interface {
 	GetURL(ctx context.Context, in *URLRequest, opts ...grpc.CallOption) (*URLResponse, error)
 	// Obtains all URLs saved by the user in the format of pairs of OriginURL and ShortURL
 	GetAllURL(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*AnyURLResponse, error)
+	// Obtains a page of URLs saved by the user, sorted and filtered
+	ListURLs(ctx context.Context, in *ListURLsRequest, opts ...grpc.CallOption) (*ListURLsResponse, error)
 	// Obtain ShortURL value for OriginalURL and save it at storage
 	PostURL(ctx context.Context, in *URLRequest, opts ...grpc.CallOption) (*URLResponse, error)
 	// Obtain ShortURL value for OriginalURL and save it at storage
 	PostAPIurl(ctx context.Context, in *URLRequest, opts ...grpc.CallOption) (*URLResponse, error)
 	// Obtain ShortURL values for OriginalURL at batch request and save values at storage
 	PostAPIBatch(ctx context.Context, in *BatchRequestArray, opts ...grpc.CallOption) (*BatchResponseArray, error)
-	// Delete stored URL values by ShortURL
-	DeleteURLs(ctx context.Context, in *AnyURLRequest, opts ...grpc.CallOption) (*Empty, error)
+	// Delete stored URL values by ShortURL, the values are deleted by the background job
+	DeleteURLs(ctx context.Context, in *AnyURLRequest, opts ...grpc.CallOption) (*DeleteJob, error)
+	// Obtains the status of the user deletion job
+	GetDeleteJob(ctx context.Context, in *DeleteJobRequest, opts ...grpc.CallOption) (*DeleteJob, error)
+	// Restore deleted URL values by ShortURL, only the links deleted within the retention period are restored
+	RestoreURLs(ctx context.Context, in *AnyURLRequest, opts ...grpc.CallOption) (*RestoreURLsResponse, error)
+	// Point the user ShortURL value at the new OriginalURL value
+	UpdateURL(ctx context.Context, in *UpdateURLRequest, opts ...grpc.CallOption) (*URLVersion, error)
+	// Obtains the OriginalURL values history of the user ShortURL value
+	GetURLHistory(ctx context.Context, in *URLRequest, opts ...grpc.CallOption) (*URLHistoryResponse, error)
+	// Point the user ShortURL value at the OriginalURL value of the earlier version
+	RollbackURL(ctx context.Context, in *RollbackURLRequest, opts ...grpc.CallOption) (*URLVersion, error)
 	// Generate Internal Stats get URLs and Users count
 	GetInternalStats(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*InternalStatsResponse, error)
+	// Obtains clicks statistics of the user ShortURL value
+	GetURLStats(ctx context.Context, in *URLRequest, opts ...grpc.CallOption) (*URLStatsResponse, error)
+	// Obtains QR code image of the full ShortURL
+	GetQRCode(ctx context.Context, in *QRCodeRequest, opts ...grpc.CallOption) (*QRCodeResponse, error)
 }
 
 type shortenerClient struct {
@@ -75,6 +91,15 @@ func (c *shortenerClient) GetAllURL(ctx context.Context, in *Empty, opts ...grpc
 	return out, nil
 }
 
+func (c *shortenerClient) ListURLs(ctx context.Context, in *ListURLsRequest, opts ...grpc.CallOption) (*ListURLsResponse, error) {
+	out := new(ListURLsResponse)
+	err := c.cc.Invoke(ctx, "/shortener.grpc.Shortener/ListURLs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *shortenerClient) PostURL(ctx context.Context, in *URLRequest, opts ...grpc.CallOption) (*URLResponse, error) {
 	out := new(URLResponse)
 	err := c.cc.Invoke(ctx, "/shortener.grpc.Shortener/PostURL", in, out, opts...)
@@ -102,8 +127,8 @@ func (c *shortenerClient) PostAPIBatch(ctx context.Context, in *BatchRequestArra
 	return out, nil
 }
 
-func (c *shortenerClient) DeleteURLs(ctx context.Context, in *AnyURLRequest, opts ...grpc.CallOption) (*Empty, error) {
-	out := new(Empty)
+func (c *shortenerClient) DeleteURLs(ctx context.Context, in *AnyURLRequest, opts ...grpc.CallOption) (*DeleteJob, error) {
+	out := new(DeleteJob)
 	err := c.cc.Invoke(ctx, "/shortener.grpc.Shortener/DeleteURLs", in, out, opts...)
 	if err != nil {
 		return nil, err
@@ -111,6 +136,51 @@ func (c *shortenerClient) DeleteURLs(ctx context.Context, in *AnyURLRequest, opt
 	return out, nil
 }
 
+func (c *shortenerClient) GetDeleteJob(ctx context.Context, in *DeleteJobRequest, opts ...grpc.CallOption) (*DeleteJob, error) {
+	out := new(DeleteJob)
+	err := c.cc.Invoke(ctx, "/shortener.grpc.Shortener/GetDeleteJob", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shortenerClient) RestoreURLs(ctx context.Context, in *AnyURLRequest, opts ...grpc.CallOption) (*RestoreURLsResponse, error) {
+	out := new(RestoreURLsResponse)
+	err := c.cc.Invoke(ctx, "/shortener.grpc.Shortener/RestoreURLs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shortenerClient) UpdateURL(ctx context.Context, in *UpdateURLRequest, opts ...grpc.CallOption) (*URLVersion, error) {
+	out := new(URLVersion)
+	err := c.cc.Invoke(ctx, "/shortener.grpc.Shortener/UpdateURL", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shortenerClient) GetURLHistory(ctx context.Context, in *URLRequest, opts ...grpc.CallOption) (*URLHistoryResponse, error) {
+	out := new(URLHistoryResponse)
+	err := c.cc.Invoke(ctx, "/shortener.grpc.Shortener/GetURLHistory", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shortenerClient) RollbackURL(ctx context.Context, in *RollbackURLRequest, opts ...grpc.CallOption) (*URLVersion, error) {
+	out := new(URLVersion)
+	err := c.cc.Invoke(ctx, "/shortener.grpc.Shortener/RollbackURL", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *shortenerClient) GetInternalStats(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*InternalStatsResponse, error) {
 	out := new(InternalStatsResponse)
 	err := c.cc.Invoke(ctx, "/shortener.grpc.Shortener/GetInternalStats", in, out, opts...)
@@ -120,6 +190,24 @@ func (c *shortenerClient) GetInternalStats(ctx context.Context, in *Empty, opts
 	return out, nil
 }
 
+func (c *shortenerClient) GetURLStats(ctx context.Context, in *URLRequest, opts ...grpc.CallOption) (*URLStatsResponse, error) {
+	out := new(URLStatsResponse)
+	err := c.cc.Invoke(ctx, "/shortener.grpc.Shortener/GetURLStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shortenerClient) GetQRCode(ctx context.Context, in *QRCodeRequest, opts ...grpc.CallOption) (*QRCodeResponse, error) {
+	out := new(QRCodeResponse)
+	err := c.cc.Invoke(ctx, "/shortener.grpc.Shortener/GetQRCode", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ShortenerServer is the server API for Shortener service.
 // All implementations must embed UnimplementedShortenerServer
 // for forward compatibility
@@ -130,16 +218,32 @@ type ShortenerServer interface {
 	GetURL(context.Context, *URLRequest) (*URLResponse, error)
 	// Obtains all URLs saved by the user in the format of pairs of OriginURL and ShortURL
 	GetAllURL(context.Context, *Empty) (*AnyURLResponse, error)
+	// Obtains a page of URLs saved by the user, sorted and filtered
+	ListURLs(context.Context, *ListURLsRequest) (*ListURLsResponse, error)
 	// Obtain ShortURL value for OriginalURL and save it at storage
 	PostURL(context.Context, *URLRequest) (*URLResponse, error)
 	// Obtain ShortURL value for OriginalURL and save it at storage
 	PostAPIurl(context.Context, *URLRequest) (*URLResponse, error)
 	// Obtain ShortURL values for OriginalURL at batch request and save values at storage
 	PostAPIBatch(context.Context, *BatchRequestArray) (*BatchResponseArray, error)
-	// Delete stored URL values by ShortURL
-	DeleteURLs(context.Context, *AnyURLRequest) (*Empty, error)
+	// Delete stored URL values by ShortURL, the values are deleted by the background job
+	DeleteURLs(context.Context, *AnyURLRequest) (*DeleteJob, error)
+	// Obtains the status of the user deletion job
+	GetDeleteJob(context.Context, *DeleteJobRequest) (*DeleteJob, error)
+	// Restore deleted URL values by ShortURL, only the links deleted within the retention period are restored
+	RestoreURLs(context.Context, *AnyURLRequest) (*RestoreURLsResponse, error)
+	// Point the user ShortURL value at the new OriginalURL value
+	UpdateURL(context.Context, *UpdateURLRequest) (*URLVersion, error)
+	// Obtains the OriginalURL values history of the user ShortURL value
+	GetURLHistory(context.Context, *URLRequest) (*URLHistoryResponse, error)
+	// Point the user ShortURL value at the OriginalURL value of the earlier version
+	RollbackURL(context.Context, *RollbackURLRequest) (*URLVersion, error)
 	// Generate Internal Stats get URLs and Users count
 	GetInternalStats(context.Context, *Empty) (*InternalStatsResponse, error)
+	// Obtains clicks statistics of the user ShortURL value
+	GetURLStats(context.Context, *URLRequest) (*URLStatsResponse, error)
+	// Obtains QR code image of the full ShortURL
+	GetQRCode(context.Context, *QRCodeRequest) (*QRCodeResponse, error)
 	mustEmbedUnimplementedShortenerServer()
 }
 
@@ -156,6 +260,9 @@ func (UnimplementedShortenerServer) GetURL(context.Context, *URLRequest) (*URLRe
 func (UnimplementedShortenerServer) GetAllURL(context.Context, *Empty) (*AnyURLResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetAllURL not implemented")
 }
+func (UnimplementedShortenerServer) ListURLs(context.Context, *ListURLsRequest) (*ListURLsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListURLs not implemented")
+}
 func (UnimplementedShortenerServer) PostURL(context.Context, *URLRequest) (*URLResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method PostURL not implemented")
 }
@@ -165,12 +272,33 @@ func (UnimplementedShortenerServer) PostAPIurl(context.Context, *URLRequest) (*U
 func (UnimplementedShortenerServer) PostAPIBatch(context.Context, *BatchRequestArray) (*BatchResponseArray, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method PostAPIBatch not implemented")
 }
-func (UnimplementedShortenerServer) DeleteURLs(context.Context, *AnyURLRequest) (*Empty, error) {
+func (UnimplementedShortenerServer) DeleteURLs(context.Context, *AnyURLRequest) (*DeleteJob, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteURLs not implemented")
 }
+func (UnimplementedShortenerServer) GetDeleteJob(context.Context, *DeleteJobRequest) (*DeleteJob, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDeleteJob not implemented")
+}
+func (UnimplementedShortenerServer) RestoreURLs(context.Context, *AnyURLRequest) (*RestoreURLsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreURLs not implemented")
+}
+func (UnimplementedShortenerServer) UpdateURL(context.Context, *UpdateURLRequest) (*URLVersion, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateURL not implemented")
+}
+func (UnimplementedShortenerServer) GetURLHistory(context.Context, *URLRequest) (*URLHistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetURLHistory not implemented")
+}
+func (UnimplementedShortenerServer) RollbackURL(context.Context, *RollbackURLRequest) (*URLVersion, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RollbackURL not implemented")
+}
 func (UnimplementedShortenerServer) GetInternalStats(context.Context, *Empty) (*InternalStatsResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetInternalStats not implemented")
 }
+func (UnimplementedShortenerServer) GetURLStats(context.Context, *URLRequest) (*URLStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetURLStats not implemented")
+}
+func (UnimplementedShortenerServer) GetQRCode(context.Context, *QRCodeRequest) (*QRCodeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetQRCode not implemented")
+}
 func (UnimplementedShortenerServer) mustEmbedUnimplementedShortenerServer() {}
 
 // UnsafeShortenerServer may be embedded to opt out of forward compatibility for this service.
@@ -238,6 +366,24 @@ func _Shortener_GetAllURL_Handler(srv interface{}, ctx context.Context, dec func
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Shortener_ListURLs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListURLsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortenerServer).ListURLs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/shortener.grpc.Shortener/ListURLs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortenerServer).ListURLs(ctx, req.(*ListURLsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Shortener_PostURL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(URLRequest)
 	if err := dec(in); err != nil {
@@ -310,6 +456,96 @@ func _Shortener_DeleteURLs_Handler(srv interface{}, ctx context.Context, dec fun
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Shortener_GetDeleteJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortenerServer).GetDeleteJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/shortener.grpc.Shortener/GetDeleteJob",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortenerServer).GetDeleteJob(ctx, req.(*DeleteJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shortener_RestoreURLs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AnyURLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortenerServer).RestoreURLs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/shortener.grpc.Shortener/RestoreURLs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortenerServer).RestoreURLs(ctx, req.(*AnyURLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shortener_UpdateURL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateURLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortenerServer).UpdateURL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/shortener.grpc.Shortener/UpdateURL",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortenerServer).UpdateURL(ctx, req.(*UpdateURLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shortener_GetURLHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(URLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortenerServer).GetURLHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/shortener.grpc.Shortener/GetURLHistory",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortenerServer).GetURLHistory(ctx, req.(*URLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shortener_RollbackURL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RollbackURLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortenerServer).RollbackURL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/shortener.grpc.Shortener/RollbackURL",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortenerServer).RollbackURL(ctx, req.(*RollbackURLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Shortener_GetInternalStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(Empty)
 	if err := dec(in); err != nil {
@@ -328,6 +564,42 @@ func _Shortener_GetInternalStats_Handler(srv interface{}, ctx context.Context, d
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Shortener_GetURLStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(URLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortenerServer).GetURLStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/shortener.grpc.Shortener/GetURLStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortenerServer).GetURLStats(ctx, req.(*URLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shortener_GetQRCode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QRCodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortenerServer).GetQRCode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/shortener.grpc.Shortener/GetQRCode",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortenerServer).GetQRCode(ctx, req.(*QRCodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Shortener_ServiceDesc is the grpc.ServiceDesc for Shortener service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -347,6 +619,10 @@ var Shortener_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetAllURL",
 			Handler:    _Shortener_GetAllURL_Handler,
 		},
+		{
+			MethodName: "ListURLs",
+			Handler:    _Shortener_ListURLs_Handler,
+		},
 		{
 			MethodName: "PostURL",
 			Handler:    _Shortener_PostURL_Handler,
@@ -363,10 +639,38 @@ var Shortener_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "DeleteURLs",
 			Handler:    _Shortener_DeleteURLs_Handler,
 		},
+		{
+			MethodName: "GetDeleteJob",
+			Handler:    _Shortener_GetDeleteJob_Handler,
+		},
+		{
+			MethodName: "RestoreURLs",
+			Handler:    _Shortener_RestoreURLs_Handler,
+		},
+		{
+			MethodName: "UpdateURL",
+			Handler:    _Shortener_UpdateURL_Handler,
+		},
+		{
+			MethodName: "GetURLHistory",
+			Handler:    _Shortener_GetURLHistory_Handler,
+		},
+		{
+			MethodName: "RollbackURL",
+			Handler:    _Shortener_RollbackURL_Handler,
+		},
 		{
 			MethodName: "GetInternalStats",
 			Handler:    _Shortener_GetInternalStats_Handler,
 		},
+		{
+			MethodName: "GetURLStats",
+			Handler:    _Shortener_GetURLStats_Handler,
+		},
+		{
+			MethodName: "GetQRCode",
+			Handler:    _Shortener_GetQRCode_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "shortener.proto",
@@ -56,6 +56,39 @@ func GenerateJWT(secretKey string) (string, int32, error) {
 	return sign, result, err
 }
 
+// ClientIP returns the client IP address, forwarding headers are used only when
+// the request came from the trusted subnet. X-Forwarded-For chain is walked from the right,
+// the first address out of the trusted subnet is the client address.
+func ClientIP(remoteAddr, xForwardedFor, xRealIP string, trustedNet *net.IPNet) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	remoteIP := net.ParseIP(host)
+	if remoteIP == nil || trustedNet == nil || !trustedNet.Contains(remoteIP) {
+		return host
+	}
+
+	if strings.TrimSpace(xForwardedFor) != "" {
+		chain := strings.Split(xForwardedFor, ",")
+		for i := len(chain) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(chain[i]))
+			if ip == nil {
+				break
+			}
+			if !trustedNet.Contains(ip) || i == 0 {
+				return ip.String()
+			}
+		}
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(xRealIP)); ip != nil {
+		return ip.String()
+	}
+	return host
+}
+
 func SetTrustedSubnet(subnet string) *net.IPNet {
 	if strings.TrimSpace(subnet) == "" {
 		return nil
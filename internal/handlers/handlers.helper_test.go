@@ -0,0 +1,55 @@
+package handlershelper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	trustedNet := SetTrustedSubnet("10.0.0.0/8")
+
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		xForwardedFor string
+		xRealIP       string
+		want          string
+	}{
+		{
+			name:       "direct request",
+			remoteAddr: "192.168.1.10:4321",
+			want:       "192.168.1.10",
+		},
+		{
+			name:          "forwarding headers from untrusted address are ignored",
+			remoteAddr:    "192.168.1.10:4321",
+			xForwardedFor: "1.2.3.4",
+			xRealIP:       "1.2.3.4",
+			want:          "192.168.1.10",
+		},
+		{
+			name:          "trusted proxy chain",
+			remoteAddr:    "10.0.0.1:4321",
+			xForwardedFor: "5.6.7.8, 1.2.3.4, 10.0.0.2",
+			want:          "1.2.3.4",
+		},
+		{
+			name:          "all addresses are trusted",
+			remoteAddr:    "10.0.0.1:4321",
+			xForwardedFor: "10.0.0.3, 10.0.0.2",
+			want:          "10.0.0.3",
+		},
+		{
+			name:       "trusted proxy with X-Real-IP",
+			remoteAddr: "10.0.0.1:4321",
+			xRealIP:    "1.2.3.4",
+			want:       "1.2.3.4",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ClientIP(tt.remoteAddr, tt.xForwardedFor, tt.xRealIP, trustedNet))
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package handlershelper
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	linkTokenTimeLength   = 8    // unix time of the token expiration, big endian
+	limiterSweepSizeConst = 1024 // count of the tracked addresses which starts the removal of the stale ones
+)
+
+// ErrLinkTokenExpired - protected link access token is expired.
+var ErrLinkTokenExpired = errors.New("link access token is expired")
+
+// GenerateLinkToken returns access token of the protected link, the token is signed with the secret key
+// and is valid for the short URL value till the expiration time.
+func GenerateLinkToken(secretKey, shortURL string, expiresAt time.Time) string {
+	data := make([]byte, linkTokenTimeLength)
+	binary.BigEndian.PutUint64(data, uint64(expiresAt.Unix()))
+	return hex.EncodeToString(append(data, linkTokenSign(secretKey, shortURL, data)...))
+}
+
+// CheckLinkToken checks the access token of the protected link.
+func CheckLinkToken(secretKey, shortURL, value string, now time.Time) error {
+	data, err := hex.DecodeString(value)
+	if err != nil {
+		return err
+	}
+	if len(data) <= linkTokenTimeLength {
+		return ErrNotEqual
+	}
+
+	if !hmac.Equal(data[linkTokenTimeLength:], linkTokenSign(secretKey, shortURL, data[:linkTokenTimeLength])) {
+		return ErrNotEqual
+	}
+	if now.Unix() >= int64(binary.BigEndian.Uint64(data[:linkTokenTimeLength])) {
+		return ErrLinkTokenExpired
+	}
+	return nil
+}
+
+// linkTokenSign signs the short URL value and the token expiration time,
+// the short URL value goes last, so the token of one link never fits another one.
+func linkTokenSign(secretKey, shortURL string, expiresAt []byte) []byte {
+	hm := hmac.New(sha256.New, []byte(secretKey))
+	hm.Write(expiresAt)
+	hm.Write([]byte(shortURL))
+	return hm.Sum(nil)
+}
+
+type (
+	// PasswordLimiter locks out the client address after repeated wrong passwords of the protected links.
+	// the failures are counted within the lockout period from the first failure.
+	PasswordLimiter struct {
+		clients     map[string]*passwordFailures
+		mu          sync.Mutex
+		maxFailures int
+		lockout     time.Duration
+	}
+
+	passwordFailures struct {
+		first       time.Time
+		lockedUntil time.Time
+		count       int
+	}
+)
+
+// NewPasswordLimiter creates the limiter, the client is locked out for the lockout period after maxFailures wrong passwords.
+// the limiter never locks out if maxFailures is not positive.
+func NewPasswordLimiter(maxFailures int, lockout time.Duration) *PasswordLimiter {
+	return &PasswordLimiter{
+		clients:     make(map[string]*passwordFailures),
+		maxFailures: maxFailures,
+		lockout:     lockout,
+	}
+}
+
+// Locked returns the time left till the end of the client lockout, zero if the client is not locked out.
+func (l *PasswordLimiter) Locked(client string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if f, ok := l.clients[client]; ok && now.Before(f.lockedUntil) {
+		return f.lockedUntil.Sub(now)
+	}
+	return 0
+}
+
+// Fail counts the wrong password of the client, the function returns the lockout period if the client is locked out.
+func (l *PasswordLimiter) Fail(client string, now time.Time) time.Duration {
+	if l.maxFailures <= 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.clients) >= limiterSweepSizeConst {
+		l.sweep(now)
+	}
+
+	f, ok := l.clients[client]
+	if !ok || l.stale(f, now) {
+		f = &passwordFailures{first: now}
+		l.clients[client] = f
+	}
+	f.count++
+	if f.count < l.maxFailures {
+		return 0
+	}
+
+	f.lockedUntil = now.Add(l.lockout)
+	f.first, f.count = f.lockedUntil, 0
+	return l.lockout
+}
+
+// Reset forgets the failures of the client after the right password.
+func (l *PasswordLimiter) Reset(client string) {
+	l.mu.Lock()
+	delete(l.clients, client)
+	l.mu.Unlock()
+}
+
+// stale checks that the failures are out of the counting period and the client is not locked out.
+func (l *PasswordLimiter) stale(f *passwordFailures, now time.Time) bool {
+	return !now.Before(f.lockedUntil) && now.Sub(f.first) >= l.lockout
+}
+
+// sweep removes the stale clients, mu must be held.
+func (l *PasswordLimiter) sweep(now time.Time) {
+	for client, f := range l.clients {
+		if l.stale(f, now) {
+			delete(l.clients, client)
+		}
+	}
+}
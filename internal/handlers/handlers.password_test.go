@@ -0,0 +1,48 @@
+package handlershelper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinkToken(t *testing.T) {
+	now := time.Now()
+	token := GenerateLinkToken("secret", "abcde", now.Add(time.Minute))
+
+	require.NoError(t, CheckLinkToken("secret", "abcde", token, now))
+	assert.ErrorIs(t, CheckLinkToken("secret", "abcde", token, now.Add(time.Minute)), ErrLinkTokenExpired)
+	assert.ErrorIs(t, CheckLinkToken("secret", "fghij", token, now), ErrNotEqual, "the token of one link does not fit another one")
+	assert.ErrorIs(t, CheckLinkToken("other secret", "abcde", token, now), ErrNotEqual)
+	assert.ErrorIs(t, CheckLinkToken("secret", "abcde", "00", now), ErrNotEqual)
+	assert.Error(t, CheckLinkToken("secret", "abcde", "not hex", now))
+}
+
+func TestPasswordLimiter(t *testing.T) {
+	l := NewPasswordLimiter(3, time.Minute)
+	now := time.Now()
+
+	assert.Zero(t, l.Fail("1.2.3.4", now))
+	assert.Zero(t, l.Fail("1.2.3.4", now.Add(time.Second)))
+	assert.Zero(t, l.Locked("1.2.3.4", now.Add(time.Second)))
+	assert.Equal(t, time.Minute, l.Fail("1.2.3.4", now.Add(2*time.Second)))
+
+	assert.Equal(t, 30*time.Second, l.Locked("1.2.3.4", now.Add(32*time.Second)))
+	assert.Zero(t, l.Locked("5.6.7.8", now), "other clients are not locked out")
+	assert.Zero(t, l.Locked("1.2.3.4", now.Add(62*time.Second)), "the lockout ends")
+
+	// the failures out of the counting period are forgotten
+	l.Fail("5.6.7.8", now)
+	l.Fail("5.6.7.8", now.Add(time.Second))
+	assert.Zero(t, l.Fail("5.6.7.8", now.Add(2*time.Minute)))
+
+	// the right password resets the failures
+	l.Fail("9.9.9.9", now)
+	l.Fail("9.9.9.9", now)
+	l.Reset("9.9.9.9")
+	assert.Zero(t, l.Fail("9.9.9.9", now))
+
+	assert.Zero(t, NewPasswordLimiter(0, time.Minute).Fail("1.2.3.4", now), "the disabled limiter never locks out")
+}
@@ -0,0 +1,165 @@
+package handlershelper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// QR code image formats.
+const (
+	QRFormatPNG = "png"
+	QRFormatSVG = "svg"
+)
+
+const (
+	defaultQRSize   = 256
+	maxQRSize       = 1024 // the QR code endpoint needs no authorization, so the rendering cost is bounded
+	defaultQRMargin = 4    // quiet zone width recommended by the QR code specification
+	maxQRMargin     = 64
+	defaultQRLevel  = "M"
+)
+
+// ErrQROptionsInvalid - QR code image settings are invalid.
+var ErrQROptionsInvalid = errors.New("QR code options are invalid")
+
+// qrLevels maps the error correction levels to the encoder recovery levels.
+var qrLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,     // 7% of the code can be restored
+	"M": qrcode.Medium,  // 15% of the code can be restored
+	"Q": qrcode.High,    // 25% of the code can be restored
+	"H": qrcode.Highest, // 30% of the code can be restored
+}
+
+// QROptions represents QR code image settings.
+type QROptions struct {
+	Format string // png or svg
+	Level  string // error correction level: L, M, Q or H
+	Size   int    // image width and height in pixels
+	Margin int    // quiet zone width in modules
+}
+
+// ParseQROptions reads the QR code image settings, empty values get the defaults:
+// 256 pixels PNG image, M error correction level and 4 modules margin.
+func ParseQROptions(format, size, level, margin string) (QROptions, error) {
+	opts := QROptions{
+		Format: QRFormatPNG,
+		Level:  defaultQRLevel,
+		Size:   defaultQRSize,
+		Margin: defaultQRMargin,
+	}
+
+	if format != "" {
+		opts.Format = strings.ToLower(format)
+	}
+	if level != "" {
+		opts.Level = strings.ToUpper(level)
+	}
+
+	var err error
+	if size != "" {
+		if opts.Size, err = strconv.Atoi(size); err != nil {
+			return opts, fmt.Errorf("%w: size must be a number", ErrQROptionsInvalid)
+		}
+	}
+	if margin != "" {
+		if opts.Margin, err = strconv.Atoi(margin); err != nil {
+			return opts, fmt.Errorf("%w: margin must be a number", ErrQROptionsInvalid)
+		}
+	}
+	return opts, opts.validate()
+}
+
+func (o QROptions) validate() error {
+	if o.Format != QRFormatPNG && o.Format != QRFormatSVG {
+		return fmt.Errorf("%w: format must be png or svg", ErrQROptionsInvalid)
+	}
+	if _, ok := qrLevels[o.Level]; !ok {
+		return fmt.Errorf("%w: level must be L, M, Q or H", ErrQROptionsInvalid)
+	}
+	if o.Size <= 0 || o.Size > maxQRSize {
+		return fmt.Errorf("%w: size must be from 1 to %d", ErrQROptionsInvalid, maxQRSize)
+	}
+	if o.Margin < 0 || o.Margin > maxQRMargin {
+		return fmt.Errorf("%w: margin must be from 0 to %d", ErrQROptionsInvalid, maxQRMargin)
+	}
+	return nil
+}
+
+// RenderQRCode encodes the content as QR code image, the function returns the image and its content type.
+// the PNG modules are whole pixels, the image is centered and the rest of the size is added to the margin.
+func RenderQRCode(content string, opts QROptions) ([]byte, string, error) {
+	if err := opts.validate(); err != nil {
+		return nil, "", err
+	}
+
+	code, err := qrcode.New(content, qrLevels[opts.Level])
+	if err != nil {
+		return nil, "", err
+	}
+	code.DisableBorder = true
+	bitmap := code.Bitmap()
+	modules := len(bitmap) + 2*opts.Margin
+
+	if opts.Format == QRFormatSVG {
+		return renderSVG(bitmap, modules, opts), "image/svg+xml", nil
+	}
+
+	scale := opts.Size / modules
+	if scale == 0 {
+		return nil, "", fmt.Errorf("%w: size must be at least %d pixels for the content", ErrQROptionsInvalid, modules)
+	}
+	offset := (opts.Size-scale*modules)/2 + opts.Margin*scale
+
+	img := image.NewPaletted(image.Rect(0, 0, opts.Size, opts.Size), color.Palette{color.White, color.Black})
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex(offset+x*scale+dx, offset+y*scale+dy, 1)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, img); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/png", nil
+}
+
+// renderSVG draws the dark modules as a path of horizontal runs, the view box unit is a module.
+func renderSVG(bitmap [][]bool, modules int, opts QROptions) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		opts.Size, opts.Size, modules, modules)
+	buf.WriteString(`<rect width="100%" height="100%" fill="#fff"/><path fill="#000" d="`)
+	for y, row := range bitmap {
+		for x := 0; x < len(row); {
+			if !row[x] {
+				x++
+				continue
+			}
+			run := 1
+			for x+run < len(row) && row[x+run] {
+				run++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv1h-%dz", x+opts.Margin, y+opts.Margin, run, run)
+			x += run
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
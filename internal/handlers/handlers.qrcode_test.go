@@ -0,0 +1,166 @@
+package handlershelper
+
+import (
+	"bytes"
+	"flag"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden images of testdata directory")
+
+func TestParseQROptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		size    string
+		level   string
+		margin  string
+		want    QROptions
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			want: QROptions{Format: QRFormatPNG, Level: "M", Size: 256, Margin: 4},
+		},
+		{
+			name:   "all settings",
+			format: "SVG",
+			size:   "512",
+			level:  "h",
+			margin: "0",
+			want:   QROptions{Format: QRFormatSVG, Level: "H", Size: 512, Margin: 0},
+		},
+		{
+			name:    "unknown format",
+			format:  "gif",
+			wantErr: true,
+		},
+		{
+			name:    "unknown level",
+			level:   "X",
+			wantErr: true,
+		},
+		{
+			name:    "size is not a number",
+			size:    "big",
+			wantErr: true,
+		},
+		{
+			name:    "size is too large",
+			size:    "100000",
+			wantErr: true,
+		},
+		{
+			name:    "size is above the limit",
+			size:    "1025",
+			wantErr: true,
+		},
+		{
+			name: "size is the limit",
+			size: "1024",
+			want: QROptions{Format: QRFormatPNG, Level: "M", Size: 1024, Margin: 4},
+		},
+		{
+			name:    "negative margin",
+			margin:  "-1",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseQROptions(tt.format, tt.size, tt.level, tt.margin)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrQROptionsInvalid)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRenderQRCode(t *testing.T) {
+	const content = "http://localhost:8080/abcde"
+
+	tests := []struct {
+		name        string
+		golden      string
+		contentType string
+		opts        QROptions
+	}{
+		{
+			name:        "default png",
+			golden:      "qrcode_default.png",
+			contentType: "image/png",
+			opts:        QROptions{Format: QRFormatPNG, Level: "M", Size: 256, Margin: 4},
+		},
+		{
+			name:        "png without margin and high level",
+			golden:      "qrcode_h_nomargin.png",
+			contentType: "image/png",
+			opts:        QROptions{Format: QRFormatPNG, Level: "H", Size: 100, Margin: 0},
+		},
+		{
+			name:        "svg",
+			golden:      "qrcode_l.svg",
+			contentType: "image/svg+xml",
+			opts:        QROptions{Format: QRFormatSVG, Level: "L", Size: 300, Margin: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, contentType, err := RenderQRCode(content, tt.opts)
+			require.NoError(t, err)
+			assert.Equal(t, tt.contentType, contentType)
+
+			path := filepath.Join("testdata", tt.golden)
+			if *updateGolden {
+				require.NoError(t, os.WriteFile(path, got, 0644))
+			}
+			want, err := os.ReadFile(path)
+			require.NoError(t, err)
+
+			if tt.opts.Format == QRFormatSVG {
+				assert.Equal(t, string(want), string(got))
+				return
+			}
+			// the compressed bytes depend on the encoder version, so the pixels are compared
+			assertSameImage(t, want, got, tt.opts.Size)
+		})
+	}
+
+	t.Run("size is too small for the content", func(t *testing.T) {
+		_, _, err := RenderQRCode(content, QROptions{Format: QRFormatPNG, Level: "M", Size: 20, Margin: 4})
+		assert.ErrorIs(t, err, ErrQROptionsInvalid)
+	})
+}
+
+// assertSameImage compares the pixels of two PNG images.
+func assertSameImage(t *testing.T, want, got []byte, size int) {
+	t.Helper()
+	decode := func(b []byte) image.Image {
+		img, err := png.Decode(bytes.NewReader(b))
+		require.NoError(t, err)
+		return img
+	}
+	wantImg, gotImg := decode(want), decode(got)
+	require.Equal(t, image.Rect(0, 0, size, size), gotImg.Bounds())
+	require.Equal(t, wantImg.Bounds(), gotImg.Bounds())
+
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			wr, wg, wb, wa := wantImg.At(x, y).RGBA()
+			gr, gg, gb, ga := gotImg.At(x, y).RGBA()
+			if wr != gr || wg != gg || wb != gb || wa != ga {
+				t.Fatalf("pixel (%d, %d) differs from the golden image", x, y)
+			}
+		}
+	}
+}
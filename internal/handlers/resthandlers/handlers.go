@@ -10,13 +10,17 @@ import (
 	"net"
 	"net/http"
 	"net/http/pprof"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/asaskevich/govalidator"
 	"github.com/go-chi/chi/v5"
 
+	"github.com/alexkopcak/shortener/internal/analytics"
 	"github.com/alexkopcak/shortener/internal/config"
 	handlershelper "github.com/alexkopcak/shortener/internal/handlers"
+	"github.com/alexkopcak/shortener/internal/jobs"
 	"github.com/alexkopcak/shortener/internal/storage"
 )
 
@@ -25,9 +29,12 @@ type (
 	Handler struct {
 		trustedNet *net.IPNet
 		*chi.Mux
-		dChannel chan *storage.DeletedShortURLValues
-		Repo     storage.Storage
-		Cfg      config.Config
+		deletes   *jobs.Queue
+		clicks    *analytics.Recorder
+		passwords *handlershelper.PasswordLimiter
+		codes     storage.CodeGenerator
+		Repo      storage.Storage
+		Cfg       config.Config
 	}
 
 	key uint64
@@ -39,20 +46,34 @@ const (
 
 type gzipWriter struct {
 	http.ResponseWriter
-	Writer io.Writer
+	Writer *gzip.Writer
 }
 
 func (w gzipWriter) Write(b []byte) (int, error) {
 	return w.Writer.Write(b)
 }
 
+// Flush sends the data compressed so far to the client, so the streamed responses are sent by chunks.
+func (w gzipWriter) Flush() {
+	if err := w.Writer.Flush(); err != nil {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 // NewURLHandler create handler object and set handlers endpoints.
-func NewURLHandler(repo storage.Storage, cfg config.Config, dChan chan *storage.DeletedShortURLValues) *Handler {
+// codes generates the short URL values, clicks recorder is optional, redirects are not recorded if it is nil.
+func NewURLHandler(repo storage.Storage, codes storage.CodeGenerator, cfg config.Config, deletes *jobs.Queue, clicks *analytics.Recorder) *Handler {
 	h := &Handler{
 		Mux:        chi.NewMux(),
 		Repo:       repo,
 		Cfg:        cfg,
-		dChannel:   dChan,
+		deletes:    deletes,
+		clicks:     clicks,
+		passwords:  handlershelper.NewPasswordLimiter(cfg.PasswordMaxFailures, cfg.PasswordLockout),
+		codes:      codes,
 		trustedNet: handlershelper.SetTrustedSubnet(cfg.TrustedSubnet),
 	}
 
@@ -60,13 +81,24 @@ func NewURLHandler(repo storage.Storage, cfg config.Config, dChan chan *storage.
 	h.Mux.Use(gzipMiddlewareHandle)
 
 	h.Mux.Get("/{idValue}", h.GetHandler())
+	h.Mux.Post("/{idValue}", h.PostPasswordHandler())
+	h.Mux.Get("/{idValue}/qr", h.GetQRCodeHandler())
 	h.Mux.Get("/api/user/urls", h.GetAPIAllURLHandler())
+	h.Mux.Get("/api/user/urls/{idValue}/stats", h.GetURLStatsHandler())
 	h.Mux.Get("/ping", h.Ping())
 	h.Mux.Post("/", h.PostHandler())
 	h.Mux.Post("/api/shorten", h.PostAPIHandler())
 	h.Mux.Post("/api/shorten/batch", h.PostAPIBatchHandler())
 	h.Mux.Delete("/api/user/urls", h.DeleteUserURLHandler())
+	h.Mux.Post("/api/user/urls/import", h.ImportURLHandler())
+	h.Mux.Get("/api/user/urls/export", h.ExportURLHandler())
+	h.Mux.Get("/api/user/jobs/{jobID}", h.GetJobHandler())
+	h.Mux.Post("/api/user/urls/restore", h.RestoreUserURLHandler())
+	h.Mux.Patch("/api/user/urls/{idValue}", h.UpdateURLHandler())
+	h.Mux.Get("/api/user/urls/{idValue}/history", h.GetURLHistoryHandler())
+	h.Mux.Post("/api/user/urls/{idValue}/rollback", h.RollbackURLHandler())
 	h.Mux.Get("/api/internal/stats", h.GetInternalStats())
+	h.Mux.Post("/api/internal/compact", h.CompactHandler())
 
 	h.Mux.Handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
 	h.Mux.Handle("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
@@ -82,12 +114,13 @@ func NewURLHandler(repo storage.Storage, cfg config.Config, dChan chan *storage.
 }
 
 // DeleteUserURLHandler godoc
-// @Summary delete user URLs based on params
+// @Summary delete user URLs based on params, the links are deleted by the background job
 // @Tags Storage
 // @Accept json
+// @Produce json
 // @Param shortURLs body string true
-// @Success 202 {string} string
-// @Failure 400 {string} string
+// @Success 202 {object} jobs.Job
+// @Failure 400,500,503 {string} string
 // @Router /api/user/urls [delete]
 func (h *Handler) DeleteUserURLHandler() http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -101,17 +134,218 @@ func (h *Handler) DeleteUserURLHandler() http.HandlerFunc {
 			return
 		}
 
-		deletedURLs := &storage.DeletedShortURLValues{
+		job, err := h.deletes.Enqueue(ctx, &storage.DeletedShortURLValues{
 			ShortURLValues: shortURLs,
 			UserIDValue:    userID,
+		})
+		if errors.Is(err, jobs.ErrQueueFull) || errors.Is(err, jobs.ErrQueueClosed) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
-		h.dChannel <- deletedURLs
-
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/api/user/jobs/"+job.ID)
 		w.WriteHeader(http.StatusAccepted)
+
+		if err = json.NewEncoder(w).Encode(&job); err != nil {
+			http.Error(w, "Something went wrong!", http.StatusBadRequest)
+			return
+		}
+	})
+}
+
+// GetJobHandler godoc
+// @Summary get the status of the user links deletion job
+// @Tags Storage
+// @Produce json
+// @Param jobID path string true "jobID"
+// @Success 200 {object} jobs.Job
+// @Failure 400,404 {string} string
+// @Router /api/user/jobs/{jobID} [get]
+func (h *Handler) GetJobHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID, _ := ctx.Value(keyPrincipalID).(int32)
+
+		job, err := h.deletes.Job(ctx, chi.URLParam(r, "jobID"), userID)
+		if errors.Is(err, jobs.ErrJobNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Something went wrong!", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		if err = json.NewEncoder(w).Encode(&job); err != nil {
+			http.Error(w, "Something went wrong!", http.StatusBadRequest)
+			return
+		}
+	}
+}
+
+// RestoreUserURLHandler godoc
+// @Summary restore deleted user URLs based on params, only the links deleted within the retention period are restored
+// @Tags Storage
+// @Accept json
+// @Produce json
+// @Param shortURLs body string true
+// @Success 200 {array} string
+// @Failure 400 {string} string
+// @Router /api/user/urls/restore [post]
+func (h *Handler) RestoreUserURLHandler() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID, _ := ctx.Value(keyPrincipalID).(int32)
+
+		var shortURLs []string
+
+		if err := json.NewDecoder(r.Body).Decode(&shortURLs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		restored, err := h.Repo.RestoreUserURL(ctx, &storage.DeletedShortURLValues{
+			ShortURLValues: shortURLs,
+			UserIDValue:    userID,
+		})
+		if err != nil {
+			http.Error(w, "Something went wrong!", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		if err = json.NewEncoder(w).Encode(&restored); err != nil {
+			http.Error(w, "Something went wrong!", http.StatusBadRequest)
+			return
+		}
 	})
 }
 
+// UpdateURLHandler godoc
+// @Summary point the user short URL value at the new original URL value, the previous one is kept at the link history
+// @Tags Storage
+// @Accept json
+// @Produce json
+// @Param idValue path string true "idValue"
+// @Param bodyraw body updateRequest true "Update request"
+// @Success 200 {object} storage.URLVersion
+// @Failure 400,403,404,409 {string} string
+// @Router /api/user/urls/{idValue} [patch]
+func (h *Handler) UpdateURLHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID, _ := ctx.Value(keyPrincipalID).(int32)
+
+		updateRequest := &struct {
+			LongURLValue string `json:"url"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(updateRequest); err != nil {
+			http.Error(w, "Bad request!", http.StatusBadRequest)
+			return
+		}
+
+		version, err := h.Repo.UpdateURL(ctx, chi.URLParam(r, "idValue"), updateRequest.LongURLValue, userID)
+		h.writeURLVersion(w, version, err)
+	}
+}
+
+// GetURLHistoryHandler godoc
+// @Summary get the destinations history of the user short URL value
+// @Tags Storage
+// @Produce json
+// @Param idValue path string true "idValue"
+// @Success 200 {array} storage.URLVersion
+// @Failure 400,403,404 {string} string
+// @Router /api/user/urls/{idValue}/history [get]
+func (h *Handler) GetURLHistoryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID, _ := ctx.Value(keyPrincipalID).(int32)
+
+		history, err := h.Repo.GetURLHistory(ctx, chi.URLParam(r, "idValue"), userID)
+		if err != nil {
+			http.Error(w, err.Error(), linkEditErrorStatus(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		if err = json.NewEncoder(w).Encode(&history); err != nil {
+			http.Error(w, "Something went wrong!", http.StatusBadRequest)
+			return
+		}
+	}
+}
+
+// RollbackURLHandler godoc
+// @Summary point the user short URL value at the original URL value of the earlier version, the rollback is a new version
+// @Tags Storage
+// @Accept json
+// @Produce json
+// @Param idValue path string true "idValue"
+// @Param bodyraw body rollbackRequest true "Rollback request"
+// @Success 200 {object} storage.URLVersion
+// @Failure 400,403,404,409 {string} string
+// @Router /api/user/urls/{idValue}/rollback [post]
+func (h *Handler) RollbackURLHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID, _ := ctx.Value(keyPrincipalID).(int32)
+
+		rollbackRequest := &struct {
+			Version int `json:"version"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(rollbackRequest); err != nil {
+			http.Error(w, "Bad request!", http.StatusBadRequest)
+			return
+		}
+
+		version, err := storage.RollbackURL(ctx, h.Repo, chi.URLParam(r, "idValue"), rollbackRequest.Version, userID)
+		h.writeURLVersion(w, version, err)
+	}
+}
+
+// func writeURLVersion writes the new link version or the link editing error.
+func (h *Handler) writeURLVersion(w http.ResponseWriter, version storage.URLVersion, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), linkEditErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err = json.NewEncoder(w).Encode(&version); err != nil {
+		http.Error(w, "Something went wrong!", http.StatusBadRequest)
+		return
+	}
+}
+
+// func linkEditErrorStatus returns the response status code for the storage error on link editing.
+func linkEditErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrNotExistRecord), errors.Is(err, storage.ErrVersionNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrNotOwner):
+		return http.StatusForbidden
+	case errors.Is(err, storage.ErrDuplicateRecord):
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
 // Ping godoc
 // @Summary simple test database connection
 // @Tags Health
@@ -205,6 +439,21 @@ func (h *Handler) authMiddlewareHandler(next http.Handler) http.Handler {
 	})
 }
 
+// func linkGone checks that the storage error means the link was deleted, expired or used up.
+func linkGone(err error) bool {
+	return errors.Is(err, storage.ErrNotExistRecord) ||
+		errors.Is(err, storage.ErrExpiredRecord) ||
+		errors.Is(err, storage.ErrClicksExhausted)
+}
+
+// func shortURLErrorStatus returns the response status code for the storage error on short URL value creation.
+func shortURLErrorStatus(err error) int {
+	if errors.Is(err, storage.ErrShortURLTaken) {
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
 func (h *Handler) MethodNotAllowed() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Only GET and POST methods are supported!", http.StatusBadRequest)
@@ -219,8 +468,12 @@ func (h *Handler) NotFound() http.HandlerFunc {
 
 // GetHandler godoc
 // @Summary get short URL value
+// @Description the link protected by password serves the password form till the access cookie of the link is issued.
+// @Description every redirect of the link with max clicks uses up one click, the used up link is gone.
 // @Tags Storage
+// @Produce html
 // @Param idValue path string true "idValue"
+// @Success 200 {string} string "password form"
 // @Success 307 {string} string
 // @Failure 400,410 {string} string
 // @Router /{idValue} [get]
@@ -228,8 +481,19 @@ func (h *Handler) GetHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		idValue := chi.URLParam(r, "idValue")
 		longURLValue, err := h.Repo.GetURL(r.Context(), idValue)
+		switch {
+		case errors.Is(err, storage.ErrPasswordRequired):
+			if !h.linkAccessGranted(r, idValue) {
+				h.writePasswordForm(w, idValue, "", http.StatusOK)
+				return
+			}
+			// the protected link may be limited too, its click is consumed after the password check
+			err = h.Repo.ConsumeClick(r.Context(), idValue)
+		case errors.Is(err, storage.ErrClicksLimited):
+			err = h.Repo.ConsumeClick(r.Context(), idValue)
+		}
 		if err != nil {
-			if errors.Is(err, storage.ErrNotExistRecord) {
+			if linkGone(err) {
 				w.WriteHeader(http.StatusGone)
 				return
 			}
@@ -243,12 +507,134 @@ func (h *Handler) GetHandler() http.HandlerFunc {
 		}
 		w.Header().Set("Location", longURLValue)
 		w.WriteHeader(http.StatusTemporaryRedirect)
+		h.recordClick(r, idValue)
+	}
+}
+
+// func recordClick records the redirect of the short URL value.
+func (h *Handler) recordClick(r *http.Request, idValue string) {
+	if h.clicks == nil {
+		return
+	}
+	h.clicks.Record(analytics.Click{
+		Timestamp: time.Now(),
+		ShortURL:  idValue,
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+		ClientIP: handlershelper.ClientIP(r.RemoteAddr,
+			r.Header.Get("X-Forwarded-For"),
+			r.Header.Get("X-Real-IP"),
+			h.trustedNet),
+	})
+}
+
+// GetQRCodeHandler godoc
+// @Summary get QR code image of short URL value
+// @Tags Storage
+// @Param idValue path string true "idValue"
+// @Param format query string false "png or svg, png by default"
+// @Param size query int false "image width and height in pixels, 256 by default"
+// @Param level query string false "error correction level L, M, Q or H, M by default"
+// @Param margin query int false "quiet zone width in modules, 4 by default"
+// @Produce png
+// @Produce image/svg+xml
+// @Success 200 {file} file
+// @Failure 400,410 {string} string
+// @Router /{idValue}/qr [get]
+func (h *Handler) GetQRCodeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		opts, err := handlershelper.ParseQROptions(query.Get("format"), query.Get("size"), query.Get("level"), query.Get("margin"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		idValue := chi.URLParam(r, "idValue")
+		// rendering the code is not the link access
+		longURLValue, err := storage.PeekURL(r.Context(), h.Repo, idValue)
+		// the code holds the short URL, so the link protected by password keeps its password and the limited link keeps its clicks
+		if err != nil && !errors.Is(err, storage.ErrPasswordRequired) && !errors.Is(err, storage.ErrClicksLimited) {
+			if linkGone(err) {
+				w.WriteHeader(http.StatusGone)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if longURLValue == "" {
+			http.Error(w, "There are no any short Urls!", http.StatusBadRequest)
+			return
+		}
+
+		image, contentType, err := handlershelper.RenderQRCode(h.Cfg.BaseURL+"/"+idValue, opts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		w.Write(image)
+	}
+}
+
+// GetURLStatsHandler godoc
+// @Summary get clicks statistics of the user short URL value
+// @Tags Analytics
+// @Param idValue path string true "idValue"
+// @Success 200 {object} analytics.Stats
+// @Failure 400,403,404 {string} string
+// @Router /api/user/urls/{idValue}/stats [get]
+func (h *Handler) GetURLStatsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID, _ := ctx.Value(keyPrincipalID).(int32)
+		idValue := chi.URLParam(r, "idValue")
+
+		if h.clicks == nil {
+			http.Error(w, "Analytics are disabled!", http.StatusNotFound)
+			return
+		}
+
+		ownerID, err := h.Repo.GetURLOwner(ctx, idValue)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotExistRecord) {
+				http.Error(w, "Short URL not found!", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Something went wrong!", http.StatusBadRequest)
+			return
+		}
+		if ownerID != userID {
+			http.Error(w, "Forbidden!", http.StatusForbidden)
+			return
+		}
+
+		stats, err := h.clicks.Stats(ctx, idValue)
+		if err != nil {
+			http.Error(w, "Something went wrong!", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		if err = json.NewEncoder(w).Encode(&stats); err != nil {
+			http.Error(w, "Something went wrong!", http.StatusBadRequest)
+			return
+		}
 	}
 }
 
 // GetAPIAllURLHandler godoc
-// @Summary get short URL value
+// @Summary get short URL values of the user, the next page cursor is returned at the X-Next-Cursor header
 // @Tags Storage
+// @Param limit query int false "page size, all links if not set"
+// @Param cursor query string false "cursor of the page"
+// @Param sort query string false "created_at or short_url"
+// @Param order query string false "asc or desc"
+// @Param domain query string false "host of the original URL or its parent domain"
+// @Param q query string false "substring of the original URL"
 // @Success 200,204 {string} string
 // @Failure 400 {string} string
 // @Router /api/user/urls [get]
@@ -257,13 +643,27 @@ func (h *Handler) GetAPIAllURLHandler() http.HandlerFunc {
 		ctx := r.Context()
 		userID, _ := ctx.Value(keyPrincipalID).(int32)
 
-		result, err := h.Repo.GetUserURL(r.Context(), h.Cfg.BaseURL, userID)
+		opts, err := listOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		page, err := h.Repo.ListUserURL(r.Context(), h.Cfg.BaseURL, userID, opts)
+		if errors.Is(err, storage.ErrListSortInvalid) || errors.Is(err, storage.ErrCursorInvalid) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			http.Error(w, "Something went wrong!", http.StatusBadRequest)
 			return
 		}
+		result := page.Items
 
 		w.Header().Set("Content-Type", "application/json")
+		if page.NextCursor != "" {
+			w.Header().Set("X-Next-Cursor", page.NextCursor)
+		}
 
 		if len(result) == 0 {
 			w.WriteHeader(http.StatusNoContent)
@@ -280,13 +680,41 @@ func (h *Handler) GetAPIAllURLHandler() http.HandlerFunc {
 	}
 }
 
+// func listOptions reads the user links listing settings from the request query.
+func listOptions(r *http.Request) (storage.ListOptions, error) {
+	query := r.URL.Query()
+	opts := storage.ListOptions{
+		Cursor:   query.Get("cursor"),
+		Sort:     query.Get("sort"),
+		Domain:   query.Get("domain"),
+		Contains: query.Get("q"),
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value < 0 {
+			return opts, errors.New("limit must be a non-negative number")
+		}
+		opts.Limit = value
+	}
+
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		return opts, errors.New("order must be asc or desc")
+	}
+	return opts, nil
+}
+
 // PostAPIBatchHandler godoc
 // @Summary add batch short URL values
 // @Tags Storage
 // @Accept json
 // @Param batchrequest body storage.BatchRequestArray true "Batch request"
-// @Success 201 {string} string
-// @Failure 400 {array} storage.BatchRequest
+// @Success 201,207 {array} storage.BatchResponse
+// @Failure 400 {string} string
 // @Router /api/shorten/batch [post]
 func (h *Handler) PostAPIBatchHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -306,18 +734,22 @@ func (h *Handler) PostAPIBatchHandler() http.HandlerFunc {
 			return
 		}
 
-		for i := range batchRequest {
-			ptr := &batchRequest[i]
-			ptr.ShortURL = storage.ShortURLGenerator()
-		}
-
-		responseValue, err := h.Repo.PostAPIBatch(ctx, &batchRequest, h.Cfg.BaseURL, userID)
+		responseValue, err := storage.PostGeneratedBatch(ctx, h.Repo, h.codes, &batchRequest, h.Cfg.BaseURL, userID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, err.Error(), shortURLErrorStatus(err))
 			return
 		}
+
+		// items have their own statuses, the multi-status response means that some items are not created
+		statusCode := http.StatusCreated
+		for _, v := range *responseValue {
+			if v.Status != storage.BatchStatusCreated {
+				statusCode = http.StatusMultiStatus
+				break
+			}
+		}
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
+		w.WriteHeader(statusCode)
 
 		if err := json.NewEncoder(w).Encode(&responseValue); err != nil {
 			http.Error(w, "Something went wrong!", http.StatusBadRequest)
@@ -346,6 +778,8 @@ func (h *Handler) PostAPIHandler() http.HandlerFunc {
 		}
 		aliasRequest := &struct {
 			LongURLValue string `json:"url,omitempty" valid:"url"`
+			Alias        string `json:"alias,omitempty"`
+			storage.URLOptions
 		}{}
 
 		if err = json.Unmarshal(bodyRaw, aliasRequest); err != nil {
@@ -359,10 +793,15 @@ func (h *Handler) PostAPIHandler() http.HandlerFunc {
 			return
 		}
 
-		requestValue, err := h.Repo.AddURL(r.Context(), aliasRequest.LongURLValue, storage.ShortURLGenerator(), userID)
+		var requestValue string
+		if aliasRequest.Alias != "" {
+			requestValue, err = h.Repo.AddURL(ctx, aliasRequest.LongURLValue, aliasRequest.Alias, userID, aliasRequest.URLOptions)
+		} else {
+			requestValue, err = storage.AddGeneratedURL(ctx, h.Repo, h.codes, aliasRequest.LongURLValue, userID, aliasRequest.URLOptions)
+		}
 		if err != nil {
 			if !errors.Is(err, storage.ErrDuplicateRecord) {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+				http.Error(w, err.Error(), shortURLErrorStatus(err))
 				return
 			}
 		}
@@ -415,7 +854,7 @@ func (h *Handler) PostHandler() http.HandlerFunc {
 			return
 		}
 
-		requestValue, err := h.Repo.AddURL(r.Context(), aliasRequest.LongURLValue, storage.ShortURLGenerator(), userID)
+		requestValue, err := storage.AddGeneratedURL(ctx, h.Repo, h.codes, aliasRequest.LongURLValue, userID, storage.URLOptions{})
 		if err != nil {
 			if !errors.Is(err, storage.ErrDuplicateRecord) {
 				http.Error(w, err.Error(), http.StatusBadRequest)
@@ -445,26 +884,71 @@ func (h *Handler) PostHandler() http.HandlerFunc {
 // @Router /api/internal/stats [get]
 func (h *Handler) GetInternalStats() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if h.trustedNet == nil {
+		if !h.isTrustedRequest(r) {
 			w.WriteHeader(http.StatusForbidden)
 			return
 		}
 
-		if !h.trustedNet.Contains(net.ParseIP(r.Header.Get("X-Real-IP"))) {
+		stats, err := h.Repo.GetInternalStats(r.Context())
+		if err != nil {
+			http.Error(w, "Something went wrong!", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		if err = json.NewEncoder(w).Encode(&stats); err != nil {
+			http.Error(w, "Something went wrong!", http.StatusBadRequest)
+			return
+		}
+	}
+}
+
+// isTrustedRequest checks that the request came from the trusted subnet.
+func (h *Handler) isTrustedRequest(r *http.Request) bool {
+	return h.trustedNet != nil &&
+		h.trustedNet.Contains(net.ParseIP(r.Header.Get("X-Real-IP")))
+}
+
+// CompactHandler godoc
+// @Summary compact the storage log
+// @Tags Storage
+// @Success 200 {string} string
+// @Failure 403,500,501 {string} string
+// @Router /api/internal/compact [post]
+func (h *Handler) CompactHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.isTrustedRequest(r) {
 			w.WriteHeader(http.StatusForbidden)
 			return
 		}
 
-		stats, err := h.Repo.GetInternalStats(r.Context())
+		compactor, ok := h.Repo.(storage.Compactor)
+		if !ok {
+			http.Error(w, storage.ErrCompactionUnsupported.Error(), http.StatusNotImplemented)
+			return
+		}
+
+		records, err := compactor.Compact(r.Context())
 		if err != nil {
-			http.Error(w, "Something went wrong!", http.StatusBadRequest)
+			if errors.Is(err, storage.ErrCompactionUnsupported) {
+				http.Error(w, err.Error(), http.StatusNotImplemented)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 
-		if err = json.NewEncoder(w).Encode(&stats); err != nil {
+		responseValue := struct {
+			Records int `json:"records"`
+		}{
+			Records: records,
+		}
+		if err = json.NewEncoder(w).Encode(&responseValue); err != nil {
 			http.Error(w, "Something went wrong!", http.StatusBadRequest)
 			return
 		}
@@ -0,0 +1,503 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexkopcak/shortener/internal/storage"
+)
+
+// links import and export formats.
+const (
+	formatCSV    = "csv"    // comma-separated values with the header row
+	formatNDJSON = "ndjson" // JSON object per line
+
+	defaultImportChunkSize = 100
+	exportPageSizeConst    = 500
+	maxImportLineConst     = 1024 * 1024 // max length of the NDJSON line
+)
+
+// links import and export errors.
+var (
+	errFormatUnsupported = errors.New("format must be csv or ndjson")
+	errNoOriginalURL     = errors.New("original_url column is required")
+)
+
+var (
+	// import report CSV columns.
+	importResultColumns = []string{"row", "correlation_id", "short_url", "status", "error"}
+	// export CSV columns.
+	exportColumns = []string{"short_url", "original_url", "created_at", "updated_at", "last_accessed_at"}
+)
+
+type (
+	// type importRow is a parsed row of the links import.
+	// the row which can not be parsed has an error and is not sent to the storage.
+	importRow struct {
+		err    error
+		item   storage.BatchRequest
+		number int
+	}
+
+	// type importResult is the import outcome of the row.
+	importResult struct {
+		Row int `json:"row"`
+		storage.BatchResponse
+	}
+
+	// type exportRow is the exported link.
+	exportRow storage.UserExportType
+
+	// type importReader reads the links import rows, io.EOF is returned after the last row.
+	importReader interface {
+		next() (importRow, error)
+	}
+
+	// type csvImportReader reads the CSV rows, the columns are found by the header row names.
+	csvImportReader struct {
+		reader  *csv.Reader
+		columns map[string]int
+		number  int
+	}
+
+	// type ndjsonImportReader reads the NDJSON rows, empty lines are skipped.
+	ndjsonImportReader struct {
+		scanner *bufio.Scanner
+		number  int
+	}
+
+	// type streamRow is a row of the streamed response.
+	streamRow interface {
+		csvRecord() []string
+	}
+
+	// type streamWriter writes the streamed response rows in CSV or NDJSON format.
+	// the status and the CSV header row are written with the first rows, so the error found before can be sent instead.
+	streamWriter struct {
+		w       http.ResponseWriter
+		csv     *csv.Writer
+		json    *json.Encoder
+		columns []string
+		started bool
+	}
+)
+
+// ImportURLHandler godoc
+// @Summary import user links from CSV or NDJSON, the rows are added by batches and the outcome of every row is streamed back
+// @Description CSV columns are correlation_id, original_url, alias, expires_at, ttl, password and max_clicks, the header row is required.
+// @Description NDJSON lines are the batch request items. The report has the same format as the request,
+// @Description the rows missing at the report end are not imported.
+// @Tags Storage
+// @Accept text/csv,application/x-ndjson
+// @Produce text/csv,application/x-ndjson
+// @Param format query string false "csv or ndjson, the request content type is used by default"
+// @Success 200 {array} importResult
+// @Failure 400,409,415 {string} string
+// @Router /api/user/urls/import [post]
+func (h *Handler) ImportURLHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID, _ := ctx.Value(keyPrincipalID).(int32)
+
+		format, err := importFormat(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+
+		rows, err := newImportReader(format, r.Body)
+		if errors.Is(err, io.EOF) {
+			http.Error(w, "Body are not contain URL!", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		chunkSize := h.Cfg.ImportChunkSize
+		if chunkSize <= 0 {
+			chunkSize = defaultImportChunkSize
+		}
+		report := newStreamWriter(w, format, importResultColumns)
+		chunk := make([]importRow, 0, chunkSize)
+		for {
+			row, err := rows.next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				streamFailed(w, report, err, http.StatusBadRequest)
+				return
+			}
+
+			chunk = append(chunk, row)
+			if len(chunk) < chunkSize {
+				continue
+			}
+			if err = h.importChunk(ctx, report, chunk, userID); err != nil {
+				streamFailed(w, report, err, shortURLErrorStatus(err))
+				return
+			}
+			chunk = chunk[:0]
+		}
+
+		if len(chunk) == 0 && !report.started {
+			http.Error(w, "Body are not contain URL!", http.StatusBadRequest)
+			return
+		}
+		if err = h.importChunk(ctx, report, chunk, userID); err != nil {
+			streamFailed(w, report, err, shortURLErrorStatus(err))
+			return
+		}
+	}
+}
+
+// func importChunk adds the valid rows of the chunk to the storage and writes the outcome of every row.
+func (h *Handler) importChunk(ctx context.Context, report *streamWriter, chunk []importRow, userID int32) error {
+	if len(chunk) == 0 {
+		return nil
+	}
+
+	items := make(storage.BatchRequestArray, 0, len(chunk))
+	for _, v := range chunk {
+		if v.err == nil {
+			items = append(items, v.item)
+		}
+	}
+
+	responses := storage.BatchResponseArray{}
+	if len(items) > 0 {
+		result, err := storage.PostGeneratedBatch(ctx, h.Repo, h.codes, &items, h.Cfg.BaseURL, userID)
+		if err != nil {
+			return err
+		}
+		responses = *result
+	}
+
+	for _, v := range chunk {
+		result := importResult{Row: v.number}
+		if v.err != nil {
+			result.CorrelationID = v.item.CorrelationID
+			result.Status = storage.BatchStatusInvalid
+			result.Error = v.err.Error()
+		} else {
+			result.BatchResponse = responses[0]
+			responses = responses[1:]
+		}
+		if err := report.write(result); err != nil {
+			return err
+		}
+	}
+	return report.flush()
+}
+
+// ExportURLHandler godoc
+// @Summary export all user links with their creation, modification and last access times
+// @Description the links are streamed in CSV or NDJSON format, the filters and the sort order of the user links listing are supported.
+// @Tags Storage
+// @Produce text/csv,application/x-ndjson
+// @Param format query string false "csv or ndjson, the Accept header is used by default, ndjson if it is not set"
+// @Param sort query string false "created_at or short_url"
+// @Param order query string false "asc or desc"
+// @Param domain query string false "host of the original URL or its parent domain"
+// @Param q query string false "substring of the original URL"
+// @Success 200 {array} storage.UserExportType
+// @Failure 400,500 {string} string
+// @Router /api/user/urls/export [get]
+func (h *Handler) ExportURLHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID, _ := ctx.Value(keyPrincipalID).(int32)
+
+		format, err := exportFormat(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		opts, err := listOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts.Cursor = ""
+		opts.Limit = exportPageSizeConst
+
+		export := newStreamWriter(w, format, exportColumns)
+		w.Header().Set("Content-Disposition", `attachment; filename="links.`+format+`"`)
+		for {
+			page, err := h.Repo.ListUserURL(ctx, h.Cfg.BaseURL, userID, opts)
+			if errors.Is(err, storage.ErrListSortInvalid) || errors.Is(err, storage.ErrCursorInvalid) {
+				streamFailed(w, export, err, http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				streamFailed(w, export, err, http.StatusInternalServerError)
+				return
+			}
+
+			for _, v := range page.Items {
+				if err = export.write(exportRow(v)); err != nil {
+					streamFailed(w, export, err, http.StatusInternalServerError)
+					return
+				}
+			}
+			if err = export.flush(); err != nil {
+				streamFailed(w, export, err, http.StatusInternalServerError)
+				return
+			}
+
+			if page.NextCursor == "" {
+				break
+			}
+			opts.Cursor = page.NextCursor
+		}
+		// the user without links gets the CSV header row only
+		if !export.started {
+			export.start()
+			export.flush()
+		}
+	}
+}
+
+// func importFormat returns the import format set by the format query value or by the request content type.
+func importFormat(r *http.Request) (string, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return checkFormat(format)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		return formatCSV, nil
+	case "application/x-ndjson", "application/jsonl":
+		return formatNDJSON, nil
+	}
+	return "", errFormatUnsupported
+}
+
+// func exportFormat returns the export format set by the format query value or by the Accept header, NDJSON is the default.
+func exportFormat(r *http.Request) (string, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return checkFormat(format)
+	}
+	if strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		return formatCSV, nil
+	}
+	return formatNDJSON, nil
+}
+
+// func checkFormat checks the format query value.
+func checkFormat(format string) (string, error) {
+	format = strings.ToLower(format)
+	if format != formatCSV && format != formatNDJSON {
+		return "", errFormatUnsupported
+	}
+	return format, nil
+}
+
+// func streamFailed sends the error if the response is not started,
+// otherwise the response is already partially sent and is cut off.
+func streamFailed(w http.ResponseWriter, s *streamWriter, err error, statusCode int) {
+	if !s.started {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, err.Error(), statusCode)
+		return
+	}
+	s.flush()
+	log.Printf("streamed response is cut off: %v", err)
+}
+
+// func newImportReader returns the rows reader of the format, the CSV header row is read at once.
+func newImportReader(format string, body io.Reader) (importReader, error) {
+	if format == formatNDJSON {
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxImportLineConst)
+		return &ndjsonImportReader{scanner: scanner}, nil
+	}
+
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, v := range header {
+		if i == 0 {
+			v = strings.TrimPrefix(v, "\ufeff") // spreadsheets may start the file with byte order mark
+		}
+		columns[strings.ToLower(strings.TrimSpace(v))] = i
+	}
+	if _, ok := columns["original_url"]; !ok {
+		return nil, errNoOriginalURL
+	}
+	return &csvImportReader{reader: reader, columns: columns}, nil
+}
+
+func (c *csvImportReader) next() (importRow, error) {
+	record, err := c.reader.Read()
+	if errors.Is(err, io.EOF) {
+		return importRow{}, err
+	}
+	c.number++
+	row := importRow{number: c.number}
+
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		row.item.CorrelationID = strconv.Itoa(row.number)
+		row.err = parseErr.Err
+		return row, nil
+	}
+	if err != nil {
+		return importRow{}, err
+	}
+
+	field := func(name string) string {
+		if i, ok := c.columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	row.item = storage.BatchRequest{
+		CorrelationID: field("correlation_id"),
+		OriginalURL:   field("original_url"),
+		Alias:         field("alias"),
+	}
+	row.item.Password = field("password")
+	if row.item.CorrelationID == "" {
+		row.item.CorrelationID = strconv.Itoa(row.number)
+	}
+
+	if value := field("expires_at"); value != "" {
+		expiresAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			row.err = fmt.Errorf("expires_at must be RFC 3339 time: %w", storage.ErrExpirationInvalid)
+			return row, nil
+		}
+		row.item.ExpiresAt = &expiresAt
+	}
+	if value := field("ttl"); value != "" {
+		ttl, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			row.err = fmt.Errorf("ttl must be a number of seconds: %w", storage.ErrExpirationInvalid)
+			return row, nil
+		}
+		row.item.TTL = ttl
+	}
+	if value := field("max_clicks"); value != "" {
+		maxClicks, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			row.err = fmt.Errorf("max_clicks must be a number: %w", storage.ErrMaxClicksInvalid)
+			return row, nil
+		}
+		row.item.MaxClicks = maxClicks
+	}
+	return row, nil
+}
+
+func (n *ndjsonImportReader) next() (importRow, error) {
+	for n.scanner.Scan() {
+		n.number++
+		line := n.scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		row := importRow{number: n.number}
+		if err := json.Unmarshal(line, &row.item); err != nil {
+			row.item = storage.BatchRequest{}
+			row.err = errors.New("line is not a JSON object")
+		}
+		if row.item.CorrelationID == "" {
+			row.item.CorrelationID = strconv.Itoa(row.number)
+		}
+		return row, nil
+	}
+
+	if err := n.scanner.Err(); err != nil {
+		return importRow{}, err
+	}
+	return importRow{}, io.EOF
+}
+
+// func newStreamWriter creates the streamed response writer, the CSV response starts with the columns row.
+func newStreamWriter(w http.ResponseWriter, format string, columns []string) *streamWriter {
+	s := &streamWriter{w: w, columns: columns}
+	if format == formatCSV {
+		s.csv = csv.NewWriter(w)
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		s.json = json.NewEncoder(w)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	return s
+}
+
+// func start writes the response status and the CSV header row once.
+func (s *streamWriter) start() error {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	s.w.WriteHeader(http.StatusOK)
+	if s.csv != nil {
+		return s.csv.Write(s.columns)
+	}
+	return nil
+}
+
+func (s *streamWriter) write(row streamRow) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	if s.csv != nil {
+		return s.csv.Write(row.csvRecord())
+	}
+	return s.json.Encode(row)
+}
+
+// func flush sends the buffered rows to the client.
+func (s *streamWriter) flush() error {
+	if s.csv != nil {
+		s.csv.Flush()
+		if err := s.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func (r importResult) csvRecord() []string {
+	return []string{strconv.Itoa(r.Row), r.CorrelationID, r.ShortURL, r.Status, r.Error}
+}
+
+func (e exportRow) csvRecord() []string {
+	return []string{e.ShortURL, e.OriginalURL, csvTime(e.CreatedAt), csvTime(e.UpdatedAt), csvTime(e.LastAccessedAt)}
+}
+
+// func csvTime formats the link time, the unknown time is empty.
+func csvTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
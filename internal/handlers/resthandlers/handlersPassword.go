@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	handlershelper "github.com/alexkopcak/shortener/internal/handlers"
+	"github.com/alexkopcak/shortener/internal/storage"
+)
+
+const (
+	linkAccessCookieName     = "link_access" // the cookie is scoped to the path of the protected link
+	defaultLinkAccessTTL     = 10 * time.Minute
+	maxPasswordFormBodyConst = 4 << 10
+)
+
+// passwordForm is the page of the protected link, the form is posted to the short URL.
+var passwordForm = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Password required</title>
+</head>
+<body>
+<form method="post" action="/{{.ShortURL}}">
+<p><label for="password">The link is protected by password</label></p>
+<p><input type="password" id="password" name="password" autocomplete="current-password" autofocus required></p>
+{{if .Message}}<p role="alert">{{.Message}}</p>
+{{end}}<p><button type="submit">Open</button></p>
+</form>
+</body>
+</html>
+`))
+
+// type passwordPage is the data of the protected link page.
+type passwordPage struct {
+	ShortURL string
+	Message  string
+}
+
+// PostPasswordHandler godoc
+// @Summary check the password of the protected short URL value
+// @Description the right password sets the short-lived access cookie of the link and redirects to the original URL,
+// @Description the client is locked out after repeated wrong passwords.
+// @Tags Storage
+// @Accept x-www-form-urlencoded
+// @Produce html
+// @Param idValue path string true "idValue"
+// @Param password formData string true "link password"
+// @Success 303 {string} string
+// @Failure 400,403,410,429 {string} string
+// @Router /{idValue} [post]
+func (h *Handler) PostPasswordHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idValue := chi.URLParam(r, "idValue")
+		client := handlershelper.ClientIP(r.RemoteAddr,
+			r.Header.Get("X-Forwarded-For"),
+			r.Header.Get("X-Real-IP"),
+			h.trustedNet)
+
+		if wait := h.passwords.Locked(client, time.Now()); wait > 0 {
+			h.writeLockout(w, idValue, wait)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxPasswordFormBodyConst)
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Bad request!", http.StatusBadRequest)
+			return
+		}
+
+		longURLValue, err := h.Repo.CheckURLPassword(r.Context(), idValue, r.PostFormValue("password"))
+		if errors.Is(err, storage.ErrClicksLimited) {
+			err = h.Repo.ConsumeClick(r.Context(), idValue)
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrPasswordMismatch):
+				if wait := h.passwords.Fail(client, time.Now()); wait > 0 {
+					h.writeLockout(w, idValue, wait)
+					return
+				}
+				h.writePasswordForm(w, idValue, "The password is wrong.", http.StatusForbidden)
+			case linkGone(err):
+				w.WriteHeader(http.StatusGone)
+			default:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		if longURLValue == "" {
+			http.Error(w, "There are no any short Urls!", http.StatusBadRequest)
+			return
+		}
+		h.passwords.Reset(client)
+
+		ttl := h.Cfg.LinkAccessTTL
+		if ttl <= 0 {
+			ttl = defaultLinkAccessTTL
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     linkAccessCookieName,
+			Value:    handlershelper.GenerateLinkToken(h.Cfg.SecretKey, idValue, time.Now().Add(ttl)),
+			Path:     "/" + idValue,
+			MaxAge:   int(ttl.Seconds()),
+			Secure:   h.Cfg.EnableHTTPS,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		w.Header().Set("Location", longURLValue)
+		w.WriteHeader(http.StatusSeeOther)
+		h.recordClick(r, idValue)
+	}
+}
+
+// func linkAccessGranted checks the access cookie of the protected link.
+func (h *Handler) linkAccessGranted(r *http.Request, idValue string) bool {
+	cookie, err := r.Cookie(linkAccessCookieName)
+	if err != nil {
+		return false
+	}
+	return handlershelper.CheckLinkToken(h.Cfg.SecretKey, idValue, cookie.Value, time.Now()) == nil
+}
+
+// func writePasswordForm writes the page of the protected link.
+func (h *Handler) writePasswordForm(w http.ResponseWriter, idValue string, message string, statusCode int) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	if err := passwordForm.Execute(w, passwordPage{ShortURL: idValue, Message: message}); err != nil {
+		log.Printf("password form: %v", err)
+	}
+}
+
+// func writeLockout writes the page of the protected link to the locked out client.
+func (h *Handler) writeLockout(w http.ResponseWriter, idValue string, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	h.writePasswordForm(w, idValue,
+		"Too many wrong passwords, try again in "+strconv.Itoa(seconds)+" seconds.", http.StatusTooManyRequests)
+}
@@ -2,19 +2,29 @@ package handlers
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/alexkopcak/shortener/internal/analytics"
 	"github.com/alexkopcak/shortener/internal/config"
+	handlershelper "github.com/alexkopcak/shortener/internal/handlers"
+	"github.com/alexkopcak/shortener/internal/jobs"
 	"github.com/alexkopcak/shortener/internal/storage"
 )
 
@@ -39,7 +49,7 @@ func TestURLHandler(t *testing.T) {
 		template string
 		body     string
 		method   string
-		repo     storage.Dictionary
+		items    map[string]string
 		want     want
 	}{
 		{
@@ -48,9 +58,7 @@ func TestURLHandler(t *testing.T) {
 			template: "%s",
 			body:     "http://abc.test/abc/abd",
 			method:   http.MethodPost,
-			repo: storage.Dictionary{
-				Items: map[string]string{},
-			},
+			items:    map[string]string{},
 			want: want{
 				contentType: "text/plain; charset=utf-8",
 				statusCode:  http.StatusCreated,
@@ -64,9 +72,7 @@ func TestURLHandler(t *testing.T) {
 			template: "%s",
 			body:     "http://abc2.test/",
 			method:   http.MethodPost,
-			repo: storage.Dictionary{
-				Items: map[string]string{"0": "http://abc.test/abc/abd"},
-			},
+			items:    map[string]string{"0": "http://abc.test/abc/abd"},
 			want: want{
 				contentType: "text/plain; charset=utf-8",
 				statusCode:  http.StatusCreated,
@@ -80,9 +86,7 @@ func TestURLHandler(t *testing.T) {
 			template: "%s",
 			body:     "",
 			method:   http.MethodGet,
-			repo: storage.Dictionary{
-				Items: map[string]string{},
-			},
+			items:    map[string]string{},
 			want: want{
 				contentType: "text/plain; charset=utf-8",
 				statusCode:  http.StatusBadRequest,
@@ -96,9 +100,7 @@ func TestURLHandler(t *testing.T) {
 			template: "%s",
 			body:     "",
 			method:   http.MethodGet,
-			repo: storage.Dictionary{
-				Items: map[string]string{},
-			},
+			items:    map[string]string{},
 			want: want{
 				contentType: "text/plain; charset=utf-8",
 				statusCode:  http.StatusBadRequest,
@@ -112,10 +114,8 @@ func TestURLHandler(t *testing.T) {
 			template: "%s",
 			body:     "",
 			method:   http.MethodConnect,
-			repo: storage.Dictionary{
-				Items: map[string]string{
-					"0": "http://abc.test/abc/abd",
-				},
+			items: map[string]string{
+				"0": "http://abc.test/abc/abd",
 			},
 			want: want{
 				contentType: "text/plain; charset=utf-8",
@@ -130,9 +130,7 @@ func TestURLHandler(t *testing.T) {
 			template: "%s",
 			body:     "",
 			method:   "abracadabra",
-			repo: storage.Dictionary{
-				Items: map[string]string{},
-			},
+			items:    map[string]string{},
 			want: want{
 				contentType: "text/plain; charset=utf-8",
 				statusCode:  http.StatusBadRequest,
@@ -146,9 +144,7 @@ func TestURLHandler(t *testing.T) {
 			template: "%s",
 			body:     "",
 			method:   http.MethodGet,
-			repo: storage.Dictionary{
-				Items: map[string]string{},
-			},
+			items:    map[string]string{},
 			want: want{
 				contentType: "text/plain; charset=utf-8",
 				statusCode:  http.StatusBadRequest,
@@ -162,9 +158,7 @@ func TestURLHandler(t *testing.T) {
 			template: "%s",
 			body:     "",
 			method:   http.MethodGet,
-			repo: storage.Dictionary{
-				Items: map[string]string{},
-			},
+			items:    map[string]string{},
 			want: want{
 				contentType: "text/plain; charset=utf-8",
 				statusCode:  http.StatusBadRequest,
@@ -178,9 +172,7 @@ func TestURLHandler(t *testing.T) {
 			template: "%s",
 			body:     "123",
 			method:   http.MethodPost,
-			repo: storage.Dictionary{
-				Items: map[string]string{},
-			},
+			items:    map[string]string{},
 			want: want{
 				contentType: "text/plain; charset=utf-8",
 				statusCode:  http.StatusBadRequest,
@@ -194,9 +186,7 @@ func TestURLHandler(t *testing.T) {
 			template: "{\"url\": \"%s\"}",
 			body:     "123",
 			method:   http.MethodPost,
-			repo: storage.Dictionary{
-				Items: map[string]string{},
-			},
+			items:    map[string]string{},
 			want: want{
 				contentType: "text/plain; charset=utf-8",
 				statusCode:  http.StatusBadRequest,
@@ -210,9 +200,7 @@ func TestURLHandler(t *testing.T) {
 			template: "%s",
 			body:     "123",
 			method:   http.MethodPost,
-			repo: storage.Dictionary{
-				Items: map[string]string{},
-			},
+			items:    map[string]string{},
 			want: want{
 				contentType: "text/plain; charset=utf-8",
 				statusCode:  http.StatusBadRequest,
@@ -226,9 +214,7 @@ func TestURLHandler(t *testing.T) {
 			template: "{\"url\": %s}",
 			body:     "http://abc/test",
 			method:   http.MethodPost,
-			repo: storage.Dictionary{
-				Items: map[string]string{},
-			},
+			items:    map[string]string{},
 			want: want{
 				contentType: "text/plain; charset=utf-8",
 				statusCode:  http.StatusBadRequest,
@@ -242,9 +228,7 @@ func TestURLHandler(t *testing.T) {
 			template: "{\"url\": \"%s}",
 			body:     "http://abc/test",
 			method:   http.MethodPost,
-			repo: storage.Dictionary{
-				Items: map[string]string{},
-			},
+			items:    map[string]string{},
 			want: want{
 				contentType: "text/plain; charset=utf-8",
 				statusCode:  http.StatusBadRequest,
@@ -258,9 +242,7 @@ func TestURLHandler(t *testing.T) {
 			template: "{\"url\": \"%s\"}",
 			body:     "http://abc/test",
 			method:   http.MethodPost,
-			repo: storage.Dictionary{
-				Items: map[string]string{},
-			},
+			items:    map[string]string{},
 			want: want{
 				contentType: "application/json",
 				statusCode:  http.StatusCreated,
@@ -274,10 +256,7 @@ func TestURLHandler(t *testing.T) {
 			template: "{\"url\": \"%s\"}",
 			body:     "",
 			method:   http.MethodPost,
-			repo: storage.Dictionary{
-				Items:     map[string]string{},
-				UserItems: map[int32][]string{},
-			},
+			items:    map[string]string{},
 			want: want{
 				contentType: "text/plain; charset=utf-8",
 				statusCode:  http.StatusBadRequest,
@@ -291,10 +270,7 @@ func TestURLHandler(t *testing.T) {
 			template: "",
 			body:     "",
 			method:   http.MethodPost,
-			repo: storage.Dictionary{
-				Items:     map[string]string{},
-				UserItems: map[int32][]string{},
-			},
+			items:    map[string]string{},
 			want: want{
 				contentType: "text/plain; charset=utf-8",
 				statusCode:  http.StatusBadRequest,
@@ -314,24 +290,21 @@ func TestURLHandler(t *testing.T) {
 			}
 			w := httptest.NewRecorder()
 
-			dChan := make(chan *storage.DeletedShortURLValues)
-			defer close(dChan)
-
-			d, err := storage.NewDictionary(config.Config{}, &sync.WaitGroup{}, dChan)
+			d, err := storage.NewDictionary(config.Config{}, &sync.WaitGroup{})
 			require.NoError(t, err)
 
-			if len(tt.repo.Items) > 0 {
-				for _, v := range tt.repo.Items {
-					d.AddURL(request.Context(), v, storage.ShortURLGenerator(), 0)
+			if len(tt.items) > 0 {
+				for _, v := range tt.items {
+					d.AddURL(request.Context(), v, storage.ShortURLGenerator(), 0, storage.URLOptions{})
 				}
 			}
 
 			h := http.Server{
-				Handler: NewURLHandler(d, config.Config{
+				Handler: NewURLHandler(d, storage.NewRandomCodeGenerator(storage.DefaultCodeLength), config.Config{
 					BaseURL:        baseURL,
 					SecretKey:      secretKey,
 					CookieAuthName: cookieAuthName,
-				}, dChan),
+				}, nil, nil),
 			}
 			h.Handler.ServeHTTP(w, request)
 			result := w.Result()
@@ -361,15 +334,12 @@ func TestURLHandler(t *testing.T) {
 
 				request2 := httptest.NewRequest(http.MethodGet, string(requestResult), nil)
 				w2 := httptest.NewRecorder()
-				dChan := make(chan *storage.DeletedShortURLValues)
-				defer close(dChan)
-
 				h2 := http.Server{
-					Handler: NewURLHandler(d, config.Config{
+					Handler: NewURLHandler(d, storage.NewRandomCodeGenerator(storage.DefaultCodeLength), config.Config{
 						BaseURL:        baseURL,
 						SecretKey:      secretKey,
 						CookieAuthName: cookieAuthName,
-					}, dChan),
+					}, nil, nil),
 				}
 				h2.Handler.ServeHTTP(w2, request2)
 				result2 := w2.Result()
@@ -415,17 +385,14 @@ func TestCookie(t *testing.T) {
 				request.AddCookie(&http.Cookie{Name: "id", Value: tt.cookie})
 			}
 			w := httptest.NewRecorder()
-			dChan := make(chan *storage.DeletedShortURLValues)
-			defer close(dChan)
-
-			d, err := storage.NewDictionary(config.Config{}, &sync.WaitGroup{}, dChan)
+			d, err := storage.NewDictionary(config.Config{}, &sync.WaitGroup{})
 			require.NoError(t, err)
 			h := http.Server{
-				Handler: NewURLHandler(d, config.Config{
+				Handler: NewURLHandler(d, storage.NewRandomCodeGenerator(storage.DefaultCodeLength), config.Config{
 					BaseURL:        baseURL,
 					SecretKey:      secretKey,
 					CookieAuthName: cookieAuthName,
-				}, dChan),
+				}, nil, nil),
 			}
 			h.Handler.ServeHTTP(w, request)
 			result := w.Result()
@@ -451,6 +418,16 @@ func TestCookie(t *testing.T) {
 	}
 }
 
+// blockingDeleter blocks DeleteUserURL until release channel is closed.
+type blockingDeleter struct {
+	release chan struct{}
+}
+
+func (b *blockingDeleter) DeleteUserURL(ctx context.Context, deletedURLs *storage.DeletedShortURLValues) error {
+	<-b.release
+	return nil
+}
+
 func TestHandler_DeleteUserURLHandler(t *testing.T) {
 	type want struct {
 		body       string
@@ -458,56 +435,78 @@ func TestHandler_DeleteUserURLHandler(t *testing.T) {
 	}
 
 	tests := []struct {
-		name     string
-		target   string
-		template string
-		body     string
-		method   string
-		repo     storage.Dictionary
-		want     want
+		name      string
+		target    string
+		template  string
+		method    string
+		queueSize int
+		full      bool
+		want      want
 	}{
 		{
-			name:     "delete User URL Handler",
-			target:   baseURL + "/api/user/urls",
-			template: "[\"short URL1\",\"short URL2\"]",
-			method:   http.MethodDelete,
-			repo: storage.Dictionary{
-				Items: map[string]string{},
-			},
+			name:      "delete User URL Handler",
+			target:    baseURL + "/api/user/urls",
+			template:  "[\"short URL1\",\"short URL2\"]",
+			method:    http.MethodDelete,
+			queueSize: 10,
 			want: want{
 				statusCode: http.StatusAccepted,
-				body:       "",
 			},
 		},
 		{
-			name:     "delete User URL Handler, bad body",
-			target:   baseURL + "/api/user/urls",
-			template: "[\"short URL1\",\"short URL2\"",
-			method:   http.MethodDelete,
-			repo: storage.Dictionary{
-				Items: map[string]string{},
-			},
+			name:      "delete User URL Handler, bad body",
+			target:    baseURL + "/api/user/urls",
+			template:  "[\"short URL1\",\"short URL2\"",
+			method:    http.MethodDelete,
+			queueSize: 10,
 			want: want{
 				statusCode: http.StatusBadRequest,
 				body:       "unexpected EOF\n",
 			},
 		},
+		{
+			name:      "delete User URL Handler, queue is full",
+			target:    baseURL + "/api/user/urls",
+			template:  "[\"short URL1\"]",
+			method:    http.MethodDelete,
+			queueSize: 1,
+			full:      true,
+			want: want{
+				statusCode: http.StatusServiceUnavailable,
+				body:       jobs.ErrQueueFull.Error() + "\n",
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			request := httptest.NewRequest(tt.method, tt.target, bytes.NewBuffer([]byte(tt.template)))
 			w := httptest.NewRecorder()
-			dChan := make(chan *storage.DeletedShortURLValues)
-			defer close(dChan)
-			d, err := storage.NewDictionary(config.Config{}, &sync.WaitGroup{}, dChan)
+			d, err := storage.NewDictionary(config.Config{}, &sync.WaitGroup{})
 			require.NoError(t, err)
+			var repo jobs.Deleter = d
+			blocked := &blockingDeleter{release: make(chan struct{})}
+			if tt.full {
+				repo = blocked
+			}
+			queue := jobs.NewQueue(jobs.NewMemoryStore(), repo, config.Config{DeleteQueueSize: tt.queueSize, DeleteWorkers: 1})
+			defer func() {
+				close(blocked.release)
+				queue.Close()
+			}()
+			if tt.full {
+				// the worker takes the first job and blocks on the repository, the second one fills the queue
+				require.Eventually(t, func() bool {
+					_, err := queue.Enqueue(context.Background(), &storage.DeletedShortURLValues{ShortURLValues: []string{"short URL0"}})
+					return errors.Is(err, jobs.ErrQueueFull)
+				}, time.Second, time.Millisecond)
+			}
 
 			h := http.Server{
-				Handler: NewURLHandler(d, config.Config{
+				Handler: NewURLHandler(d, storage.NewRandomCodeGenerator(storage.DefaultCodeLength), config.Config{
 					BaseURL:        baseURL,
 					SecretKey:      secretKey,
 					CookieAuthName: cookieAuthName,
-				}, dChan),
+				}, queue, nil),
 			}
 
 			h.Handler.ServeHTTP(w, request)
@@ -517,14 +516,80 @@ func TestHandler_DeleteUserURLHandler(t *testing.T) {
 			assert.Equal(t, tt.want.statusCode, result.StatusCode)
 			requestResult, err := ioutil.ReadAll(result.Body)
 			require.NoError(t, err)
-
-			assert.Equal(t, tt.want.body, string(requestResult))
 			err = result.Body.Close()
 			require.NoError(t, err)
+
+			if tt.want.statusCode != http.StatusAccepted {
+				assert.Equal(t, tt.want.body, string(requestResult))
+				return
+			}
+			job := jobs.Job{}
+			require.NoError(t, json.Unmarshal(requestResult, &job))
+			assert.NotEmpty(t, job.ID)
+			assert.Equal(t, jobs.StatusPending, job.Status)
+			assert.Equal(t, []string{"short URL1", "short URL2"}, job.ShortURLs)
+			assert.Equal(t, "/api/user/jobs/"+job.ID, result.Header.Get("Location"))
 		})
 	}
 }
 
+func TestHandler_GetJobHandler(t *testing.T) {
+	cfg := config.Config{
+		BaseURL:        baseURL,
+		SecretKey:      secretKey,
+		CookieAuthName: cookieAuthName,
+	}
+
+	d, err := storage.NewDictionary(cfg, &sync.WaitGroup{})
+	require.NoError(t, err)
+	queue := jobs.NewQueue(jobs.NewMemoryStore(), d, config.Config{DeleteQueueSize: 10})
+	defer queue.Close()
+
+	h := http.Server{
+		Handler: NewURLHandler(d, storage.NewRandomCodeGenerator(storage.DefaultCodeLength), cfg, queue, nil),
+	}
+
+	send := func(method string, target string, body string, cookies []*http.Cookie) (int, string, []*http.Cookie) {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		for _, v := range cookies {
+			request.AddCookie(v)
+		}
+		w := httptest.NewRecorder()
+		h.Handler.ServeHTTP(w, request)
+		result := w.Result()
+		defer result.Body.Close()
+
+		resultBody, err := ioutil.ReadAll(result.Body)
+		require.NoError(t, err)
+		return result.StatusCode, string(resultBody), result.Cookies()
+	}
+
+	statusCode, _, cookies := send(http.MethodPost, baseURL+"/api/shorten", `{"url":"http://jobs.test/first","alias":"deleted"}`, nil)
+	require.Equal(t, http.StatusCreated, statusCode)
+
+	statusCode, body, _ := send(http.MethodDelete, baseURL+"/api/user/urls", `["deleted"]`, cookies)
+	require.Equal(t, http.StatusAccepted, statusCode)
+	job := jobs.Job{}
+	require.NoError(t, json.Unmarshal([]byte(body), &job))
+
+	require.Eventually(t, func() bool {
+		statusCode, body, _ = send(http.MethodGet, baseURL+"/api/user/jobs/"+job.ID, "", cookies)
+		require.Equal(t, http.StatusOK, statusCode)
+		require.NoError(t, json.Unmarshal([]byte(body), &job))
+		return job.Status == jobs.StatusDone
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, job.Attempts)
+
+	statusCode, _, _ = send(http.MethodGet, baseURL+"/deleted", "", nil)
+	assert.Equal(t, http.StatusGone, statusCode)
+
+	// the job of another user is not found
+	statusCode, _, _ = send(http.MethodGet, baseURL+"/api/user/jobs/"+job.ID, "", nil)
+	assert.Equal(t, http.StatusNotFound, statusCode)
+	statusCode, _, _ = send(http.MethodGet, baseURL+"/api/user/jobs/unknown", "", cookies)
+	assert.Equal(t, http.StatusNotFound, statusCode)
+}
+
 func TestHandler_Ping(t *testing.T) {
 	type want struct {
 		statusCode int
@@ -535,7 +600,6 @@ func TestHandler_Ping(t *testing.T) {
 		target   string
 		template string
 		method   string
-		repo     storage.Dictionary
 		want     want
 	}{
 		{
@@ -543,9 +607,6 @@ func TestHandler_Ping(t *testing.T) {
 			target:   baseURL + "/ping",
 			template: "/",
 			method:   http.MethodGet,
-			repo: storage.Dictionary{
-				Items: map[string]string{},
-			},
 			want: want{
 				statusCode: http.StatusOK,
 			},
@@ -555,23 +616,19 @@ func TestHandler_Ping(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			request := httptest.NewRequest(tt.method, tt.target, bytes.NewBuffer([]byte(tt.template)))
 			w := httptest.NewRecorder()
-			dChan := make(chan *storage.DeletedShortURLValues)
-
-			d, err := storage.NewDictionary(config.Config{}, &sync.WaitGroup{}, dChan)
+			d, err := storage.NewDictionary(config.Config{}, &sync.WaitGroup{})
 			require.NoError(t, err)
 
 			h := http.Server{
-				Handler: NewURLHandler(d, config.Config{
+				Handler: NewURLHandler(d, storage.NewRandomCodeGenerator(storage.DefaultCodeLength), config.Config{
 					BaseURL:        baseURL,
 					SecretKey:      secretKey,
 					CookieAuthName: cookieAuthName,
-				}, dChan),
+				}, nil, nil),
 			}
 
 			h.Handler.ServeHTTP(w, request)
 
-			close(dChan)
-
 			result := w.Result()
 
 			assert.Equal(t, tt.want.statusCode, result.StatusCode)
@@ -592,7 +649,6 @@ func TestHandler_GetAPIAllURLHandler(t *testing.T) {
 		target      string
 		method      string
 		originalURL string
-		repo        storage.Dictionary
 		want        want
 	}{
 		{
@@ -600,9 +656,6 @@ func TestHandler_GetAPIAllURLHandler(t *testing.T) {
 			target:      baseURL + "/api/user/urls",
 			method:      http.MethodGet,
 			originalURL: "",
-			repo: storage.Dictionary{
-				Items: map[string]string{},
-			},
 			want: want{
 				contentType: "text/plain; charset=utf-8",
 				statusCode:  http.StatusNoContent,
@@ -613,10 +666,6 @@ func TestHandler_GetAPIAllURLHandler(t *testing.T) {
 			target:      baseURL + "/api/user/urls",
 			method:      http.MethodGet,
 			originalURL: "http://original.url",
-			repo: storage.Dictionary{
-				Items:     map[string]string{},
-				UserItems: map[int32][]string{},
-			},
 			want: want{
 				contentType: "application/json",
 				statusCode:  http.StatusOK,
@@ -635,15 +684,15 @@ func TestHandler_GetAPIAllURLHandler(t *testing.T) {
 			request := httptest.NewRequest(tt.method, tt.target, nil)
 			w := httptest.NewRecorder()
 
-			dChan := make(chan *storage.DeletedShortURLValues)
-			defer close(dChan)
+			d, err := storage.NewDictionary(config.Config{}, &sync.WaitGroup{})
+			require.NoError(t, err)
 
 			h := http.Server{
-				Handler: NewURLHandler(&tt.repo, config.Config{
+				Handler: NewURLHandler(d, storage.NewRandomCodeGenerator(storage.DefaultCodeLength), config.Config{
 					BaseURL:        baseURL,
 					SecretKey:      secretKey,
 					CookieAuthName: cookieAuthName,
-				}, dChan),
+				}, nil, nil),
 			}
 
 			if tt.originalURL != "" {
@@ -668,6 +717,7 @@ func TestHandler_PostAPIBatchHandler(t *testing.T) {
 	type want struct {
 		contentType string
 		body        string
+		statuses    []string
 		statusCode  int
 	}
 
@@ -675,7 +725,6 @@ func TestHandler_PostAPIBatchHandler(t *testing.T) {
 		name   string
 		target string
 		method string
-		repo   storage.Dictionary
 		item   *storage.BatchRequestArray
 		want   want
 	}{
@@ -683,11 +732,10 @@ func TestHandler_PostAPIBatchHandler(t *testing.T) {
 			name:   "set batch values with api",
 			target: baseURL + "/api/shorten/batch",
 			method: http.MethodPost,
-			repo:   storage.Dictionary{},
 			item: &storage.BatchRequestArray{
 				storage.BatchRequest{
 					CorrelationID: "1",
-					OriginalURL:   "http:\\test.tst",
+					OriginalURL:   "http://test.tst",
 				},
 			},
 			want: want{
@@ -696,11 +744,31 @@ func TestHandler_PostAPIBatchHandler(t *testing.T) {
 				body:        "[]",
 			},
 		},
+		{
+			name:   "set batch values with invalid url",
+			target: baseURL + "/api/shorten/batch",
+			method: http.MethodPost,
+			item: &storage.BatchRequestArray{
+				storage.BatchRequest{
+					CorrelationID: "1",
+					OriginalURL:   "http://test.tst",
+				},
+				storage.BatchRequest{
+					CorrelationID: "2",
+					OriginalURL:   "http:\\test.tst",
+				},
+			},
+			want: want{
+				contentType: "application/json",
+				statusCode:  http.StatusMultiStatus,
+				body:        "[]",
+				statuses:    []string{storage.BatchStatusCreated, storage.BatchStatusInvalid},
+			},
+		},
 		{
 			name:   "set batch values with api empty body",
 			target: baseURL + "/api/shorten/batch",
 			method: http.MethodPost,
-			repo:   storage.Dictionary{},
 			item:   nil,
 			want: want{
 				contentType: "text/plain; charset=utf-8",
@@ -723,19 +791,17 @@ func TestHandler_PostAPIBatchHandler(t *testing.T) {
 			request := httptest.NewRequest(tt.method, tt.target, bytes.NewBuffer(body))
 			w := httptest.NewRecorder()
 
-			dChan := make(chan *storage.DeletedShortURLValues)
-			defer close(dChan)
 			cfg := config.Config{
 				BaseURL:        baseURL,
 				SecretKey:      secretKey,
 				CookieAuthName: cookieAuthName,
 			}
 
-			d, err := storage.NewDictionary(cfg, &sync.WaitGroup{}, dChan)
+			d, err := storage.NewDictionary(cfg, &sync.WaitGroup{})
 			require.NoError(t, err)
 
 			h := http.Server{
-				Handler: NewURLHandler(d, cfg, dChan),
+				Handler: NewURLHandler(d, storage.NewRandomCodeGenerator(storage.DefaultCodeLength), cfg, nil, nil),
 			}
 
 			h.Handler.ServeHTTP(w, request)
@@ -745,6 +811,15 @@ func TestHandler_PostAPIBatchHandler(t *testing.T) {
 			assert.Equal(t, tt.want.statusCode, result.StatusCode)
 			require.NoError(t, err)
 
+			if tt.want.statuses != nil {
+				response := storage.BatchResponseArray{}
+				require.NoError(t, json.NewDecoder(result.Body).Decode(&response))
+				require.Len(t, response, len(tt.want.statuses))
+				for i, v := range response {
+					assert.Equal(t, tt.want.statuses[i], v.Status)
+				}
+			}
+
 			err = result.Body.Close()
 			require.NoError(t, err)
 
@@ -807,9 +882,6 @@ func TestHandler_GetInternalStats(t *testing.T) {
 			request.Header.Add("X-Real-IP", tt.realipRequest)
 			w := httptest.NewRecorder()
 
-			dChan := make(chan *storage.DeletedShortURLValues)
-			defer close(dChan)
-
 			cfg := config.Config{
 				BaseURL:        baseURL,
 				SecretKey:      secretKey,
@@ -817,11 +889,11 @@ func TestHandler_GetInternalStats(t *testing.T) {
 				TrustedSubnet:  tt.trustedNet,
 			}
 
-			d, err := storage.NewDictionary(cfg, &sync.WaitGroup{}, dChan)
+			d, err := storage.NewDictionary(cfg, &sync.WaitGroup{})
 			require.NoError(t, err)
 
 			h := http.Server{
-				Handler: NewURLHandler(d, cfg, dChan),
+				Handler: NewURLHandler(d, storage.NewRandomCodeGenerator(storage.DefaultCodeLength), cfg, nil, nil),
 			}
 
 			h.Handler.ServeHTTP(w, request)
@@ -835,6 +907,71 @@ func TestHandler_GetInternalStats(t *testing.T) {
 	}
 }
 
+func TestHandler_CompactHandler(t *testing.T) {
+	tests := []struct {
+		name          string
+		realipRequest string
+		fileStorage   bool
+		statusCode    int
+	}{
+		{
+			name:          "not trusted request",
+			realipRequest: "192.168.0.1",
+			fileStorage:   true,
+			statusCode:    http.StatusForbidden,
+		},
+		{
+			name:          "memory storage without file",
+			realipRequest: "10.0.0.1",
+			fileStorage:   false,
+			statusCode:    http.StatusNotImplemented,
+		},
+		{
+			name:          "compact file storage",
+			realipRequest: "10.0.0.1",
+			fileStorage:   true,
+			statusCode:    http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, baseURL+"/api/internal/compact", nil)
+			request.Header.Add("X-Real-IP", tt.realipRequest)
+			w := httptest.NewRecorder()
+
+			cfg := config.Config{
+				BaseURL:        baseURL,
+				SecretKey:      secretKey,
+				CookieAuthName: cookieAuthName,
+				TrustedSubnet:  "10.0.0.0/8",
+			}
+			if tt.fileStorage {
+				cfg.FileStoragePath = filepath.Join(t.TempDir(), "localStorage.test")
+			}
+
+			d, err := storage.NewDictionary(cfg, &sync.WaitGroup{})
+			require.NoError(t, err)
+			_, err = d.AddURL(context.Background(), "http://abc.test/compact", "compact", 1, storage.URLOptions{})
+			require.NoError(t, err)
+
+			h := http.Server{
+				Handler: NewURLHandler(d, storage.NewRandomCodeGenerator(storage.DefaultCodeLength), cfg, nil, nil),
+			}
+			h.Handler.ServeHTTP(w, request)
+
+			result := w.Result()
+			body, err := io.ReadAll(result.Body)
+			require.NoError(t, err)
+			result.Body.Close()
+
+			assert.Equal(t, tt.statusCode, result.StatusCode)
+			if tt.statusCode == http.StatusOK {
+				assert.JSONEq(t, `{"records":1}`, string(body))
+			}
+		})
+	}
+}
+
 func TestMiddleware_gzip(t *testing.T) {
 	type want struct {
 		contentType string
@@ -872,20 +1009,17 @@ func TestMiddleware_gzip(t *testing.T) {
 			request.Header.Add("Accept-Encoding", tt.acceptEncoding)
 			w := httptest.NewRecorder()
 
-			dChan := make(chan *storage.DeletedShortURLValues)
-			defer close(dChan)
-
 			cfg := config.Config{
 				BaseURL:        baseURL,
 				SecretKey:      secretKey,
 				CookieAuthName: cookieAuthName,
 			}
 
-			d, err := storage.NewDictionary(cfg, &sync.WaitGroup{}, dChan)
+			d, err := storage.NewDictionary(cfg, &sync.WaitGroup{})
 			require.NoError(t, err)
 
 			h := http.Server{
-				Handler: NewURLHandler(d, cfg, dChan),
+				Handler: NewURLHandler(d, storage.NewRandomCodeGenerator(storage.DefaultCodeLength), cfg, nil, nil),
 			}
 
 			h.Handler.ServeHTTP(w, request)
@@ -899,3 +1033,974 @@ func TestMiddleware_gzip(t *testing.T) {
 	}
 
 }
+
+func TestMiddleware_gzipFlush(t *testing.T) {
+	w := httptest.NewRecorder()
+	flushed := 0
+	handler := gzipMiddlewareHandle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, row := range []string{"first row\n", "second row\n"} {
+			_, err := w.Write([]byte(row))
+			require.NoError(t, err)
+			f, ok := w.(http.Flusher)
+			require.True(t, ok, "the compressed response may be streamed")
+			f.Flush()
+		}
+		flushed = w.(gzipWriter).ResponseWriter.(*httptest.ResponseRecorder).Body.Len()
+	}))
+
+	request := httptest.NewRequest(http.MethodGet, baseURL+"/api/user/urls/export", nil)
+	request.Header.Add("Accept-Encoding", "gzip")
+	handler.ServeHTTP(w, request)
+
+	// the rows are sent to the client before the handler returns
+	assert.True(t, w.Flushed)
+	assert.NotZero(t, flushed)
+
+	result := w.Result()
+	defer result.Body.Close()
+	assert.Equal(t, "gzip", result.Header.Get("Content-Encoding"))
+	gzr, err := gzip.NewReader(result.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(gzr)
+	require.NoError(t, err)
+	assert.Equal(t, "first row\nsecond row\n", string(body))
+}
+
+func TestHandler_PostAPIHandlerAlias(t *testing.T) {
+	type want struct {
+		body       string
+		statusCode int
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want want
+	}{
+		{
+			name: "custom short URL value",
+			body: `{"url":"http://alias.test/report","alias":"q3-report"}`,
+			want: want{
+				statusCode: http.StatusCreated,
+				body:       `{"result":"` + baseURL + `/q3-report"}` + "\n",
+			},
+		},
+		{
+			name: "short URL value already taken",
+			body: `{"url":"http://alias.test/other","alias":"q3-report"}`,
+			want: want{
+				statusCode: http.StatusConflict,
+				body:       storage.ErrShortURLTaken.Error() + "\n",
+			},
+		},
+		{
+			name: "reserved short URL value",
+			body: `{"url":"http://alias.test/other","alias":"ping"}`,
+			want: want{
+				statusCode: http.StatusBadRequest,
+				body:       storage.ErrAliasReserved.Error() + "\n",
+			},
+		},
+		{
+			name: "bad short URL value",
+			body: `{"url":"http://alias.test/other","alias":"q3 report"}`,
+			want: want{
+				statusCode: http.StatusBadRequest,
+				body:       storage.ErrAliasInvalid.Error() + "\n",
+			},
+		},
+	}
+
+	cfg := config.Config{
+		BaseURL:        baseURL,
+		SecretKey:      secretKey,
+		CookieAuthName: cookieAuthName,
+	}
+
+	d, err := storage.NewDictionary(cfg, &sync.WaitGroup{})
+	require.NoError(t, err)
+
+	h := http.Server{
+		Handler: NewURLHandler(d, storage.NewRandomCodeGenerator(storage.DefaultCodeLength), cfg, nil, nil),
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, baseURL+"/api/shorten", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			h.Handler.ServeHTTP(w, request)
+
+			result := w.Result()
+			body, err := ioutil.ReadAll(result.Body)
+			require.NoError(t, err)
+			err = result.Body.Close()
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want.statusCode, result.StatusCode)
+			assert.Equal(t, tt.want.body, string(body))
+		})
+	}
+}
+
+func TestHandler_GetHandlerExpired(t *testing.T) {
+	cfg := config.Config{
+		BaseURL:        baseURL,
+		SecretKey:      secretKey,
+		CookieAuthName: cookieAuthName,
+	}
+
+	d, err := storage.NewDictionary(cfg, &sync.WaitGroup{})
+	require.NoError(t, err)
+
+	h := http.Server{
+		Handler: NewURLHandler(d, storage.NewRandomCodeGenerator(storage.DefaultCodeLength), cfg, nil, nil),
+	}
+
+	request := httptest.NewRequest(http.MethodPost, baseURL+"/api/shorten",
+		strings.NewReader(`{"url":"http://expired.test","alias":"expired","ttl":1}`))
+	w := httptest.NewRecorder()
+	h.Handler.ServeHTTP(w, request)
+	result := w.Result()
+	require.NoError(t, result.Body.Close())
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+
+	request = httptest.NewRequest(http.MethodGet, baseURL+"/expired", nil)
+	w = httptest.NewRecorder()
+	h.Handler.ServeHTTP(w, request)
+	result = w.Result()
+	require.NoError(t, result.Body.Close())
+	assert.Equal(t, http.StatusTemporaryRedirect, result.StatusCode)
+
+	time.Sleep(1100 * time.Millisecond)
+
+	request = httptest.NewRequest(http.MethodGet, baseURL+"/expired", nil)
+	w = httptest.NewRecorder()
+	h.Handler.ServeHTTP(w, request)
+	result = w.Result()
+	require.NoError(t, result.Body.Close())
+	assert.Equal(t, http.StatusGone, result.StatusCode)
+
+	request = httptest.NewRequest(http.MethodPost, baseURL+"/api/shorten",
+		strings.NewReader(`{"url":"http://expired.test/2","expires_at":"2000-01-01T00:00:00Z"}`))
+	w = httptest.NewRecorder()
+	h.Handler.ServeHTTP(w, request)
+	result = w.Result()
+	require.NoError(t, result.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+}
+
+func TestHandler_GetQRCodeHandler(t *testing.T) {
+	cfg := config.Config{
+		BaseURL:        baseURL,
+		SecretKey:      secretKey,
+		CookieAuthName: cookieAuthName,
+	}
+
+	d, err := storage.NewDictionary(cfg, &sync.WaitGroup{})
+	require.NoError(t, err)
+	_, err = d.AddURL(context.Background(), "http://qr.test", "abcde", 1, storage.URLOptions{})
+	require.NoError(t, err)
+
+	h := http.Server{
+		Handler: NewURLHandler(d, storage.NewRandomCodeGenerator(storage.DefaultCodeLength), cfg, nil, nil),
+	}
+
+	tests := []struct {
+		name        string
+		target      string
+		status      int
+		contentType string
+		format      string
+	}{
+		{
+			name:        "png by default",
+			target:      "/abcde/qr",
+			status:      http.StatusOK,
+			contentType: "image/png",
+			format:      handlershelper.QRFormatPNG,
+		},
+		{
+			name:        "svg with settings",
+			target:      "/abcde/qr?format=svg&size=300&level=H&margin=2",
+			status:      http.StatusOK,
+			contentType: "image/svg+xml",
+			format:      handlershelper.QRFormatSVG,
+		},
+		{
+			name:   "invalid settings",
+			target: "/abcde/qr?level=X",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "size is too small",
+			target: "/abcde/qr?size=10",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "unknown short URL",
+			target: "/fghij/qr",
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, baseURL+tt.target, nil)
+			w := httptest.NewRecorder()
+			h.Handler.ServeHTTP(w, request)
+			result := w.Result()
+			body, err := io.ReadAll(result.Body)
+			require.NoError(t, err)
+			require.NoError(t, result.Body.Close())
+
+			require.Equal(t, tt.status, result.StatusCode)
+			if tt.status != http.StatusOK {
+				return
+			}
+			assert.Equal(t, tt.contentType, result.Header.Get("Content-Type"))
+
+			opts, err := handlershelper.ParseQROptions(tt.format, request.URL.Query().Get("size"),
+				request.URL.Query().Get("level"), request.URL.Query().Get("margin"))
+			require.NoError(t, err)
+			want, _, err := handlershelper.RenderQRCode(baseURL+"/abcde", opts)
+			require.NoError(t, err)
+			assert.Equal(t, want, body)
+		})
+	}
+}
+
+func TestHandler_PasswordProtectedURL(t *testing.T) {
+	cfg := config.Config{
+		BaseURL:             baseURL,
+		SecretKey:           secretKey,
+		CookieAuthName:      cookieAuthName,
+		LinkAccessTTL:       time.Minute,
+		PasswordMaxFailures: 3,
+		PasswordLockout:     time.Minute,
+	}
+
+	d, err := storage.NewDictionary(cfg, &sync.WaitGroup{})
+	require.NoError(t, err)
+	h := http.Server{
+		Handler: NewURLHandler(d, storage.NewRandomCodeGenerator(storage.DefaultCodeLength), cfg, nil, nil),
+	}
+
+	do := func(method string, target string, body string, remoteAddr string, cookies ...*http.Cookie) (*http.Response, string) {
+		request := httptest.NewRequest(method, baseURL+target, strings.NewReader(body))
+		if method == http.MethodPost && target != "/api/shorten" {
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		request.RemoteAddr = remoteAddr
+		for _, c := range cookies {
+			request.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		h.Handler.ServeHTTP(w, request)
+		result := w.Result()
+		data, err := io.ReadAll(result.Body)
+		require.NoError(t, err)
+		require.NoError(t, result.Body.Close())
+		return result, string(data)
+	}
+
+	result, _ := do(http.MethodPost, "/api/shorten",
+		`{"url":"http://internal.test/doc","alias":"secret-doc","password":"open sesame"}`, "192.0.2.1:1234")
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+
+	result, _ = do(http.MethodPost, "/api/shorten",
+		`{"url":"http://internal.test/other","password":"`+strings.Repeat("p", 73)+`"}`, "192.0.2.1:1234")
+	require.Equal(t, http.StatusBadRequest, result.StatusCode, "bcrypt password is 72 bytes at most")
+
+	// the form is served instead of the redirect
+	result, body := do(http.MethodGet, "/secret-doc", "", "192.0.2.1:1234")
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", result.Header.Get("Content-Type"))
+	assert.Empty(t, result.Header.Get("Location"))
+	assert.Contains(t, body, `action="/secret-doc"`)
+	assert.NotContains(t, body, "internal.test")
+
+	result, body = do(http.MethodPost, "/secret-doc", "password=wrong", "192.0.2.1:1234")
+	require.Equal(t, http.StatusForbidden, result.StatusCode)
+	assert.Contains(t, body, "The password is wrong.")
+
+	// the right password issues the access cookie of the link
+	result, _ = do(http.MethodPost, "/secret-doc", "password=open+sesame", "192.0.2.1:1234")
+	require.Equal(t, http.StatusSeeOther, result.StatusCode)
+	assert.Equal(t, "http://internal.test/doc", result.Header.Get("Location"))
+	var access *http.Cookie
+	for _, c := range result.Cookies() {
+		if c.Name == linkAccessCookieName {
+			access = c
+		}
+	}
+	require.NotNil(t, access)
+	assert.Equal(t, "/secret-doc", access.Path)
+	assert.True(t, access.HttpOnly)
+	assert.Equal(t, 60, access.MaxAge)
+
+	result, _ = do(http.MethodGet, "/secret-doc", "", "192.0.2.2:1234", access)
+	require.Equal(t, http.StatusTemporaryRedirect, result.StatusCode)
+	assert.Equal(t, "http://internal.test/doc", result.Header.Get("Location"))
+
+	// the cookie of one link does not open another one
+	_, err = d.AddURL(context.Background(), "http://internal.test/plan", "secret-plan", 1, storage.URLOptions{Password: "plan"})
+	require.NoError(t, err)
+	result, _ = do(http.MethodGet, "/secret-plan", "", "192.0.2.1:1234", access)
+	require.Equal(t, http.StatusOK, result.StatusCode)
+
+	forged := *access
+	forged.Value = handlershelper.GenerateLinkToken("other secret", "secret-doc", time.Now().Add(time.Minute))
+	result, _ = do(http.MethodGet, "/secret-doc", "", "192.0.2.1:1234", &forged)
+	require.Equal(t, http.StatusOK, result.StatusCode)
+
+	// repeated failures lock the client out
+	for i := 0; i < 2; i++ {
+		result, _ = do(http.MethodPost, "/secret-plan", "password=wrong", "198.51.100.7:1234")
+		require.Equal(t, http.StatusForbidden, result.StatusCode)
+	}
+	result, _ = do(http.MethodPost, "/secret-plan", "password=wrong", "198.51.100.7:1234")
+	require.Equal(t, http.StatusTooManyRequests, result.StatusCode)
+	assert.Equal(t, "60", result.Header.Get("Retry-After"))
+	result, _ = do(http.MethodPost, "/secret-plan", "password=plan", "198.51.100.7:4321")
+	require.Equal(t, http.StatusTooManyRequests, result.StatusCode, "the right password is not checked while the client is locked out")
+	result, _ = do(http.MethodPost, "/secret-plan", "password=plan", "192.0.2.1:1234")
+	require.Equal(t, http.StatusSeeOther, result.StatusCode, "other clients are not locked out")
+
+	// unknown links
+	result, _ = do(http.MethodPost, "/fghij", "password=plan", "192.0.2.1:1234")
+	require.Equal(t, http.StatusBadRequest, result.StatusCode)
+
+	// the QR code holds the short URL only
+	result, _ = do(http.MethodGet, "/secret-doc/qr", "", "192.0.2.1:1234")
+	require.Equal(t, http.StatusOK, result.StatusCode)
+}
+
+func TestHandler_MaxClicksURL(t *testing.T) {
+	cfg := config.Config{
+		BaseURL:        baseURL,
+		SecretKey:      secretKey,
+		CookieAuthName: cookieAuthName,
+		LinkAccessTTL:  time.Minute,
+	}
+
+	d, err := storage.NewDictionary(cfg, &sync.WaitGroup{})
+	require.NoError(t, err)
+	h := http.Server{
+		Handler: NewURLHandler(d, storage.NewRandomCodeGenerator(storage.DefaultCodeLength), cfg, nil, nil),
+	}
+
+	send := func(method string, target string, body string, cookies []*http.Cookie) (*http.Response, string) {
+		request := httptest.NewRequest(method, baseURL+target, strings.NewReader(body))
+		if method == http.MethodPost && target != "/api/shorten" {
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		for _, v := range cookies {
+			request.AddCookie(v)
+		}
+		w := httptest.NewRecorder()
+		h.Handler.ServeHTTP(w, request)
+		result := w.Result()
+		data, err := io.ReadAll(result.Body)
+		require.NoError(t, err)
+		require.NoError(t, result.Body.Close())
+		return result, string(data)
+	}
+
+	result, _ := send(http.MethodPost, "/api/shorten", `{"url":"http://download.test/file","alias":"download","max_clicks":2}`, nil)
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+	cookies := result.Cookies()
+
+	result, _ = send(http.MethodPost, "/api/shorten", `{"url":"http://download.test/other","max_clicks":-1}`, cookies)
+	require.Equal(t, http.StatusBadRequest, result.StatusCode)
+
+	for i := 0; i < 2; i++ {
+		result, _ = send(http.MethodGet, "/download", "", nil)
+		require.Equal(t, http.StatusTemporaryRedirect, result.StatusCode)
+		assert.Equal(t, "http://download.test/file", result.Header.Get("Location"))
+	}
+	result, _ = send(http.MethodGet, "/download", "", nil)
+	require.Equal(t, http.StatusGone, result.StatusCode, "the clicks are used up")
+	result, _ = send(http.MethodGet, "/download/qr", "", nil)
+	require.Equal(t, http.StatusGone, result.StatusCode)
+
+	// the owner sees the clicks left
+	result, body := send(http.MethodGet, "/api/user/urls", "", cookies)
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Contains(t, body, `"clicks_left":0`)
+
+	// the protected link uses up its click after the password check
+	_, err = d.AddURL(context.Background(), "http://download.test/secret", "once", 1, storage.URLOptions{Password: "once", MaxClicks: 1})
+	require.NoError(t, err)
+	result, _ = send(http.MethodGet, "/once", "", nil)
+	require.Equal(t, http.StatusOK, result.StatusCode, "the form does not use up the click")
+	result, _ = send(http.MethodPost, "/once", "password=once", nil)
+	require.Equal(t, http.StatusSeeOther, result.StatusCode)
+	assert.Equal(t, "http://download.test/secret", result.Header.Get("Location"))
+	result, _ = send(http.MethodGet, "/once", "", result.Cookies())
+	require.Equal(t, http.StatusGone, result.StatusCode, "the access cookie does not add clicks")
+}
+
+func TestHandler_GetURLStatsHandler(t *testing.T) {
+	cfg := config.Config{
+		BaseURL:        baseURL,
+		SecretKey:      secretKey,
+		CookieAuthName: cookieAuthName,
+	}
+
+	d, err := storage.NewDictionary(cfg, &sync.WaitGroup{})
+	require.NoError(t, err)
+
+	clicks := analytics.NewRecorder(analytics.NewMemoryStore(), config.Config{
+		ClicksBufferSize:    10,
+		ClicksBatchSize:     1,
+		ClicksFlushInterval: time.Second,
+	})
+	defer clicks.Close()
+
+	h := http.Server{
+		Handler: NewURLHandler(d, storage.NewRandomCodeGenerator(storage.DefaultCodeLength), cfg, nil, clicks),
+	}
+
+	request := httptest.NewRequest(http.MethodPost, baseURL+"/api/shorten",
+		strings.NewReader(`{"url":"http://stats.test","alias":"stats"}`))
+	w := httptest.NewRecorder()
+	h.Handler.ServeHTTP(w, request)
+	result := w.Result()
+	require.NoError(t, result.Body.Close())
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+	cookies := result.Cookies()
+
+	for i := 0; i < 2; i++ {
+		request = httptest.NewRequest(http.MethodGet, baseURL+"/stats", nil)
+		w = httptest.NewRecorder()
+		h.Handler.ServeHTTP(w, request)
+		result = w.Result()
+		require.NoError(t, result.Body.Close())
+		require.Equal(t, http.StatusTemporaryRedirect, result.StatusCode)
+	}
+
+	getStats := func(target string, cookies []*http.Cookie) (*http.Response, analytics.Stats) {
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		for _, v := range cookies {
+			request.AddCookie(v)
+		}
+		w := httptest.NewRecorder()
+		h.Handler.ServeHTTP(w, request)
+		result := w.Result()
+		defer result.Body.Close()
+
+		stats := analytics.Stats{}
+		if result.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(result.Body).Decode(&stats))
+		}
+		return result, stats
+	}
+
+	require.Eventually(t, func() bool {
+		result, stats := getStats(baseURL+"/api/user/urls/stats/stats", cookies)
+		return result.StatusCode == http.StatusOK && stats.Total == 2 && len(stats.Daily) == 1
+	}, 3*time.Second, 50*time.Millisecond)
+
+	result, _ = getStats(baseURL+"/api/user/urls/stats/stats", nil)
+	assert.Equal(t, http.StatusForbidden, result.StatusCode)
+
+	result, _ = getStats(baseURL+"/api/user/urls/unknown/stats", cookies)
+	assert.Equal(t, http.StatusNotFound, result.StatusCode)
+}
+
+func TestHandler_RestoreUserURLHandler(t *testing.T) {
+	cfg := config.Config{
+		BaseURL:        baseURL,
+		SecretKey:      secretKey,
+		CookieAuthName: cookieAuthName,
+	}
+
+	d, err := storage.NewDictionary(cfg, &sync.WaitGroup{})
+	require.NoError(t, err)
+	queue := jobs.NewQueue(jobs.NewMemoryStore(), d, config.Config{DeleteQueueSize: 10})
+	defer queue.Close()
+
+	h := http.Server{
+		Handler: NewURLHandler(d, storage.NewRandomCodeGenerator(storage.DefaultCodeLength), cfg, queue, nil),
+	}
+
+	send := func(method string, target string, body string, cookies []*http.Cookie) (int, string, []*http.Cookie) {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		for _, v := range cookies {
+			request.AddCookie(v)
+		}
+		w := httptest.NewRecorder()
+		h.Handler.ServeHTTP(w, request)
+		result := w.Result()
+		defer result.Body.Close()
+
+		resultBody, err := ioutil.ReadAll(result.Body)
+		require.NoError(t, err)
+		return result.StatusCode, string(resultBody), result.Cookies()
+	}
+
+	statusCode, _, cookies := send(http.MethodPost, baseURL+"/api/shorten", `{"url":"http://restore.test","alias":"restore"}`, nil)
+	require.Equal(t, http.StatusCreated, statusCode)
+
+	statusCode, _, _ = send(http.MethodDelete, baseURL+"/api/user/urls", `["restore"]`, cookies)
+	require.Equal(t, http.StatusAccepted, statusCode)
+	require.Eventually(t, func() bool {
+		statusCode, _, _ := send(http.MethodGet, baseURL+"/restore", "", nil)
+		return statusCode == http.StatusGone
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// only the owner restores the link
+	statusCode, body, _ := send(http.MethodPost, baseURL+"/api/user/urls/restore", `["restore"]`, nil)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "[]\n", body)
+
+	statusCode, body, _ = send(http.MethodPost, baseURL+"/api/user/urls/restore", `["restore"`, cookies)
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	assert.Equal(t, "unexpected EOF\n", body)
+
+	statusCode, body, _ = send(http.MethodPost, baseURL+"/api/user/urls/restore", `["restore","unknown"]`, cookies)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "[\"restore\"]\n", body)
+
+	statusCode, _, _ = send(http.MethodGet, baseURL+"/restore", "", nil)
+	assert.Equal(t, http.StatusTemporaryRedirect, statusCode)
+}
+
+func TestHandler_UpdateURLHandler(t *testing.T) {
+	cfg := config.Config{
+		BaseURL:        baseURL,
+		SecretKey:      secretKey,
+		CookieAuthName: cookieAuthName,
+	}
+
+	d, err := storage.NewDictionary(cfg, &sync.WaitGroup{})
+	require.NoError(t, err)
+
+	h := http.Server{
+		Handler: NewURLHandler(d, storage.NewRandomCodeGenerator(storage.DefaultCodeLength), cfg, nil, nil),
+	}
+
+	send := func(method string, target string, body string, cookies []*http.Cookie) (int, string, []*http.Cookie) {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		for _, v := range cookies {
+			request.AddCookie(v)
+		}
+		w := httptest.NewRecorder()
+		h.Handler.ServeHTTP(w, request)
+		result := w.Result()
+		defer result.Body.Close()
+
+		resultBody, err := ioutil.ReadAll(result.Body)
+		require.NoError(t, err)
+		return result.StatusCode, string(resultBody), result.Cookies()
+	}
+
+	statusCode, _, cookies := send(http.MethodPost, baseURL+"/api/shorten", `{"url":"http://update.test/first","alias":"update"}`, nil)
+	require.Equal(t, http.StatusCreated, statusCode)
+	statusCode, _, _ = send(http.MethodPost, baseURL+"/api/shorten", `{"url":"http://update.test/other","alias":"other"}`, cookies)
+	require.Equal(t, http.StatusCreated, statusCode)
+
+	version := storage.URLVersion{}
+	statusCode, body, _ := send(http.MethodPatch, baseURL+"/api/user/urls/update", `{"url":"http://update.test/second"}`, cookies)
+	require.Equal(t, http.StatusOK, statusCode)
+	require.NoError(t, json.Unmarshal([]byte(body), &version))
+	assert.Equal(t, 2, version.Version)
+	assert.Equal(t, "http://update.test/second", version.OriginalURL)
+
+	statusCode, _, _ = send(http.MethodGet, baseURL+"/update", "", nil)
+	assert.Equal(t, http.StatusTemporaryRedirect, statusCode)
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		cookies    []*http.Cookie
+		statusCode int
+	}{
+		{
+			name:       "not owner",
+			method:     http.MethodPatch,
+			target:     "/api/user/urls/update",
+			body:       `{"url":"http://update.test/third"}`,
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "not exist",
+			method:     http.MethodPatch,
+			target:     "/api/user/urls/unknown",
+			body:       `{"url":"http://update.test/third"}`,
+			cookies:    cookies,
+			statusCode: http.StatusNotFound,
+		},
+		{
+			name:       "duplicate",
+			method:     http.MethodPatch,
+			target:     "/api/user/urls/update",
+			body:       `{"url":"http://update.test/other"}`,
+			cookies:    cookies,
+			statusCode: http.StatusConflict,
+		},
+		{
+			name:       "invalid url",
+			method:     http.MethodPatch,
+			target:     "/api/user/urls/update",
+			body:       `{"url":"not a url"}`,
+			cookies:    cookies,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "bad body",
+			method:     http.MethodPatch,
+			target:     "/api/user/urls/update",
+			body:       `{"url":`,
+			cookies:    cookies,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "history not owner",
+			method:     http.MethodGet,
+			target:     "/api/user/urls/update/history",
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "rollback version not exist",
+			method:     http.MethodPost,
+			target:     "/api/user/urls/update/rollback",
+			body:       `{"version":9}`,
+			cookies:    cookies,
+			statusCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statusCode, _, _ := send(tt.method, baseURL+tt.target, tt.body, tt.cookies)
+			assert.Equal(t, tt.statusCode, statusCode)
+		})
+	}
+
+	statusCode, body, _ = send(http.MethodPost, baseURL+"/api/user/urls/update/rollback", `{"version":1}`, cookies)
+	require.Equal(t, http.StatusOK, statusCode)
+	require.NoError(t, json.Unmarshal([]byte(body), &version))
+	assert.Equal(t, 3, version.Version)
+	assert.Equal(t, "http://update.test/first", version.OriginalURL)
+
+	history := []storage.URLVersion{}
+	statusCode, body, _ = send(http.MethodGet, baseURL+"/api/user/urls/update/history", "", cookies)
+	require.Equal(t, http.StatusOK, statusCode)
+	require.NoError(t, json.Unmarshal([]byte(body), &history))
+	require.Len(t, history, 3)
+	assert.Equal(t, "http://update.test/second", history[1].OriginalURL)
+}
+
+func TestHandler_GetAPIAllURLHandlerPages(t *testing.T) {
+	cfg := config.Config{
+		BaseURL:        baseURL,
+		SecretKey:      secretKey,
+		CookieAuthName: cookieAuthName,
+	}
+
+	d, err := storage.NewDictionary(cfg, &sync.WaitGroup{})
+	require.NoError(t, err)
+
+	h := http.Server{
+		Handler: NewURLHandler(d, storage.NewRandomCodeGenerator(storage.DefaultCodeLength), cfg, nil, nil),
+	}
+
+	send := func(target string, body string, cookies []*http.Cookie) (*http.Response, string) {
+		method := http.MethodGet
+		if body != "" {
+			method = http.MethodPost
+		}
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		for _, v := range cookies {
+			request.AddCookie(v)
+		}
+		w := httptest.NewRecorder()
+		h.Handler.ServeHTTP(w, request)
+		result := w.Result()
+		defer result.Body.Close()
+
+		resultBody, err := ioutil.ReadAll(result.Body)
+		require.NoError(t, err)
+		return result, string(resultBody)
+	}
+
+	result, _ := send(baseURL+"/api/shorten", `{"url":"http://pages.test/c","alias":"pagec"}`, nil)
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+	cookies := result.Cookies()
+	for _, v := range []string{"a", "b"} {
+		result, _ = send(baseURL+"/api/shorten", `{"url":"http://other.pages.test/`+v+`","alias":"page`+v+`"}`, cookies)
+		require.Equal(t, http.StatusCreated, result.StatusCode)
+	}
+
+	// short URL values of the page, every link has the creation time
+	shortURLs := func(body string) []string {
+		items := []storage.UserExportType{}
+		require.NoError(t, json.Unmarshal([]byte(body), &items))
+		result := []string{}
+		for _, v := range items {
+			require.NotNil(t, v.CreatedAt)
+			require.NotNil(t, v.UpdatedAt)
+			result = append(result, v.ShortURL)
+		}
+		return result
+	}
+
+	result, body := send(baseURL+"/api/user/urls?limit=2&sort=short_url", "", cookies)
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, []string{baseURL + "/pagea", baseURL + "/pageb"}, shortURLs(body))
+	cursor := result.Header.Get("X-Next-Cursor")
+	require.NotEmpty(t, cursor)
+
+	result, body = send(baseURL+"/api/user/urls?limit=2&sort=short_url&cursor="+cursor, "", cookies)
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, []string{baseURL + "/pagec"}, shortURLs(body))
+	assert.Empty(t, result.Header.Get("X-Next-Cursor"))
+
+	result, body = send(baseURL+"/api/user/urls?sort=short_url&order=desc&domain=other.pages.test&q=B", "", cookies)
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, []string{baseURL + "/pageb"}, shortURLs(body))
+
+	result, _ = send(baseURL+"/api/user/urls?domain=unknown.test", "", cookies)
+	assert.Equal(t, http.StatusNoContent, result.StatusCode)
+
+	for _, query := range []string{"limit=-1", "limit=ten", "order=up", "sort=original_url", "cursor=bad", "cursor=" + cursor} {
+		result, _ = send(baseURL+"/api/user/urls?"+query, "", cookies)
+		assert.Equal(t, http.StatusBadRequest, result.StatusCode, query)
+	}
+}
+
+func TestHandler_ImportURLHandler(t *testing.T) {
+	cfg := config.Config{
+		BaseURL:         baseURL,
+		SecretKey:       secretKey,
+		CookieAuthName:  cookieAuthName,
+		ImportChunkSize: 2,
+	}
+
+	d, err := storage.NewDictionary(cfg, &sync.WaitGroup{})
+	require.NoError(t, err)
+
+	h := http.Server{
+		Handler: NewURLHandler(d, storage.NewRandomCodeGenerator(storage.DefaultCodeLength), cfg, nil, nil),
+	}
+
+	send := func(target string, contentType string, body []byte, gzipped bool, cookies []*http.Cookie) (*http.Response, string) {
+		request := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+		request.Header.Set("Content-Type", contentType)
+		if gzipped {
+			request.Header.Set("Content-Encoding", "gzip")
+		}
+		for _, v := range cookies {
+			request.AddCookie(v)
+		}
+		w := httptest.NewRecorder()
+		h.Handler.ServeHTTP(w, request)
+		result := w.Result()
+		defer result.Body.Close()
+
+		resultBody, err := ioutil.ReadAll(result.Body)
+		require.NoError(t, err)
+		return result, string(resultBody)
+	}
+
+	csvBody := "\ufeffOriginal_URL,alias,correlation_id,ttl,max_clicks\n" +
+		"http://import.test/a,impa,,,\n" +
+		"http://import.test/b,,b,,\n" +
+		"not a url,,,,\n" +
+		"http://import.test/c,impa,,,\n" +
+		"http://import.test/d,,,ten,\n" +
+		"http://import.test/a,,,,\n" +
+		"http://import.test/g,,,,many\n"
+	result, body := send(baseURL+"/api/user/urls/import", "text/csv", []byte(csvBody), false, nil)
+	require.Equal(t, http.StatusOK, result.StatusCode, body)
+	assert.Equal(t, "text/csv; charset=utf-8", result.Header.Get("Content-Type"))
+	cookies := result.Cookies()
+
+	records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 8)
+	assert.Equal(t, []string{"row", "correlation_id", "short_url", "status", "error"}, records[0])
+	assert.Equal(t, []string{"1", "1", baseURL + "/impa", storage.BatchStatusCreated, ""}, records[1])
+	assert.Equal(t, []string{"2", "b", storage.BatchStatusCreated}, []string{records[2][0], records[2][1], records[2][3]})
+	assert.Equal(t, []string{"3", "3", storage.BatchStatusInvalid}, []string{records[3][0], records[3][1], records[3][3]})
+	assert.Equal(t, []string{"4", "4", storage.BatchStatusTaken}, []string{records[4][0], records[4][1], records[4][3]})
+	assert.Equal(t, []string{"5", "5", storage.BatchStatusInvalid}, []string{records[5][0], records[5][1], records[5][3]})
+	assert.Contains(t, records[5][4], "ttl")
+	assert.Equal(t, []string{"6", "6", baseURL + "/impa", storage.BatchStatusExists, ""}, records[6])
+	assert.Equal(t, []string{"7", "7", storage.BatchStatusInvalid}, []string{records[7][0], records[7][1], records[7][3]})
+	assert.Contains(t, records[7][4], "max_clicks")
+
+	// the gzip-compressed NDJSON, the row is the line number
+	var compressed bytes.Buffer
+	gzw := gzip.NewWriter(&compressed)
+	_, err = gzw.Write([]byte(`{"correlation_id":"e","original_url":"http://import.test/e"}` + "\n\n" +
+		`not json` + "\n" +
+		`{"original_url":"http://import.test/f","alias":"impf","ttl":3600}`))
+	require.NoError(t, err)
+	require.NoError(t, gzw.Close())
+
+	result, body = send(baseURL+"/api/user/urls/import", "application/x-ndjson", compressed.Bytes(), true, cookies)
+	require.Equal(t, http.StatusOK, result.StatusCode, body)
+	assert.Equal(t, "application/x-ndjson", result.Header.Get("Content-Type"))
+
+	results := []importResult{}
+	decoder := json.NewDecoder(strings.NewReader(body))
+	for decoder.More() {
+		item := importResult{}
+		require.NoError(t, decoder.Decode(&item))
+		results = append(results, item)
+	}
+	require.Len(t, results, 3)
+	assert.Equal(t, 1, results[0].Row)
+	assert.Equal(t, "e", results[0].CorrelationID)
+	assert.Equal(t, storage.BatchStatusCreated, results[0].Status)
+	assert.Equal(t, 3, results[1].Row)
+	assert.Equal(t, storage.BatchStatusInvalid, results[1].Status)
+	assert.Equal(t, 4, results[2].Row)
+	assert.Equal(t, baseURL+"/impf", results[2].ShortURL)
+
+	stats, err := d.GetInternalStats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 4, stats.URLs)
+
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		body        string
+		statusCode  int
+	}{
+		{
+			name:        "unsupported content type",
+			target:      baseURL + "/api/user/urls/import",
+			contentType: "application/json",
+			body:        `[{"original_url":"http://import.test/g"}]`,
+			statusCode:  http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "unsupported format",
+			target:      baseURL + "/api/user/urls/import?format=xml",
+			contentType: "text/csv",
+			body:        "original_url\nhttp://import.test/g\n",
+			statusCode:  http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "no original URL column",
+			target:      baseURL + "/api/user/urls/import",
+			contentType: "text/csv",
+			body:        "url\nhttp://import.test/g\n",
+			statusCode:  http.StatusBadRequest,
+		},
+		{
+			name:        "header row only",
+			target:      baseURL + "/api/user/urls/import?format=csv",
+			contentType: "text/plain",
+			body:        "original_url\n",
+			statusCode:  http.StatusBadRequest,
+		},
+		{
+			name:        "empty body",
+			target:      baseURL + "/api/user/urls/import",
+			contentType: "application/x-ndjson",
+			statusCode:  http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, _ := send(tt.target, tt.contentType, []byte(tt.body), false, cookies)
+			assert.Equal(t, tt.statusCode, result.StatusCode)
+		})
+	}
+}
+
+func TestHandler_ExportURLHandler(t *testing.T) {
+	cfg := config.Config{
+		BaseURL:        baseURL,
+		SecretKey:      secretKey,
+		CookieAuthName: cookieAuthName,
+	}
+
+	d, err := storage.NewDictionary(cfg, &sync.WaitGroup{})
+	require.NoError(t, err)
+
+	h := http.Server{
+		Handler: NewURLHandler(d, storage.NewRandomCodeGenerator(storage.DefaultCodeLength), cfg, nil, nil),
+	}
+
+	send := func(method string, target string, header http.Header, body string, cookies []*http.Cookie) (*http.Response, string) {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		for k, v := range header {
+			request.Header[k] = v
+		}
+		for _, v := range cookies {
+			request.AddCookie(v)
+		}
+		w := httptest.NewRecorder()
+		h.Handler.ServeHTTP(w, request)
+		result := w.Result()
+		defer result.Body.Close()
+
+		resultBody, err := ioutil.ReadAll(result.Body)
+		require.NoError(t, err)
+		return result, string(resultBody)
+	}
+
+	result, _ := send(http.MethodPost, baseURL+"/api/shorten", nil, `{"url":"http://export.test/a","alias":"expa"}`, nil)
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+	cookies := result.Cookies()
+	for _, v := range []string{"b", "c"} {
+		result, _ = send(http.MethodPost, baseURL+"/api/shorten", nil, `{"url":"http://other.export.test/`+v+`","alias":"exp`+v+`"}`, cookies)
+		require.Equal(t, http.StatusCreated, result.StatusCode)
+	}
+
+	result, body := send(http.MethodGet, baseURL+"/api/user/urls/export?sort=short_url", nil, "", cookies)
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, "application/x-ndjson", result.Header.Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="links.ndjson"`, result.Header.Get("Content-Disposition"))
+
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	require.Len(t, lines, 3)
+	links := []storage.UserExportType{}
+	for _, v := range lines {
+		item := storage.UserExportType{}
+		require.NoError(t, json.Unmarshal([]byte(v), &item))
+		require.NotNil(t, item.CreatedAt)
+		require.NotNil(t, item.UpdatedAt)
+		links = append(links, item)
+	}
+	assert.Equal(t, baseURL+"/expa", links[0].ShortURL)
+	assert.Equal(t, "http://export.test/a", links[0].OriginalURL)
+	assert.Equal(t, baseURL+"/expc", links[2].ShortURL)
+
+	result, body = send(http.MethodGet, baseURL+"/api/user/urls/export?domain=other.export.test&order=desc&sort=short_url",
+		http.Header{"Accept": []string{"text/csv"}}, "", cookies)
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, "text/csv; charset=utf-8", result.Header.Get("Content-Type"))
+	records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, []string{"short_url", "original_url", "created_at", "updated_at", "last_accessed_at"}, records[0])
+	assert.Equal(t, []string{baseURL + "/expc", "http://other.export.test/c"}, records[1][:2])
+	assert.Equal(t, links[2].CreatedAt.UTC().Format(time.RFC3339Nano), records[1][2])
+	assert.Empty(t, records[1][4])
+	assert.Equal(t, baseURL+"/expb", records[2][0])
+
+	// the export of one user is the import of another one
+	result, body = send(http.MethodPost, baseURL+"/api/user/urls/import",
+		http.Header{"Content-Type": []string{"application/x-ndjson"}}, strings.Join(lines, "\n"), nil)
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, 3, strings.Count(body, `"status":"created"`))
+
+	// the user without links gets the header row only
+	result, body = send(http.MethodGet, baseURL+"/api/user/urls/export?format=csv", nil, "", nil)
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, "short_url,original_url,created_at,updated_at,last_accessed_at\n", body)
+
+	for _, query := range []string{"format=xml", "sort=original_url", "order=up"} {
+		result, _ = send(http.MethodGet, baseURL+"/api/user/urls/export?"+query, nil, "", cookies)
+		assert.Equal(t, http.StatusBadRequest, result.StatusCode, query)
+		assert.Empty(t, result.Header.Get("Content-Disposition"), query)
+	}
+}
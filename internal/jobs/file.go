@@ -0,0 +1,166 @@
+package jobs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const fileSuffixConst = ".jobs" // suffix of the deletion jobs file name
+
+// FilePath returns the deletion jobs file name of the file storage.
+func FilePath(fileStoragePath string) string {
+	return fileStoragePath + fileSuffixConst
+}
+
+// FileStore keeps the deletion jobs in memory and appends every job change to the file.
+// the file is loaded on start, the last line of the job wins. The cleanup rewrites the file with the kept jobs.
+type FileStore struct {
+	mu   sync.RWMutex
+	jobs map[string]Job
+	path string
+	file *os.File
+}
+
+// NewFileStore loads the deletion jobs file and opens it for writing, missing file is not an error.
+// the damaged lines are skipped, the file may end with a line partially written at a crash.
+func NewFileStore(path string) (*FileStore, error) {
+	f := &FileStore{
+		jobs: make(map[string]Job),
+		path: path,
+	}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	f.file = file
+	return f, nil
+}
+
+func (f *FileStore) load() error {
+	file, err := os.Open(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		job := Job{}
+		if err = json.Unmarshal(scanner.Bytes(), &job); err != nil || job.ID == "" {
+			log.Printf("deletion jobs file %s: damaged line is skipped", f.path)
+			continue
+		}
+		f.jobs[job.ID] = job
+	}
+	return scanner.Err()
+}
+
+// SaveJob creates or replaces the job, the change is synced to the file.
+func (f *FileStore) SaveJob(ctx context.Context, job Job) error {
+	line, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err = f.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err = f.file.Sync(); err != nil {
+		return err
+	}
+	f.jobs[job.ID] = job
+	return nil
+}
+
+// GetJob returns the job by its identifier.
+func (f *FileStore) GetJob(ctx context.Context, id string) (Job, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	job, ok := f.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// PendingJobs returns the unfinished jobs in the order of creation.
+func (f *FileStore) PendingJobs(ctx context.Context) ([]Job, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return pendingJobs(f.jobs), nil
+}
+
+// DeleteFinishedJobs removes the done and failed jobs updated before the time and rewrites the file with the kept jobs.
+// the new file replaces the old one by rename, so a crash leaves one of the complete files.
+func (f *FileStore) DeleteFinishedJobs(ctx context.Context, before time.Time) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	count := deleteFinishedJobs(f.jobs, before)
+	if count == 0 {
+		return 0, nil
+	}
+
+	tmpPath := f.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, v := range f.jobs {
+		if err = encoder.Encode(v); err != nil {
+			tmp.Close()
+			return 0, err
+		}
+	}
+	if err = writer.Flush(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err = tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err = os.Rename(tmpPath, f.path); err != nil {
+		return 0, err
+	}
+
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	f.file.Close()
+	f.file = file
+	return count, nil
+}
+
+// Close closes the file.
+func (f *FileStore) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Close()
+}
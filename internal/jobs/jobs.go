@@ -0,0 +1,367 @@
+// Package jobs deletes user links in the background and tracks the deletion jobs status.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexkopcak/shortener/internal/config"
+	"github.com/alexkopcak/shortener/internal/storage"
+)
+
+// deletion job statuses.
+const (
+	StatusPending = "pending" // the job waits for a worker or for the next attempt
+	StatusRunning = "running" // a worker deletes the links
+	StatusDone    = "done"    // the links are deleted
+	StatusFailed  = "failed"  // all attempts failed, Error keeps the last error
+)
+
+const (
+	maxBackoffConst       = time.Minute // upper limit of the delay between the attempts
+	cleanupIntervalConst  = time.Hour   // finished jobs cleanup period
+	defaultMaxAttempts    = 5
+	defaultWorkersCount   = 3
+	defaultRetryBackoff   = time.Second
+	defaultJobRetention   = 24 * time.Hour
+	defaultQueueSize      = 1000
+	jobIDLengthBytesConst = 16
+)
+
+// deletion jobs errors.
+var (
+	ErrQueueFull   = errors.New("deletion queue is full")    // the buffer is full, the request should be repeated later
+	ErrQueueClosed = errors.New("deletion queue is closed")  // the service is shutting down
+	ErrJobNotFound = errors.New("deletion job is not found") // the job does not exist or belongs to another user
+)
+
+type (
+	// Job represents a deletion of the user links.
+	Job struct {
+		CreatedAt time.Time `json:"created_at"`
+		UpdatedAt time.Time `json:"updated_at"`
+		ID        string    `json:"id"`
+		Status    string    `json:"status"`
+		Error     string    `json:"error,omitempty"`
+		ShortURLs []string  `json:"short_urls"`
+		Attempts  int       `json:"attempts"`
+		UserID    int32     `json:"user_id"`
+	}
+
+	// Store represents deletion jobs storage interface.
+	Store interface {
+		SaveJob(ctx context.Context, job Job) error
+		GetJob(ctx context.Context, id string) (Job, error)
+		// PendingJobs returns the unfinished jobs in the order of creation.
+		PendingJobs(ctx context.Context) ([]Job, error)
+		// DeleteFinishedJobs removes the done and failed jobs updated before the time, returns count of removed jobs.
+		DeleteFinishedJobs(ctx context.Context, before time.Time) (int, error)
+		Close() error
+	}
+
+	// Deleter represents the storage which deletes the user links.
+	Deleter interface {
+		DeleteUserURL(ctx context.Context, deletedURLs *storage.DeletedShortURLValues) error
+	}
+)
+
+// finished checks that the job will not run again.
+func (j Job) finished() bool {
+	return j.Status == StatusDone || j.Status == StatusFailed
+}
+
+// InitializeStore implements the choice of deletion jobs storage depending on the configuration.
+// the jobs are kept in the postgres database, in the file next to the file storage or in memory.
+// the error is returned if the database is configured but not available, the jobs would not be durable otherwise.
+func InitializeStore(cfg config.Config) (Store, error) {
+	if strings.TrimSpace(cfg.DBConnectionString) != "" {
+		store, err := openPostgresStore(cfg.DBConnectionString)
+		if err != nil {
+			return nil, fmt.Errorf("deletion jobs store: %w", err)
+		}
+		return store, nil
+	}
+	if strings.TrimSpace(cfg.FileStoragePath) != "" {
+		return NewFileStore(FilePath(cfg.FileStoragePath))
+	}
+	return NewMemoryStore(), nil
+}
+
+// openPostgresStore connects to the postgres database.
+func openPostgresStore(dsn string) (*PostgresStore, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	store, err := NewPostgresStore(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// Queue is a bounded deletion jobs queue.
+// every job is saved before it is queued, so the jobs left at shutdown are run again by the next start.
+// failed attempts are repeated with exponential backoff.
+type Queue struct {
+	store        Store
+	repo         Deleter
+	jobs         chan Job
+	done         chan struct{}
+	wg           sync.WaitGroup
+	saving       sync.WaitGroup // Enqueue calls saving the jobs, the store is closed after them
+	mu           sync.Mutex     // guards closed, reserved and the sends to jobs
+	closed       bool
+	reserved     int // places of the jobs being saved
+	maxAttempts  int
+	retryBackoff time.Duration
+	retention    time.Duration
+}
+
+// NewQueue creates deletion jobs queue, starts its workers and resumes the unfinished jobs of the store.
+func NewQueue(store Store, repo Deleter, cfg config.Config) *Queue {
+	q := &Queue{
+		store:        store,
+		repo:         repo,
+		done:         make(chan struct{}),
+		maxAttempts:  cfg.DeleteMaxAttempts,
+		retryBackoff: cfg.DeleteRetryBackoff,
+		retention:    cfg.DeleteJobRetention,
+	}
+	if q.maxAttempts <= 0 {
+		q.maxAttempts = defaultMaxAttempts
+	}
+	if q.retryBackoff <= 0 {
+		q.retryBackoff = defaultRetryBackoff
+	}
+	if q.retention <= 0 {
+		q.retention = defaultJobRetention
+	}
+	size := cfg.DeleteQueueSize
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+	q.jobs = make(chan Job, size)
+	workers := cfg.DeleteWorkers
+	if workers <= 0 {
+		workers = defaultWorkersCount
+	}
+
+	// the unfinished jobs are loaded before the first Enqueue, so a new job is never run twice
+	pending, err := store.PendingJobs(context.Background())
+	if err != nil {
+		log.Printf("deletion jobs: %v", err)
+	}
+	if len(pending) > 0 {
+		log.Printf("deletion jobs: %d unfinished jobs resumed", len(pending))
+	}
+
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+	q.wg.Add(2)
+	go q.resume(pending)
+	go q.cleanup()
+	return q
+}
+
+// Enqueue saves the deletion job and puts it to the queue without blocking.
+// ErrQueueFull is returned if the buffer is full.
+// the place of the job is reserved before the job is saved, so the concurrent calls do not wait for the store.
+func (q *Queue) Enqueue(ctx context.Context, deletedURLs *storage.DeletedShortURLValues) (Job, error) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return Job{}, ErrQueueClosed
+	}
+	// only Enqueue sends to the channel, so the reserved place can not be taken by others
+	if len(q.jobs)+q.reserved >= cap(q.jobs) {
+		q.mu.Unlock()
+		return Job{}, ErrQueueFull
+	}
+	q.reserved++
+	q.saving.Add(1)
+	q.mu.Unlock()
+	defer q.saving.Done()
+
+	job, err := q.saveNew(ctx, deletedURLs)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.reserved--
+	if err != nil {
+		return Job{}, err
+	}
+	// the job saved while the queue is closing stays pending at the store and is resumed by the next start
+	q.jobs <- job
+	return job, nil
+}
+
+// saveNew saves the new pending job of the links deletion.
+func (q *Queue) saveNew(ctx context.Context, deletedURLs *storage.DeletedShortURLValues) (Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return Job{}, err
+	}
+	now := time.Now().UTC()
+	job := Job{
+		ID:        id,
+		UserID:    deletedURLs.UserIDValue,
+		ShortURLs: deletedURLs.ShortURLValues,
+		Status:    StatusPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err = q.store.SaveJob(ctx, job); err != nil {
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// Job returns the deletion job of the user, jobs of other users are not found.
+func (q *Queue) Job(ctx context.Context, id string, userID int32) (Job, error) {
+	job, err := q.store.GetJob(ctx, id)
+	if err != nil {
+		return Job{}, err
+	}
+	if job.UserID != userID {
+		return Job{}, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// Close stops the workers and closes the store, the running attempts are finished first.
+// the queued jobs stay pending at the store.
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	close(q.done)
+	q.mu.Unlock()
+
+	q.saving.Wait()
+	q.wg.Wait()
+	return q.store.Close()
+}
+
+func (q *Queue) worker() {
+	defer q.wg.Done()
+
+	for {
+		select {
+		case <-q.done:
+			return
+		case job := <-q.jobs:
+			q.run(job)
+		}
+	}
+}
+
+// resume runs the unfinished jobs of the previous start one by one.
+func (q *Queue) resume(pending []Job) {
+	defer q.wg.Done()
+
+	for _, job := range pending {
+		select {
+		case <-q.done:
+			return
+		default:
+			q.run(job)
+		}
+	}
+}
+
+// cleanup periodically removes the finished jobs older than the retention period.
+func (q *Queue) cleanup() {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(cleanupIntervalConst)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.done:
+			return
+		case <-ticker.C:
+			if _, err := q.store.DeleteFinishedJobs(context.Background(), time.Now().Add(-q.retention)); err != nil {
+				log.Printf("deletion jobs cleanup: %v", err)
+			}
+		}
+	}
+}
+
+// run deletes the links of the job, the failed attempt is repeated after the backoff delay.
+// the job stays pending if the queue is closed while it waits for the next attempt.
+func (q *Queue) run(job Job) {
+	for {
+		job.Attempts++
+		q.save(job, StatusRunning, "")
+
+		err := q.repo.DeleteUserURL(context.Background(), &storage.DeletedShortURLValues{
+			ShortURLValues: job.ShortURLs,
+			UserIDValue:    job.UserID,
+		})
+		if err == nil {
+			q.save(job, StatusDone, "")
+			return
+		}
+		if job.Attempts >= q.maxAttempts {
+			log.Printf("deletion job %s failed after %d attempts: %v", job.ID, job.Attempts, err)
+			q.save(job, StatusFailed, err.Error())
+			return
+		}
+		q.save(job, StatusPending, err.Error())
+
+		timer := time.NewTimer(q.backoff(job.Attempts))
+		select {
+		case <-q.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// save writes the job status to the store, the job keeps running if the store fails.
+func (q *Queue) save(job Job, status string, message string) {
+	job.Status = status
+	job.Error = message
+	job.UpdatedAt = time.Now().UTC()
+	if err := q.store.SaveJob(context.Background(), job); err != nil {
+		log.Printf("deletion job %s: %v", job.ID, err)
+	}
+}
+
+// backoff returns the delay before the next attempt, the delay doubles after every failed attempt.
+func (q *Queue) backoff(attempts int) time.Duration {
+	delay := q.retryBackoff
+	for i := 1; i < attempts && delay < maxBackoffConst; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoffConst {
+		return maxBackoffConst
+	}
+	return delay
+}
+
+// newJobID returns a random job identifier.
+func newJobID() (string, error) {
+	b := make([]byte, jobIDLengthBytesConst)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,89 @@
+package jobs
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a memory deletion jobs storage implementation, the jobs are lost at shutdown.
+type MemoryStore struct {
+	mu   sync.RWMutex
+	jobs map[string]Job
+}
+
+// NewMemoryStore creates a new memory deletion jobs storage.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		jobs: make(map[string]Job),
+	}
+}
+
+// SaveJob creates or replaces the job.
+func (m *MemoryStore) SaveJob(ctx context.Context, job Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.jobs[job.ID] = job
+	return nil
+}
+
+// GetJob returns the job by its identifier.
+func (m *MemoryStore) GetJob(ctx context.Context, id string) (Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// PendingJobs returns the unfinished jobs in the order of creation.
+func (m *MemoryStore) PendingJobs(ctx context.Context) ([]Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return pendingJobs(m.jobs), nil
+}
+
+// DeleteFinishedJobs removes the done and failed jobs updated before the time.
+func (m *MemoryStore) DeleteFinishedJobs(ctx context.Context, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return deleteFinishedJobs(m.jobs, before), nil
+}
+
+// Close interface plug.
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// pendingJobs returns the unfinished jobs of the map in the order of creation.
+func pendingJobs(jobs map[string]Job) []Job {
+	result := []Job{}
+	for _, v := range jobs {
+		if !v.finished() {
+			result = append(result, v)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result
+}
+
+// deleteFinishedJobs removes the finished jobs updated before the time from the map, returns count of removed jobs.
+func deleteFinishedJobs(jobs map[string]Job, before time.Time) int {
+	count := 0
+	for id, v := range jobs {
+		if v.finished() && v.UpdatedAt.Before(before) {
+			delete(jobs, id)
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,116 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgtype"
+	_ "github.com/jackc/pgx/v4/stdlib"
+)
+
+// ErrNoJobsTable - the deletion jobs table is not created, postgres migrations must be applied first.
+var ErrNoJobsTable = errors.New("delete_jobs table does not exist")
+
+// PostgresStore is a postgres deletion jobs storage implementation.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore creates a new postgres deletion jobs storage, the jobs table is created by the storage migrations.
+func NewPostgresStore(db *sql.DB) (*PostgresStore, error) {
+	var exists bool
+	err := db.QueryRowContext(context.Background(), "SELECT to_regclass('delete_jobs') IS NOT NULL;").Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNoJobsTable
+	}
+
+	return &PostgresStore{db: db}, nil
+}
+
+// SaveJob creates or replaces the job.
+func (ps *PostgresStore) SaveJob(ctx context.Context, job Job) error {
+	shortURLs := &pgtype.TextArray{}
+	if err := shortURLs.Set(job.ShortURLs); err != nil {
+		return err
+	}
+
+	_, err := ps.db.ExecContext(ctx,
+		"INSERT INTO delete_jobs (id, user_id, short_urls, status, attempts, error, created_at, updated_at) "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8) "+
+			"ON CONFLICT (id) DO UPDATE SET status = EXCLUDED.status, attempts = EXCLUDED.attempts, "+
+			"error = EXCLUDED.error, updated_at = EXCLUDED.updated_at;",
+		job.ID, job.UserID, shortURLs, job.Status, job.Attempts, job.Error, job.CreatedAt, job.UpdatedAt)
+	return err
+}
+
+// GetJob returns the job by its identifier.
+func (ps *PostgresStore) GetJob(ctx context.Context, id string) (Job, error) {
+	rows, err := ps.db.QueryContext(ctx,
+		"SELECT id, user_id, short_urls, status, attempts, error, created_at, updated_at FROM delete_jobs WHERE id = $1 ;",
+		id)
+	if err != nil {
+		return Job{}, err
+	}
+	jobs, err := scanJobs(rows)
+	if err != nil {
+		return Job{}, err
+	}
+	if len(jobs) == 0 {
+		return Job{}, ErrJobNotFound
+	}
+	return jobs[0], nil
+}
+
+// PendingJobs returns the unfinished jobs in the order of creation.
+func (ps *PostgresStore) PendingJobs(ctx context.Context) ([]Job, error) {
+	rows, err := ps.db.QueryContext(ctx,
+		"SELECT id, user_id, short_urls, status, attempts, error, created_at, updated_at FROM delete_jobs "+
+			"WHERE status IN ($1, $2) ORDER BY created_at;",
+		StatusPending, StatusRunning)
+	if err != nil {
+		return nil, err
+	}
+	return scanJobs(rows)
+}
+
+// DeleteFinishedJobs removes the done and failed jobs updated before the time.
+func (ps *PostgresStore) DeleteFinishedJobs(ctx context.Context, before time.Time) (int, error) {
+	cTag, err := ps.db.ExecContext(ctx,
+		"DELETE FROM delete_jobs WHERE status IN ($1, $2) AND updated_at < $3;",
+		StatusDone, StatusFailed, before)
+	if err != nil {
+		return 0, err
+	}
+
+	cnt, err := cTag.RowsAffected()
+	return int(cnt), err
+}
+
+// Close close postgres connection.
+func (ps *PostgresStore) Close() error {
+	return ps.db.Close()
+}
+
+// scanJobs reads the jobs rows and closes them.
+func scanJobs(rows *sql.Rows) ([]Job, error) {
+	defer rows.Close()
+
+	result := []Job{}
+	for rows.Next() {
+		job := Job{}
+		shortURLs := pgtype.TextArray{}
+		if err := rows.Scan(&job.ID, &job.UserID, &shortURLs, &job.Status, &job.Attempts, &job.Error, &job.CreatedAt, &job.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := shortURLs.AssignTo(&job.ShortURLs); err != nil {
+			return nil, err
+		}
+		result = append(result, job)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,311 @@
+// package migrations implements versioned postgres schema migrations embedded in the binary.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	advisoryLockKey  = 7130212 // pg_advisory_lock key, only one instance migrates at a time
+	baselineVersion  = 1       // version of the original shortener table
+	baselineTable    = "shortener"
+	migrationsFolder = "sql"
+)
+
+//go:embed sql/*.sql
+var migrationFiles embed.FS
+
+// ErrNoMigration - there are no applied migrations to roll back.
+var ErrNoMigration = errors.New("there are no applied migrations")
+
+type (
+	// type Migration represents a schema version with up and down SQL scripts.
+	Migration struct {
+		Name    string
+		Up      string
+		Down    string
+		Version int
+	}
+
+	// type Status represents migration state at the database.
+	Status struct {
+		AppliedAt *time.Time
+		Migration
+	}
+
+	// type Migrator applies migrations to the postgres database.
+	Migrator struct {
+		db         *sql.DB
+		migrations []Migration
+	}
+)
+
+// func Load reads embedded migrations ordered by version.
+// Migration file name format is <version>_<name>.<up|down>.sql
+func Load() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, migrationsFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		parts := strings.SplitN(strings.TrimSuffix(name, ".sql"), "_", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad migration file name %q", name)
+		}
+
+		version, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("bad migration file name %q: %w", name, err)
+		}
+
+		body, err := migrationFiles.ReadFile(path.Join(migrationsFolder, name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version}
+			byVersion[version] = m
+		}
+
+		switch {
+		case strings.HasSuffix(parts[1], ".up"):
+			m.Name = strings.TrimSuffix(parts[1], ".up")
+			m.Up = string(body)
+		case strings.HasSuffix(parts[1], ".down"):
+			m.Down = string(body)
+		default:
+			return nil, fmt.Errorf("bad migration file name %q", name)
+		}
+	}
+
+	result := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d must have up and down scripts", m.Version)
+		}
+		result = append(result, *m)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Version < result[j].Version
+	})
+
+	for i, m := range result {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+	}
+	return result, nil
+}
+
+// func NewMigrator creates a new migrator object with the embedded migrations.
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+	}, nil
+}
+
+// func Up applies all pending migrations, returns applied migrations.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var result []Migration
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		current, err := currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if migration.Version <= current {
+				continue
+			}
+			if err = apply(ctx, conn, migration); err != nil {
+				return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+			log.Printf("migration %d %s applied", migration.Version, migration.Name)
+			result = append(result, migration)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// func Down rolls back the last applied migration, returns the rolled back migration.
+func (m *Migrator) Down(ctx context.Context) (Migration, error) {
+	var result Migration
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		current, err := currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if current == 0 {
+			return ErrNoMigration
+		}
+		if current > len(m.migrations) {
+			return fmt.Errorf("database version %d is newer than the binary", current)
+		}
+
+		result = m.migrations[current-1]
+		if err = revert(ctx, conn, result); err != nil {
+			return fmt.Errorf("migration %d %s: %w", result.Version, result.Name, err)
+		}
+		log.Printf("migration %d %s rolled back", result.Version, result.Name)
+		return nil
+	})
+	return result, err
+}
+
+// func Status returns all known migrations with the time of application.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var result []Status
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied := make(map[int]time.Time)
+		rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations ORDER BY version;")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var version int
+			var appliedAt time.Time
+			if err = rows.Scan(&version, &appliedAt); err != nil {
+				return err
+			}
+			applied[version] = appliedAt
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			item := Status{Migration: migration}
+			if appliedAt, ok := applied[migration.Version]; ok {
+				item.AppliedAt = &appliedAt
+			}
+			result = append(result, item)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// func locked runs fn on a single connection holding the advisory lock,
+// prepares the version table and adopts the existing deployment.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1);", advisoryLockKey); err != nil {
+		return err
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1);", advisoryLockKey); err != nil {
+			log.Printf("migrations unlock: %v", err)
+		}
+	}()
+
+	if err = prepare(ctx, conn, m.migrations[baselineVersion-1]); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// func prepare creates the version table, if there is no version yet
+// but the original table exists, the baseline migration is marked as applied.
+func prepare(ctx context.Context, conn *sql.Conn, baseline Migration) error {
+	_, err := conn.ExecContext(ctx,
+		"CREATE TABLE IF NOT EXISTS schema_migrations ("+
+			"version INTEGER PRIMARY KEY, "+
+			"name VARCHAR(255) NOT NULL, "+
+			"applied_at TIMESTAMPTZ NOT NULL DEFAULT now());")
+	if err != nil {
+		return err
+	}
+
+	current, err := currentVersion(ctx, conn)
+	if err != nil || current != 0 {
+		return err
+	}
+
+	var exists bool
+	err = conn.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL;", baselineTable).Scan(&exists)
+	if err != nil || !exists {
+		return err
+	}
+
+	_, err = conn.ExecContext(ctx,
+		"INSERT INTO schema_migrations (version, name) VALUES ($1, $2);",
+		baseline.Version, baseline.Name)
+	if err != nil {
+		return err
+	}
+	log.Printf("existing %s table adopted as migration %d %s", baselineTable, baseline.Version, baseline.Name)
+	return nil
+}
+
+// func currentVersion returns the last applied migration version, 0 if there are no migrations.
+func currentVersion(ctx context.Context, conn *sql.Conn) (int, error) {
+	var version int
+	err := conn.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations;").Scan(&version)
+	return version, err
+}
+
+// func apply runs up script and saves the version in one transaction.
+func apply(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, migration.Up); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO schema_migrations (version, name) VALUES ($1, $2);",
+		migration.Version, migration.Name)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// func revert runs down script and removes the version in one transaction.
+func revert(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, migration.Down); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1;", migration.Version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,202 @@
+package migrations
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	require.Equal(t, baselineVersion, migrations[0].Version)
+	require.Equal(t, "create_shortener", migrations[0].Name)
+	for i, m := range migrations {
+		require.Equal(t, i+1, m.Version)
+		require.NotEmpty(t, m.Name)
+		require.NotEmpty(t, m.Up)
+		require.NotEmpty(t, m.Down)
+	}
+}
+
+// func expectPrepare sets expectations for the advisory lock and the version table preparation.
+func expectPrepare(mock sqlmock.Sqlmock, current int, tableExists bool) {
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_lock($1);")).
+		WithArgs(advisoryLockKey).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS schema_migrations")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0) FROM schema_migrations;")).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(current))
+	if current != 0 {
+		return
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT to_regclass($1) IS NOT NULL;")).
+		WithArgs(baselineTable).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tableExists))
+	if tableExists {
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO schema_migrations (version, name) VALUES ($1, $2);")).
+			WithArgs(baselineVersion, "create_shortener").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+}
+
+func expectUnlock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock($1);")).
+		WithArgs(advisoryLockKey).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestMigratorUp(t *testing.T) {
+	tests := []struct {
+		name        string
+		tableExists bool
+		current     int
+		applied     int
+	}{
+		{
+			name:        "fresh database",
+			tableExists: false,
+			current:     0,
+			applied:     0,
+		},
+		{
+			name:        "adopt existing table",
+			tableExists: true,
+			current:     0,
+			applied:     baselineVersion,
+		},
+		{
+			name:    "partially migrated",
+			current: 2,
+			applied: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			migrator, err := NewMigrator(db)
+			require.NoError(t, err)
+
+			expectPrepare(mock, tt.current, tt.tableExists)
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0) FROM schema_migrations;")).
+				WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(tt.applied))
+			for _, m := range migrator.migrations[tt.applied:] {
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta(m.Up)).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO schema_migrations (version, name) VALUES ($1, $2);")).
+					WithArgs(m.Version, m.Name).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			}
+			expectUnlock(mock)
+
+			result, err := migrator.Up(context.Background())
+			require.NoError(t, err)
+			require.Len(t, result, len(migrator.migrations)-tt.applied)
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestMigratorUpError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	migrator, err := NewMigrator(db)
+	require.NoError(t, err)
+
+	expectPrepare(mock, 0, false)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0) FROM schema_migrations;")).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(migrator.migrations[0].Up)).WillReturnError(context.DeadlineExceeded)
+	mock.ExpectRollback()
+	expectUnlock(mock)
+
+	result, err := migrator.Up(context.Background())
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Empty(t, result)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigratorDown(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	migrator, err := NewMigrator(db)
+	require.NoError(t, err)
+	last := migrator.migrations[len(migrator.migrations)-1]
+
+	expectPrepare(mock, last.Version, false)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0) FROM schema_migrations;")).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(last.Version))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(last.Down)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM schema_migrations WHERE version = $1;")).
+		WithArgs(last.Version).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	result, err := migrator.Down(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, last.Version, result.Version)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigratorDownEmpty(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	migrator, err := NewMigrator(db)
+	require.NoError(t, err)
+
+	expectPrepare(mock, 0, false)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0) FROM schema_migrations;")).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(0))
+	expectUnlock(mock)
+
+	_, err = migrator.Down(context.Background())
+	require.ErrorIs(t, err, ErrNoMigration)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigratorStatus(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	migrator, err := NewMigrator(db)
+	require.NoError(t, err)
+
+	stamp := time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC)
+	expectPrepare(mock, 1, true)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT version, applied_at FROM schema_migrations ORDER BY version;")).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, stamp))
+	expectUnlock(mock)
+
+	result, err := migrator.Status(context.Background())
+	require.NoError(t, err)
+	require.Len(t, result, len(migrator.migrations))
+	require.NotNil(t, result[0].AppliedAt)
+	require.True(t, stamp.Equal(*result[0].AppliedAt))
+	for _, v := range result[1:] {
+		require.Nil(t, v.AppliedAt)
+	}
+	require.NoError(t, mock.ExpectationsWereMet())
+}
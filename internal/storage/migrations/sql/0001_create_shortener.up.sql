@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS shortener (
+    user_id INTEGER,
+    short_url VARCHAR(5),
+    original_url VARCHAR(255),
+    deleted_at TIMESTAMP,
+    UNIQUE(user_id, original_url)
+);
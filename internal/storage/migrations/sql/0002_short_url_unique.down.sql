@@ -0,0 +1 @@
+DROP INDEX IF EXISTS shortener_short_url_key;
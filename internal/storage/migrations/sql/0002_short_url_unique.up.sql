@@ -0,0 +1,14 @@
+-- the unique index can not be built over duplicate short URL values, they are reported to be resolved by hand
+DO $$
+DECLARE
+    duplicates TEXT;
+BEGIN
+    SELECT string_agg(short_url, ', ' ORDER BY short_url) INTO duplicates
+    FROM (SELECT short_url FROM shortener GROUP BY short_url HAVING COUNT(*) > 1 ORDER BY short_url LIMIT 20) AS d;
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'shortener table has duplicate short_url values: %', duplicates
+            USING HINT = 'keep one row of every short_url value and start the service again';
+    END IF;
+END $$;
+ALTER TABLE shortener ALTER COLUMN short_url TYPE VARCHAR(32);
+CREATE UNIQUE INDEX IF NOT EXISTS shortener_short_url_key ON shortener (short_url);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS clicks (
+    short_url VARCHAR(32) NOT NULL,
+    clicked_at TIMESTAMPTZ NOT NULL,
+    referrer TEXT,
+    user_agent TEXT,
+    client_ip VARCHAR(45)
+);
+CREATE INDEX IF NOT EXISTS clicks_short_url_idx ON clicks (short_url, clicked_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS shortener_deleted_at_idx;
+DROP TABLE IF EXISTS retired_short_urls;
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS retired_short_urls (
+    short_url VARCHAR(32) PRIMARY KEY,
+    retired_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS shortener_deleted_at_idx ON shortener (deleted_at) WHERE deleted_at IS NOT NULL;
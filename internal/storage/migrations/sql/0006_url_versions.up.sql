@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS url_versions (
+    short_url VARCHAR(32) NOT NULL,
+    version INTEGER NOT NULL,
+    original_url VARCHAR(255) NOT NULL,
+    edited_by INTEGER,
+    edited_at TIMESTAMPTZ,
+    PRIMARY KEY (short_url, version)
+);
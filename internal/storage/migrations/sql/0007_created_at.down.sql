@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS shortener_user_created_at_idx;
+ALTER TABLE shortener DROP COLUMN IF EXISTS created_at;
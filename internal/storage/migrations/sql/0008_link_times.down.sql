@@ -0,0 +1,2 @@
+ALTER TABLE shortener DROP COLUMN IF EXISTS last_accessed_at;
+ALTER TABLE shortener DROP COLUMN IF EXISTS updated_at;
@@ -0,0 +1,3 @@
+ALTER TABLE shortener ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE shortener ADD COLUMN IF NOT EXISTS last_accessed_at TIMESTAMPTZ;
+UPDATE shortener SET updated_at = created_at;
@@ -5,28 +5,33 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"io"
-	"math/rand"
-	"os"
+	"fmt"
+	"log"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgtype"
 	_ "github.com/jackc/pgx/v4"
 	_ "github.com/jackc/pgx/v4/stdlib"
 
 	"github.com/alexkopcak/shortener/internal/config"
+	"github.com/alexkopcak/shortener/internal/storage/migrations"
 )
 
 const (
 	minShortURLLengthConst = 5 // short URL length used at func shortURLGenerator
+
+	pgUniqueViolationCode    = "23505"                   // postgres unique_violation error code
+	pgShortURLConstraintName = "shortener_short_url_key" // postgres short URL unique index name
 )
 
 // Custom error implementation.
 var (
 	ErrDuplicateRecord = errors.New("record are duplicate") // record already exists
 	ErrNotExistRecord  = errors.New("record not exist")     // record not exists
+	ErrExpiredRecord   = errors.New("record expired")       // record expiration time has come
 )
 
 // type DeletedShortURLValues represents a structure from an array of ShortURLValues to be removed and User ID.
@@ -37,93 +42,220 @@ type DeletedShortURLValues struct {
 
 // func ShortURLGenerator generates a random string value consisting of n characters.
 func ShortURLGenerator() string {
-	rand.Seed(time.Now().UnixNano())
-	var letterRunes = []rune("0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
-	b := make([]rune, minShortURLLengthConst)
-	for i := range b {
-		b[i] = letterRunes[rand.Intn(len(letterRunes))]
-	}
-	return string(b)
+	return defaultCodeGenerator.Generate("", 0)
 }
 
 // type Storage represents storage interface.
 type Storage interface {
-	AddURL(ctx context.Context, longURLValue string, shortURLValue string, userID int32) (string, error)
+	AddURL(ctx context.Context, longURLValue string, shortURLValue string, userID int32, opts URLOptions) (string, error)
 	GetURL(ctx context.Context, shortURLValue string) (string, error)
+	CheckURLPassword(ctx context.Context, shortURLValue string, password string) (string, error)
+	ConsumeClick(ctx context.Context, shortURLValue string) error
 	GetUserURL(ctx context.Context, prefix string, userID int32) ([]UserExportType, error)
+	ListUserURL(ctx context.Context, prefix string, userID int32, opts ListOptions) (UserURLPage, error)
+	GetURLOwner(ctx context.Context, shortURLValue string) (int32, error)
 	GetInternalStats(ctx context.Context) (InternalStats, error)
 	PostAPIBatch(ctx context.Context, shortURLArray *BatchRequestArray, prefix string, userID int32) (*BatchResponseArray, error)
 	Ping(ctx context.Context) error
 	DeleteUserURL(ctx context.Context, deletedURL *DeletedShortURLValues) error
+	RestoreUserURL(ctx context.Context, restoredURL *DeletedShortURLValues) ([]string, error)
+	UpdateURL(ctx context.Context, shortURLValue string, longURLValue string, userID int32) (URLVersion, error)
+	GetURLHistory(ctx context.Context, shortURLValue string, userID int32) ([]URLVersion, error)
+	TouchURL(ctx context.Context, accessed map[string]time.Time) error
+	DeleteExpiredURL(ctx context.Context) (int, error)
 	Close() error
 }
 
 // func InitializeStorage implements the choice of storage depending on the configuration, returns the storage interface.
-func InitializeStorage(cfg config.Config, wg *sync.WaitGroup, dChannel chan *DeletedShortURLValues) (Storage, error) {
+// the Bloom filter wraps the storage if its memory is set, the postgres storage may be shared by the instances,
+// so the filter is enabled for it only with the rebuild interval. The cache wraps the storage if its size is set.
+// the access tracking wraps the others, so the links found at the cache are tracked too.
+func InitializeStorage(cfg config.Config, wg *sync.WaitGroup) (Storage, error) {
+	repo, err := initializeBackend(cfg, wg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.BloomMemory > 0 {
+		repo = initializeBloom(cfg, repo, wg)
+	}
+
+	if cfg.CacheSize > 0 {
+		repo = NewCachedStorage(repo, cfg.CacheSize, cfg.CacheTTL, cfg.CacheNegativeTTL)
+	}
+
+	if cfg.AccessFlushInterval <= 0 {
+		return repo, nil
+	}
+	return NewAccessTrackingStorage(repo, wg, cfg.AccessFlushInterval), nil
+}
+
+// func initializeBloom wraps the storage with the Bloom filter, the storage is returned as is if the filter is disabled.
+func initializeBloom(cfg config.Config, repo Storage, wg *sync.WaitGroup) Storage {
+	if strings.TrimSpace(cfg.DBConnectionString) != "" && cfg.BloomRebuildInterval <= 0 {
+		log.Print("bloom filter is disabled: the postgres storage needs the rebuild interval, the links of other instances are not seen without it")
+		return repo
+	}
+
+	bloom, err := NewBloomStorage(context.Background(), repo, cfg.BloomMemory, cfg.BloomFalsePositive)
+	if err != nil {
+		log.Printf("bloom filter is disabled: %v", err)
+		return repo
+	}
+	bloom.startRebuild(wg, cfg.BloomRebuildInterval)
+	return bloom
+}
+
+// func initializeBackend creates the postgres storage or the memory storage if the connection string is empty.
+func initializeBackend(cfg config.Config, wg *sync.WaitGroup) (Storage, error) {
 	if strings.TrimSpace(cfg.DBConnectionString) == "" {
-		return NewDictionary(cfg, wg, dChannel)
+		return NewDictionary(cfg, wg)
 	}
-	return NewPostgresStorage(cfg, wg, dChannel)
+	return NewPostgresStorage(cfg, wg)
+}
+
+// func startExpiredReaper launches the worker which periodically removes expired links from the storage.
+// the worker stops when the done channel is closed.
+func startExpiredReaper(repo Storage, wg *sync.WaitGroup, done chan struct{}, interval time.Duration) {
+	startPeriodic(wg, done, interval, func() {
+		cnt, err := repo.DeleteExpiredURL(context.Background())
+		if err != nil {
+			log.Printf("expired links reaper: %v", err)
+			return
+		}
+		if cnt > 0 {
+			log.Printf("expired links reaper: %d links removed", cnt)
+		}
+	})
+}
+
+// func startPeriodic launches the worker which calls fn every interval until the done channel is closed.
+// zero or negative interval disables the worker.
+func startPeriodic(wg *sync.WaitGroup, done chan struct{}, interval time.Duration, fn func()) {
+	if interval <= 0 {
+		return
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}()
 }
 
 // type PostgresStorage - postgres storage implenetation.
 type PostgresStorage struct {
-	db            *sql.DB
-	WaitGroup     *sync.WaitGroup
-	DeleteChannel chan *DeletedShortURLValues
+	db    *sql.DB
+	done  chan struct{}
+	purge PurgePolicy
+	removalListeners
 }
 
 // func NewPostgresStorage creates a new postgres storage object.
-func NewPostgresStorage(cfg config.Config, wg *sync.WaitGroup, dChannel chan *DeletedShortURLValues) (Storage, error) {
+// the storage is not created if the database is not available or its schema can not be migrated,
+// so the service never serves the configured database links from memory.
+func NewPostgresStorage(cfg config.Config, wg *sync.WaitGroup) (Storage, error) {
 	ps, err := sql.Open("pgx", cfg.DBConnectionString)
 	if err != nil {
-		return NewDictionary(cfg, wg, dChannel)
+		return nil, err
 	}
 
 	var cnt int
 	err = ps.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM pg_database WHERE datname = 'shortener_db';").Scan(&cnt)
 
 	if cnt != 1 || err != nil {
-		_, err = ps.ExecContext(context.Background(), "CREATE DATABASE shortener_db OWNER postgres;")
-		if err != nil {
-			return NewDictionary(cfg, wg, dChannel)
+		if _, err = ps.ExecContext(context.Background(), "CREATE DATABASE shortener_db OWNER postgres;"); err != nil {
+			log.Printf("postgres create database: %v", err)
 		}
 	}
 
-	_, err = ps.ExecContext(context.Background(), "SELECT * FROM shortener LIMIT 1;")
+	migrator, err := migrations.NewMigrator(ps)
 	if err != nil {
-		_, err = ps.ExecContext(context.Background(), "CREATE TABLE shortener (user_id INTEGER, short_url VARCHAR(5), original_url VARCHAR(255), deleted_at TIMESTAMP, UNIQUE(user_id, original_url));")
-		if err != nil {
-			return NewDictionary(cfg, wg, dChannel)
-		}
+		ps.Close()
+		return nil, err
+	}
+
+	if _, err = migrator.Up(context.Background()); err != nil {
+		ps.Close()
+		return nil, fmt.Errorf("postgres migrations: %w", err)
 	}
 
 	pstorage := &PostgresStorage{
-		db:            ps,
-		WaitGroup:     wg,
-		DeleteChannel: dChannel,
+		db:   ps,
+		done: make(chan struct{}),
+		purge: PurgePolicy{
+			Retention: cfg.DeletedRetention,
+			Interval:  cfg.PurgeInterval,
+			BatchSize: cfg.PurgeBatchSize,
+			ReuseURLs: cfg.ReusePurgedShortURLs,
+		},
 	}
 
-	pstorage.startDeleteWorker()
+	startExpiredReaper(pstorage, wg, pstorage.done, cfg.ExpiredReapInterval)
+	startPurger(pstorage, wg, pstorage.done, pstorage.purge.Interval)
 
 	return pstorage, nil
 }
 
+// func isShortURLViolation checks that the postgres error is a short URL unique index violation.
+func isShortURLViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) &&
+		pgErr.Code == pgUniqueViolationCode &&
+		pgErr.ConstraintName == pgShortURLConstraintName
+}
+
 // func AddURL adds original URL value to DB postgres, the function returns a short URL value.
-func (ps *PostgresStorage) AddURL(ctx context.Context, longURLValue string, shortURLValue string, userID int32) (string, error) {
+// retired short URL values of the purged links are taken.
+func (ps *PostgresStorage) AddURL(ctx context.Context, longURLValue string, shortURLValue string, userID int32, opts URLOptions) (string, error) {
 	if strings.TrimSpace(longURLValue) == "" {
 		return "", errors.New("empty long URL value")
 	}
 
+	if err := ValidateShortURL(shortURLValue); err != nil {
+		return "", err
+	}
+
+	expiresAt, err := opts.Expiration(time.Now())
+	if err != nil {
+		return "", err
+	}
+	passwordHash, err := opts.PasswordHash()
+	if err != nil {
+		return "", err
+	}
+	clicksLeft, err := opts.ClicksLeft()
+	if err != nil {
+		return "", err
+	}
+
 	cTag, err := ps.db.ExecContext(ctx,
 		"INSERT INTO shortener "+
-			"(user_id, short_url, original_url) "+
-			"VALUES ($1, $2, $3) "+
+			"(user_id, short_url, original_url, expires_at, password_hash, clicks_left) "+
+			"SELECT $1::integer, $2::varchar, $3::varchar, $4::timestamptz, $5::text, $6::bigint "+
+			"WHERE NOT EXISTS (SELECT 1 FROM retired_short_urls WHERE short_url = $2) "+
 			"ON CONFLICT (user_id, original_url) DO NOTHING;",
 		userID,
 		shortURLValue,
-		longURLValue)
+		longURLValue,
+		expiresAt,
+		nullString(passwordHash),
+		clicksLeft)
 	if err != nil {
+		if isShortURLViolation(err) {
+			return "", ErrShortURLTaken
+		}
 		return "", err
 	}
 
@@ -139,6 +271,9 @@ func (ps *PostgresStorage) AddURL(ctx context.Context, longURLValue string, shor
 				"WHERE user_id = $1 AND original_url = $2 ;",
 			userID, longURLValue).Scan(&shortURL)
 
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrShortURLTaken
+		}
 		if err != nil {
 			return "", err
 		}
@@ -148,58 +283,70 @@ func (ps *PostgresStorage) AddURL(ctx context.Context, longURLValue string, shor
 }
 
 // func GetURL get original URL value by a short value from the postgres DB.
+// ErrPasswordRequired and ErrClicksLimited are returned with the original URL value of the protected and the limited links.
 func (ps *PostgresStorage) GetURL(ctx context.Context, shortURLValue string) (string, error) {
+	longURL, _, err := ps.LookupURL(ctx, shortURLValue)
+	return longURL, err
+}
+
+// func LookupURL get original URL value by a short value from the postgres DB with the link expiration time.
+func (ps *PostgresStorage) LookupURL(ctx context.Context, shortURLValue string) (string, *time.Time, error) {
 	var longURL string
 	var deletedAt *time.Time
+	var expiresAt *time.Time
+	var protected bool
+	var clicksLeft *int64
 
 	err := ps.db.QueryRowContext(ctx,
-		"SELECT original_url, deleted_at "+
+		"SELECT original_url, deleted_at, expires_at, password_hash IS NOT NULL, clicks_left "+
 			"FROM shortener "+
 			"WHERE short_url = $1 ;",
-		shortURLValue).Scan(&longURL, &deletedAt)
+		shortURLValue).Scan(&longURL, &deletedAt, &expiresAt, &protected, &clicksLeft)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil, nil
+	}
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	if deletedAt != nil {
-		return longURL, ErrNotExistRecord
-	} else {
-		return longURL, nil
+		return longURL, expiresAt, ErrNotExistRecord
 	}
-}
-
-// func GetUserURL get short URL value and original URL value pairs array created by user (userID)
-func (ps *PostgresStorage) GetUserURL(ctx context.Context, prefix string, userID int32) ([]UserExportType, error) {
-	result := []UserExportType{}
-	rows, err := ps.db.QueryContext(ctx, "SELECT short_url, original_url FROM shortener WHERE user_id = $1 ;", userID)
-	if err != nil {
-		return result, err
+	if isExpired(expiresAt, time.Now()) {
+		return longURL, expiresAt, ErrExpiredRecord
 	}
-	defer rows.Close()
-
-	if err = rows.Err(); err != nil {
-		return result, err
+	if clicksLeft != nil && *clicksLeft <= 0 {
+		return longURL, expiresAt, ErrClicksExhausted
 	}
+	if protected {
+		return longURL, expiresAt, ErrPasswordRequired
+	}
+	longURL, err = limitedURL(longURL, clicksLeft)
+	return longURL, expiresAt, err
+}
 
-	for rows.Next() {
-		item := UserExportType{}
-		var value string
-		err := rows.Scan(&value, &item.OriginalURL)
-		if err != nil {
-			continue
-		}
+// func GetUserURL get short URL value and original URL value pairs array created by user in the order of creation.
+func (ps *PostgresStorage) GetUserURL(ctx context.Context, prefix string, userID int32) ([]UserExportType, error) {
+	page, err := ps.ListUserURL(ctx, prefix, userID, ListOptions{})
+	return page.Items, err
+}
 
-		if strings.TrimSpace(prefix) == "" {
-			item.ShortURL = value
-		} else {
-			item.ShortURL = prefix + "/" + value
-		}
-		result = append(result, item)
+// func GetURLOwner get ID of the user created the short URL value from the postgres DB.
+func (ps *PostgresStorage) GetURLOwner(ctx context.Context, shortURLValue string) (int32, error) {
+	var userID int32
+	err := ps.db.QueryRowContext(ctx,
+		"SELECT user_id FROM shortener WHERE short_url = $1 ;",
+		shortURLValue).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotExistRecord
 	}
-	return result, nil
+	return userID, err
 }
 
 // func PostAPIBatch group addition of short URL values to the database postgres via api.
+// the pending items are inserted by one multi-row statement, every item gets its own outcome.
+// the conflicting items are checked by the second query: the original URL shortened
+// by the user returns the existing short URL, otherwise the short URL value is taken or retired.
 //
 // items - array of BatchRequest
 // prefix - shortener service name
@@ -209,71 +356,123 @@ func (ps *PostgresStorage) PostAPIBatch(
 	items *BatchRequestArray,
 	prefix string,
 	userID int32) (*BatchResponseArray, error) {
-	result := &BatchResponseArray{}
 	if ps.db == nil {
-		return result, errors.New("db is nil")
+		return &BatchResponseArray{}, errors.New("db is nil")
 	}
 
-	tx, err := ps.db.BeginTx(ctx, nil)
-	if err != nil {
-		return result, err
+	plan := newBatchPlan(items, prefix, time.Now())
+	if len(plan.pending) == 0 {
+		return plan.finish(), nil
 	}
-	defer tx.Rollback()
 
-	for _, v := range *items {
-		batchResponseItem := BatchResponse{}
-		batchResponseItem.CorrelationID = v.CorrelationID
+	shortURLValues := make([]string, 0, len(plan.pending))
+	originalURLValues := make([]string, 0, len(plan.pending))
+	expiresAt := make([]*time.Time, 0, len(plan.pending))
+	passwordHashes := make([]*string, 0, len(plan.pending))
+	clicksLeft := make([]*int64, 0, len(plan.pending))
+	for _, i := range plan.pending {
+		shortURLValues = append(shortURLValues, (*items)[i].ShortURL)
+		originalURLValues = append(originalURLValues, (*items)[i].OriginalURL)
+		expiresAt = append(expiresAt, plan.expiresAt[i])
+		passwordHashes = append(passwordHashes, nullString(plan.passwordHash[i]))
+		clicksLeft = append(clicksLeft, plan.clicksLeft[i])
+	}
 
-		batchResponseItem.ShortURL = v.ShortURL
-		if strings.TrimSpace(prefix) != "" {
-			batchResponseItem.ShortURL = prefix + "/" + v.ShortURL
-		}
+	shortURLArray := &pgtype.TextArray{}
+	originalURLArray := &pgtype.TextArray{}
+	expiresAtArray := &pgtype.TimestamptzArray{}
+	passwordHashArray := &pgtype.TextArray{}
+	clicksLeftArray := &pgtype.Int8Array{}
+	if err := shortURLArray.Set(shortURLValues); err != nil {
+		return &BatchResponseArray{}, err
+	}
+	if err := originalURLArray.Set(originalURLValues); err != nil {
+		return &BatchResponseArray{}, err
+	}
+	if err := expiresAtArray.Set(expiresAt); err != nil {
+		return &BatchResponseArray{}, err
+	}
+	if err := passwordHashArray.Set(passwordHashes); err != nil {
+		return &BatchResponseArray{}, err
+	}
+	if err := clicksLeftArray.Set(clicksLeft); err != nil {
+		return &BatchResponseArray{}, err
+	}
 
-		_, err = tx.ExecContext(ctx,
-			"INSERT INTO shortener (user_id, short_url, original_url) VALUES ($1, $2, $3);",
-			userID,
-			v.ShortURL,
-			v.OriginalURL,
-		)
-		if err != nil {
-			return &BatchResponseArray{}, err
+	created, err := queryStrings(ctx, ps.db,
+		"INSERT INTO shortener (user_id, short_url, original_url, expires_at, password_hash, clicks_left) "+
+			"SELECT $1::integer, t.* FROM unnest($2::varchar[], $3::varchar[], $4::timestamptz[], $5::text[], $6::bigint[]) "+
+			"AS t(short_url, original_url, expires_at, password_hash, clicks_left) "+
+			"WHERE NOT EXISTS (SELECT 1 FROM retired_short_urls r WHERE r.short_url = t.short_url) "+
+			"ON CONFLICT DO NOTHING "+
+			"RETURNING short_url, original_url;",
+		userID, shortURLArray, originalURLArray, expiresAtArray, passwordHashArray, clicksLeftArray)
+	if err != nil {
+		return &BatchResponseArray{}, err
+	}
+
+	var conflicts []int
+	var conflictOriginalURLs []string
+	for _, i := range plan.pending {
+		v := (*items)[i]
+		if created[v.ShortURL] == v.OriginalURL {
+			plan.created(i)
+			continue
 		}
-		*result = append(*result, batchResponseItem)
+		conflicts = append(conflicts, i)
+		conflictOriginalURLs = append(conflictOriginalURLs, v.OriginalURL)
+	}
+	if len(conflicts) == 0 {
+		return plan.finish(), nil
 	}
-	err = tx.Commit()
+
+	if err = originalURLArray.Set(conflictOriginalURLs); err != nil {
+		return &BatchResponseArray{}, err
+	}
+	existing, err := queryStrings(ctx, ps.db,
+		"SELECT original_url, short_url FROM shortener "+
+			"WHERE user_id = $1 AND original_url = ANY($2) ;",
+		userID, originalURLArray)
 	if err != nil {
 		return &BatchResponseArray{}, err
 	}
-	return result, nil
-}
 
-// func Ping simple test database postgres connection.
-func (ps *PostgresStorage) Ping(ctx context.Context) error {
-	err := ps.db.PingContext(ctx)
-	if err != nil {
-		return err
+	for _, i := range conflicts {
+		if shortURL, ok := existing[(*items)[i].OriginalURL]; ok {
+			plan.exists(i, shortURL)
+			continue
+		}
+		plan.fail(i, BatchStatusTaken, ErrShortURLTaken.Error())
 	}
-	return err
+	return plan.finish(), nil
 }
 
-// func StartDeleteWorker launches three delete workers.
-func (ps *PostgresStorage) startDeleteWorker() {
-	workerCount := 3
+// func queryStrings runs the query which returns pairs of string values and maps the first value to the second one.
+func queryStrings(ctx context.Context, db *sql.DB, query string, args ...interface{}) (map[string]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	for i := 0; i < workerCount; i++ {
-		ps.WaitGroup.Add(1)
-		go ps.deleteWorker()
+	result := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err = rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		result[key] = value
 	}
+	return result, rows.Err()
 }
 
-// func DeleteWorker is a listened the DeleteChannel worker.
-// when a value is received through the channel, worker starts DeleteUserURL func.
-func (ps *PostgresStorage) deleteWorker() {
-	defer ps.WaitGroup.Done()
-
-	for job := range ps.DeleteChannel {
-		ps.DeleteUserURL(context.Background(), job)
+// func Ping simple test database postgres connection.
+func (ps *PostgresStorage) Ping(ctx context.Context) error {
+	err := ps.db.PingContext(ctx)
+	if err != nil {
+		return err
 	}
+	return err
 }
 
 // func DeleteUserURL sends an sql query to the postgres repository.
@@ -287,12 +486,32 @@ func (ps *PostgresStorage) DeleteUserURL(ctx context.Context, deletedURLs *Delet
 		return err
 	}
 
-	_, err = ps.db.ExecContext(ctx, "UPDATE shortener SET deleted_at = now() WHERE user_id = $1 and short_url = ANY($2);", deletedURLs.UserIDValue, idsArray)
+	_, err = ps.db.ExecContext(ctx, "UPDATE shortener SET deleted_at = now(), updated_at = now() WHERE user_id = $1 and short_url = ANY($2);", deletedURLs.UserIDValue, idsArray)
 	return err
 }
 
+// func DeleteExpiredURL soft-deletes expired links at the postgres database,
+// the function returns count of deleted links.
+func (ps *PostgresStorage) DeleteExpiredURL(ctx context.Context) (int, error) {
+	cTag, err := ps.db.ExecContext(ctx,
+		"UPDATE shortener SET deleted_at = now(), updated_at = now() "+
+			"WHERE deleted_at IS NULL AND expires_at <= now();")
+	if err != nil {
+		return 0, err
+	}
+
+	cnt, err := cTag.RowsAffected()
+	if cnt > 0 {
+		ps.notifyRemoved()
+	}
+	return int(cnt), err
+}
+
 // func Close close postgres connection.
 func (ps *PostgresStorage) Close() error {
+	if ps.done != nil {
+		close(ps.done)
+	}
 	return ps.db.Close()
 }
 
@@ -302,138 +521,410 @@ func (ps *PostgresStorage) GetInternalStats(ctx context.Context) (InternalStats,
 	err := ps.db.QueryRowContext(ctx,
 		"SELECT user_nested.user_cnt, url_nested.url_cnt "+
 			"FROM "+
-			"(SELECT COUNT(DISTINCT user_id) AS user_cnt FROM shortener WHERE deleted_at IS NULL) AS user_nested, "+
-			"(SELECT COUNT(*) AS url_cnt FROM shortener WHERE deleted_at IS NULL) AS url_nested;").
+			"(SELECT COUNT(DISTINCT user_id) AS user_cnt FROM shortener WHERE deleted_at IS NULL AND (expires_at IS NULL OR expires_at > now())) AS user_nested, "+
+			"(SELECT COUNT(*) AS url_cnt FROM shortener WHERE deleted_at IS NULL AND (expires_at IS NULL OR expires_at > now())) AS url_nested;").
 		Scan(&internalStats.Users, &internalStats.URLs)
 
 	return internalStats, err
 }
 
 // type Dictionary - memory storage implementation.
+// links are kept in shards with their own RW locks, so handlers and deletion jobs
+// may work with the storage concurrently.
 type Dictionary struct {
-	WaitGroup     *sync.WaitGroup
-	DeleteChannel chan *DeletedShortURLValues
-
-	Items           map[string]string
-	UserItems       map[int32][]string
+	shards          [dictionaryShardsConst]*dictionaryShard
+	users           [dictionaryShardsConst]*userShard
 	fileStoragePath string
+	writer          *logWriter
+	done            chan struct{}
+	purge           PurgePolicy
+	removalListeners
+}
+
+// func newDictionary creates an empty memory storage without background workers.
+func newDictionary(fileStoragePath string) *Dictionary {
+	d := &Dictionary{
+		fileStoragePath: fileStoragePath,
+	}
+	for i := range d.shards {
+		d.shards[i] = newDictionaryShard()
+		d.users[i] = newUserShard()
+	}
+	return d
 }
 
 // func NewDictionary create a new memory storage object.
-func NewDictionary(cfg config.Config, wg *sync.WaitGroup, dChan chan *DeletedShortURLValues) (Storage, error) {
-	items := make(map[string]string)
-	userItems := make(map[int32][]string)
+func NewDictionary(cfg config.Config, wg *sync.WaitGroup) (Storage, error) {
+	dic := newDictionary(cfg.FileStoragePath)
+	dic.done = make(chan struct{})
+	dic.purge = PurgePolicy{
+		Retention: cfg.DeletedRetention,
+		Interval:  cfg.PurgeInterval,
+		ReuseURLs: cfg.ReusePurgedShortURLs,
+	}
 
-	_, err := os.Stat(cfg.FileStoragePath)
-	if err == nil {
-		consumerItem, err := NewConsumer(cfg.FileStoragePath)
+	now := time.Now()
+	if cfg.FileStoragePath != "" {
+		if err := dic.loadLog(SnapshotPath(cfg.FileStoragePath), now, cfg.FileTailRecovery); err != nil {
+			return nil, err
+		}
+		if err := dic.loadLog(cfg.FileStoragePath, now, cfg.FileTailRecovery); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.FileStoragePath != "" {
+		writer, err := newLogWriter(cfg.FileStoragePath, cfg.FileSyncPolicy, cfg.FileSyncInterval)
 		if err != nil {
 			return nil, err
 		}
-		defer consumerItem.Close()
-		for {
-			item, err := consumerItem.ReadItem()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				return nil, err
+		dic.writer = writer
+	}
+
+	startExpiredReaper(dic, wg, dic.done, cfg.ExpiredReapInterval)
+	startPurger(dic, wg, dic.done, dic.purge.Interval)
+	if dic.writer != nil {
+		startCompactor(dic, wg, dic.done, cfg.CompactInterval)
+	}
+	return dic, nil
+}
+
+// func replay applies the file storage log record to the memory storage.
+// records without type are written by the old versions, such links have no owner.
+// replay is idempotent, a record applied twice gives the same state.
+func (d *Dictionary) replay(item *ItemType, now time.Time) {
+	shard := d.shards[shardIndex(item.ShortURLValue)]
+	value, ok := shard.items[item.ShortURLValue]
+
+	switch item.Type {
+	case ItemTypeDelete:
+		if !ok {
+			return
+		}
+		if value.owned {
+			d.users[userShardIndex(value.userID)].remove(value.userID, item.ShortURLValue, value.longURL)
+		}
+		// deletion records without timestamp are written by the old versions, which removed the links for good
+		if item.Timestamp == nil {
+			shard.remove(item.ShortURLValue)
+			return
+		}
+		deletedAt := item.Timestamp.UTC()
+		value.deletedAt = &deletedAt
+		value.updatedAt = laterTime(value.updatedAt, deletedAt)
+		shard.put(item.ShortURLValue, value)
+		return
+	case ItemTypeRestore:
+		if !ok || value.deletedAt == nil {
+			return
+		}
+		value.deletedAt = nil
+		if item.Timestamp != nil {
+			value.updatedAt = laterTime(value.updatedAt, item.Timestamp.UTC())
+		}
+		shard.put(item.ShortURLValue, value)
+		if value.owned {
+			d.users[userShardIndex(value.userID)].add(value.userID, item.ShortURLValue, value.longURL)
+		}
+		return
+	case ItemTypeUpdate:
+		d.replayUpdate(shard, item)
+		return
+	case ItemTypeAccess:
+		if ok && item.Timestamp != nil {
+			value.accessedAt = laterAccess(value.accessedAt, item.Timestamp.UTC())
+			shard.put(item.ShortURLValue, value)
+		}
+		return
+	case ItemTypeClick:
+		// the least clicks left win, so the click records written out of order give the same state
+		if ok && value.clicksLeft != nil && item.ClicksLeft != nil && *item.ClicksLeft < *value.clicksLeft {
+			value.clicksLeft = copyCount(item.ClicksLeft)
+			shard.put(item.ShortURLValue, value)
+		}
+		return
+	case ItemTypePurge, ItemTypeRetire:
+		if ok {
+			shard.remove(item.ShortURLValue)
+			if value.owned {
+				d.users[userShardIndex(value.userID)].remove(value.userID, item.ShortURLValue, value.longURL)
 			}
-			items[item.ShortURLValue] = item.LongURLValue
 		}
+		if item.Type == ItemTypeRetire {
+			shard.retired[item.ShortURLValue] = struct{}{}
+		}
+		return
 	}
 
-	dic := &Dictionary{
-		Items:           items,
-		UserItems:       userItems,
-		fileStoragePath: cfg.FileStoragePath,
-		WaitGroup:       wg,
-		DeleteChannel:   dChan,
+	// the previous record of the short URL value is replaced by the creation record
+	if ok {
+		shard.remove(item.ShortURLValue)
+		if value.owned {
+			d.users[userShardIndex(value.userID)].remove(value.userID, item.ShortURLValue, value.longURL)
+		}
 	}
 
-	dic.startDeleteWorker()
-	return dic, nil
+	if isExpired(item.ExpiresAt, now) {
+		return
+	}
+
+	owned := item.Type == ItemTypeCreate
+	var createdAt time.Time
+	if item.Timestamp != nil {
+		createdAt = item.Timestamp.UTC()
+	}
+	updatedAt := createdAt
+	if item.UpdatedAt != nil {
+		updatedAt = item.UpdatedAt.UTC()
+	}
+	shard.put(item.ShortURLValue, dictionaryItem{
+		longURL:      item.LongURLValue,
+		userID:       item.UserID,
+		owned:        owned,
+		expiresAt:    item.ExpiresAt,
+		createdAt:    createdAt,
+		updatedAt:    updatedAt,
+		accessedAt:   item.AccessedAt,
+		passwordHash: item.PasswordHash,
+		clicksLeft:   copyCount(item.ClicksLeft),
+	})
+	if owned {
+		d.users[userShardIndex(item.UserID)].add(item.UserID, item.ShortURLValue, item.LongURLValue)
+	}
+}
+
+// func writeFile appends the records to the file storage log and commits them with one sync.
+func (d *Dictionary) writeFile(items ...*ItemType) error {
+	if d.writer == nil {
+		return nil
+	}
+	return d.writer.Write(items...)
+}
+
+// func appendFile buffers the records of the file storage log, the function returns the sequence number for commitFile.
+// the records are appended while the locks of the changed links are held, so the log keeps the order of the link changes.
+func (d *Dictionary) appendFile(items ...*ItemType) (uint64, error) {
+	if d.writer == nil {
+		return 0, nil
+	}
+	return d.writer.Append(items...)
+}
+
+// func commitFile syncs the appended records by the file storage sync policy, it is called after the locks are released.
+func (d *Dictionary) commitFile(seq uint64) error {
+	if d.writer == nil {
+		return nil
+	}
+	return d.writer.Commit(seq)
 }
 
 // func AddURL add original URL value to memory storage.
-func (d *Dictionary) AddURL(ctx context.Context, longURLValue string, shortURLValue string, userID int32) (string, error) {
+func (d *Dictionary) AddURL(ctx context.Context, longURLValue string, shortURLValue string, userID int32, opts URLOptions) (string, error) {
 	if strings.TrimSpace(longURLValue) == "" {
 		return "", errors.New("empty long URL value")
 	}
 
-	d.Items[shortURLValue] = longURLValue
-	d.UserItems[userID] = append(d.UserItems[userID], shortURLValue)
+	if err := ValidateShortURL(shortURLValue); err != nil {
+		return "", err
+	}
+
+	expiresAt, err := opts.Expiration(time.Now())
+	if err != nil {
+		return "", err
+	}
+	passwordHash, err := opts.PasswordHash()
+	if err != nil {
+		return "", err
+	}
+	clicksLeft, err := opts.ClicksLeft()
+	if err != nil {
+		return "", err
+	}
 
-	if err := ProducerWrite(d.fileStoragePath, &ItemType{
+	// the user index is locked while the link is created, so the same original URL value is not shortened twice
+	users := d.users[userShardIndex(userID)]
+	users.Lock()
+	if index, ok := users.users[userID]; ok {
+		if shortURL, ok := index.originals[longURLValue]; ok {
+			users.Unlock()
+			return shortURL, ErrDuplicateRecord
+		}
+	}
+
+	now := time.Now().UTC()
+	shard := d.shards[shardIndex(shortURLValue)]
+	shard.Lock()
+	if shard.taken(shortURLValue) {
+		shard.Unlock()
+		users.Unlock()
+		return "", ErrShortURLTaken
+	}
+	// the creation record is appended before the link is seen, so its later changes are logged after it
+	seq, err := d.appendFile(&ItemType{
+		Type:          ItemTypeCreate,
+		Timestamp:     &now,
 		ShortURLValue: shortURLValue,
 		LongURLValue:  longURLValue,
-	}); err != nil {
+		UserID:        userID,
+		ExpiresAt:     expiresAt,
+		PasswordHash:  passwordHash,
+		ClicksLeft:    copyCount(clicksLeft),
+	})
+	if err != nil {
+		shard.Unlock()
+		users.Unlock()
+		return "", err
+	}
+	shard.put(shortURLValue, dictionaryItem{
+		longURL:      longURLValue,
+		userID:       userID,
+		owned:        true,
+		expiresAt:    expiresAt,
+		createdAt:    now,
+		updatedAt:    now,
+		passwordHash: passwordHash,
+		clicksLeft:   clicksLeft,
+	})
+	shard.Unlock()
+
+	users.index(userID).add(shortURLValue, longURLValue)
+	users.Unlock()
+
+	if err := d.commitFile(seq); err != nil {
 		return "", err
 	}
-
 	return shortURLValue, nil
 }
 
 // func GetURL get original URL value by a short value from memory storage.
+// ErrPasswordRequired and ErrClicksLimited are returned with the original URL value of the protected and the limited links.
 func (d *Dictionary) GetURL(ctx context.Context, shortURLValue string) (string, error) {
+	longURL, _, err := d.LookupURL(ctx, shortURLValue)
+	return longURL, err
+}
+
+// func LookupURL get original URL value by a short value from memory storage with the link expiration time.
+func (d *Dictionary) LookupURL(ctx context.Context, shortURLValue string) (string, *time.Time, error) {
 	if strings.TrimSpace(shortURLValue) == "" {
-		return "", errors.New("empty short URL value")
+		return "", nil, errors.New("empty short URL value")
+	}
+
+	shard := d.shards[shardIndex(shortURLValue)]
+	shard.RLock()
+	item := shard.items[shortURLValue]
+	shard.RUnlock()
+
+	if item.deletedAt != nil {
+		return item.longURL, item.expiresAt, ErrNotExistRecord
 	}
-	return d.Items[shortURLValue], nil
+	if isExpired(item.expiresAt, time.Now()) {
+		return item.longURL, item.expiresAt, ErrExpiredRecord
+	}
+	longURL, err := availableURL(item.longURL, item.passwordHash, item.clicksLeft)
+	return longURL, item.expiresAt, err
 }
 
-// func GetUserURL get short URL value and original URL value pairs array created by user.
+// func GetUserURL get short URL value and original URL value pairs array created by user in the order of creation.
 func (d *Dictionary) GetUserURL(ctx context.Context, prefix string, userID int32) ([]UserExportType, error) {
-	result := []UserExportType{}
-	for _, v := range d.UserItems[userID] {
-		longURL, err := d.GetURL(ctx, v)
+	page, err := d.ListUserURL(ctx, prefix, userID, ListOptions{})
+	return page.Items, err
+}
 
-		item := UserExportType{}
-		if err != nil {
-			continue
-		} else {
-			item.OriginalURL = longURL
-		}
+// func GetURLOwner get ID of the user created the short URL value from memory storage.
+func (d *Dictionary) GetURLOwner(ctx context.Context, shortURLValue string) (int32, error) {
+	shard := d.shards[shardIndex(shortURLValue)]
+	shard.RLock()
+	item, ok := shard.items[shortURLValue]
+	shard.RUnlock()
 
-		if prefix == "" ||
-			strings.TrimSpace(prefix) == "" {
-			item.ShortURL = v
-		} else {
-			item.ShortURL = prefix + "/" + v
-		}
-		result = append(result, item)
+	if !ok || !item.owned {
+		return 0, ErrNotExistRecord
 	}
-	return result, nil
+	return item.userID, nil
 }
 
 // func PostAPIBatch is a group addition of short URL values to the memory storage via api.
+// every item gets its own outcome. The user index is locked while the batch runs,
+// so concurrent batches of the user do not shorten the same original URL twice.
 //
 // items - array of BatchRequest
 // prefix - shortener service name
 // userID - user ID
 func (d *Dictionary) PostAPIBatch(ctx context.Context, items *BatchRequestArray, prefix string, userID int32) (*BatchResponseArray, error) {
-	result := &BatchResponseArray{}
-	for _, v := range *items {
-		batchResponseItem := BatchResponse{}
-		batchResponseItem.CorrelationID = v.CorrelationID
+	now := time.Now()
+	plan := newBatchPlan(items, prefix, now)
 
-		batchResponseItem.ShortURL = v.ShortURL
-		if strings.TrimSpace(prefix) != "" {
-			batchResponseItem.ShortURL = prefix + "/" + v.ShortURL
-		}
+	users := d.users[userShardIndex(userID)]
+	users.Lock()
+	index := users.index(userID)
 
-		d.Items[v.ShortURL] = v.OriginalURL
-		d.UserItems[userID] = append(d.UserItems[userID], v.ShortURL)
+	shortURLValues := make([]string, 0, len(plan.pending))
+	for _, i := range plan.pending {
+		shortURLValues = append(shortURLValues, (*items)[i].ShortURL)
+	}
+
+	created := now.UTC()
+	records := make([]*ItemType, 0, len(plan.pending))
+	unlock := d.lockShards(shortURLValues)
+	for _, i := range plan.pending {
+		v := (*items)[i]
+		if shortURL, ok := index.originals[v.OriginalURL]; ok {
+			plan.exists(i, shortURL)
+			continue
+		}
+		if d.shards[shardIndex(v.ShortURL)].taken(v.ShortURL) {
+			plan.fail(i, BatchStatusTaken, ErrShortURLTaken.Error())
+			continue
+		}
 
-		if err := ProducerWrite(d.fileStoragePath, &ItemType{
+		d.shards[shardIndex(v.ShortURL)].put(v.ShortURL, dictionaryItem{
+			longURL:      v.OriginalURL,
+			userID:       userID,
+			owned:        true,
+			expiresAt:    plan.expiresAt[i],
+			createdAt:    created,
+			updatedAt:    created,
+			passwordHash: plan.passwordHash[i],
+			clicksLeft:   plan.clicksLeft[i],
+		})
+		index.add(v.ShortURL, v.OriginalURL)
+		plan.created(i)
+
+		records = append(records, &ItemType{
+			Type:          ItemTypeCreate,
+			Timestamp:     &created,
 			ShortURLValue: v.ShortURL,
 			LongURLValue:  v.OriginalURL,
-		}); err != nil {
-			return nil, err
+			UserID:        userID,
+			ExpiresAt:     plan.expiresAt[i],
+			PasswordHash:  plan.passwordHash[i],
+			ClicksLeft:    copyCount(plan.clicksLeft[i]),
+		})
+	}
+	// the records are appended before the links are unlocked, the links are rolled back if the log fails
+	var seq uint64
+	var err error
+	if len(records) > 0 {
+		if seq, err = d.appendFile(records...); err != nil {
+			for _, v := range records {
+				d.shards[shardIndex(v.ShortURLValue)].remove(v.ShortURLValue)
+				index.remove(v.ShortURLValue, v.LongURLValue)
+			}
 		}
-		*result = append(*result, batchResponseItem)
 	}
-	return result, nil
+	unlock()
+	if len(index.values) == 0 {
+		delete(users.users, userID)
+	}
+	users.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	if err = d.commitFile(seq); err != nil {
+		return nil, err
+	}
+	return plan.finish(), nil
 }
 
 // func Ping - interface plug.
@@ -441,75 +932,141 @@ func (d *Dictionary) Ping(ctx context.Context) error {
 	return nil
 }
 
-// func StartDeleteWorker launches three delete workers.
-func (d *Dictionary) startDeleteWorker() {
-	workerCount := 3
-
-	for i := 0; i < workerCount; i++ {
-		d.WaitGroup.Add(1)
-		go d.deleteWorker()
-	}
-}
-
-// func DeleteWorker is a listened the DeleteChannel worker.
-// when a value is received through the channel, worker starts DeleteUserURL func.
-func (d *Dictionary) deleteWorker() {
-	defer d.WaitGroup.Done()
-
-	for job := range d.DeleteChannel {
-		d.DeleteUserURL(context.Background(), job)
-	}
-}
-
-// func DeleteUserURL delete user URLs from memory storage
+// func DeleteUserURL soft-deletes user URLs at memory storage, only the links created by the user are deleted.
+// the deleted links keep their short URL values until they are purged.
 func (d *Dictionary) DeleteUserURL(ctx context.Context, deletedURLs *DeletedShortURLValues) error {
 	if deletedURLs == nil {
 		return nil
 	}
 
-	index := func(item string, ar []string) int {
-		for id, val := range ar {
-			if val == item {
-				return id
-			}
+	now := time.Now().UTC()
+	records := make([]*ItemType, 0, len(deletedURLs.ShortURLValues))
+	previous := make([]dictionaryItem, 0, len(deletedURLs.ShortURLValues))
+	unlock := d.lockShards(deletedURLs.ShortURLValues)
+	for _, item := range deletedURLs.ShortURLValues {
+		shard := d.shards[shardIndex(item)]
+		value, ok := shard.items[item]
+		if !ok || !value.owned || value.userID != deletedURLs.UserIDValue || value.deletedAt != nil {
+			continue
 		}
-		return -1
+		previous = append(previous, value)
+		value.deletedAt = &now
+		value.updatedAt = now
+		shard.put(item, value)
+
+		records = append(records, &ItemType{
+			Type:          ItemTypeDelete,
+			Timestamp:     &now,
+			ShortURLValue: item,
+			UserID:        deletedURLs.UserIDValue,
+		})
+	}
+	if len(records) == 0 {
+		unlock()
+		return nil
+	}
+	// the batch is appended with one call and synced once, the same way as the batch creation.
+	// the records are appended before the links are unlocked, the links are rolled back if the log fails
+	seq, err := d.appendFile(records...)
+	if err != nil {
+		for i, v := range records {
+			d.shards[shardIndex(v.ShortURLValue)].put(v.ShortURLValue, previous[i])
+		}
+		unlock()
+		return err
 	}
+	unlock()
 
-	for _, item := range deletedURLs.ShortURLValues {
-		delete(d.Items, item)
+	users := d.users[userShardIndex(deletedURLs.UserIDValue)]
+	for i, record := range records {
+		users.remove(deletedURLs.UserIDValue, record.ShortURLValue, previous[i].longURL)
+	}
+	return d.commitFile(seq)
+}
 
-		a := d.UserItems[deletedURLs.UserIDValue]
-		i := index(item, a)
-		if i == -1 {
-			continue
+// func DeleteExpiredURL evicts expired links from memory storage,
+// the function returns count of evicted links.
+func (d *Dictionary) DeleteExpiredURL(ctx context.Context) (int, error) {
+	now := time.Now()
+	count := 0
+	for _, shard := range d.shards {
+		var expired []dictionaryItem
+		var expiredValues []string
+
+		shard.Lock()
+		for shortURL, expiresAt := range shard.expiring {
+			// the deleted links are hard-deleted by the purge only, it applies the short URL values reuse policy
+			if _, deleted := shard.deleted[shortURL]; deleted {
+				continue
+			}
+			if isExpired(&expiresAt, now) {
+				expired = append(expired, shard.items[shortURL])
+				expiredValues = append(expiredValues, shortURL)
+				shard.remove(shortURL)
+			}
 		}
+		shard.Unlock()
 
-		a[i] = a[len(a)-1]
-		a[len(a)-1] = ""
-		d.UserItems[deletedURLs.UserIDValue] = a[:len(a)-1]
+		for i, item := range expired {
+			if item.owned {
+				d.users[userShardIndex(item.userID)].remove(item.userID, expiredValues[i], item.longURL)
+			}
+		}
+		count += len(expired)
 	}
-	return nil
+	if count > 0 {
+		d.notifyRemoved()
+	}
+	return count, nil
 }
 
-// func Close inteface plug.
+// func Close stops the memory storage background workers, flushes and closes the file storage log.
 func (d *Dictionary) Close() error {
+	if d.done != nil {
+		close(d.done)
+	}
+	if d.writer != nil {
+		return d.writer.Close()
+	}
 	return nil
 }
 
 // func GetInternalStats counts the number of URLs and the number of users in the service.
 func (d *Dictionary) GetInternalStats(ctx context.Context) (InternalStats, error) {
-	return InternalStats{
-		URLs:  len(d.Items),
-		Users: len(d.UserItems),
-	}, nil
+	now := time.Now()
+	result := InternalStats{}
+	for _, shard := range d.shards {
+		shard.RLock()
+		result.URLs += len(shard.items) - len(shard.deleted)
+		for shortURL, expiresAt := range shard.expiring {
+			if _, deleted := shard.deleted[shortURL]; !deleted && isExpired(&expiresAt, now) {
+				result.URLs--
+			}
+		}
+		shard.RUnlock()
+	}
+
+	for _, users := range d.users {
+		users.RLock()
+		result.Users += len(users.users)
+		users.RUnlock()
+	}
+	return result, nil
 }
 
 // type URLItem is a linked list storage item.
 type URLItem struct {
+	CreatedAt        time.Time
+	UpdatedAt        time.Time // last destination edit, deletion or restoration time
+	LastAccessedAt   *time.Time
 	Next             *URLItem
+	ExpiresAt        *time.Time
+	DeletedAt        *time.Time   // soft deletion time, the linked list storage does not purge the deleted items
+	Versions         []URLVersion // destinations of the edited item, empty if the item was never edited
 	ShortURLValue    string
 	OriginalURLValue string
+	PasswordHash     string // salted password hash of the protected item
+	ClicksLeft       *int64 // clicks left of the limited item
 }
 
 // type LinkedListURLItem is a linked list storage implementation.
@@ -531,15 +1088,62 @@ func NewLinkedListStorage() Storage {
 	}
 }
 
+// func exists checks that the short URL value is used by any user of linked list storage.
+func (l UsersLinkedListMemoryStorage) exists(shortURLValue string) bool {
+	for _, v := range l.LinkedListStorage {
+		for currentNode := v.Head; currentNode != nil; currentNode = currentNode.Next {
+			if currentNode.ShortURLValue == shortURLValue {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // func AddURL add original URL value to linked list storage.
-func (l UsersLinkedListMemoryStorage) AddURL(ctx context.Context, longURLValue string, shortURLValue string, userID int32) (string, error) {
+func (l UsersLinkedListMemoryStorage) AddURL(ctx context.Context, longURLValue string, shortURLValue string, userID int32, opts URLOptions) (string, error) {
 	if strings.TrimSpace(longURLValue) == "" {
 		return "", errors.New("empty long URL value")
 	}
 
+	if err := ValidateShortURL(shortURLValue); err != nil {
+		return "", err
+	}
+
+	if list := l.LinkedListStorage[userID]; list != nil {
+		for currentNode := list.Head; currentNode != nil; currentNode = currentNode.Next {
+			if currentNode.DeletedAt == nil && currentNode.OriginalURLValue == longURLValue {
+				return currentNode.ShortURLValue, ErrDuplicateRecord
+			}
+		}
+	}
+
+	if l.exists(shortURLValue) {
+		return "", ErrShortURLTaken
+	}
+
+	expiresAt, err := opts.Expiration(time.Now())
+	if err != nil {
+		return "", err
+	}
+	passwordHash, err := opts.PasswordHash()
+	if err != nil {
+		return "", err
+	}
+	clicksLeft, err := opts.ClicksLeft()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
 	u := &URLItem{
 		ShortURLValue:    shortURLValue,
 		OriginalURLValue: longURLValue,
+		ExpiresAt:        expiresAt,
+		PasswordHash:     passwordHash,
+		ClicksLeft:       clicksLeft,
+		CreatedAt:        now,
+		UpdatedAt:        now,
 		Next:             nil,
 	}
 
@@ -561,81 +1165,102 @@ func (l UsersLinkedListMemoryStorage) AddURL(ctx context.Context, longURLValue s
 	return shortURLValue, nil
 }
 
+// func linkedURLValue returns the original URL value of the item, checks the item deletion, expiration time, password and click limit.
+func (u *URLItem) linkedURLValue() (string, error) {
+	if u.DeletedAt != nil {
+		return u.OriginalURLValue, ErrNotExistRecord
+	}
+	if isExpired(u.ExpiresAt, time.Now()) {
+		return u.OriginalURLValue, ErrExpiredRecord
+	}
+	return availableURL(u.OriginalURLValue, u.PasswordHash, u.ClicksLeft)
+}
+
 // func GetURL get original URL value by a short value from linked list storage.
 func (l UsersLinkedListMemoryStorage) GetURL(ctx context.Context, shortURLValue string) (string, error) {
+	longURL, _, err := l.LookupURL(ctx, shortURLValue)
+	return longURL, err
+}
+
+// func LookupURL get original URL value by a short value from linked list storage with the link expiration time.
+func (l UsersLinkedListMemoryStorage) LookupURL(ctx context.Context, shortURLValue string) (string, *time.Time, error) {
 	if strings.TrimSpace(shortURLValue) == "" {
-		return "", errors.New("empty short URL value")
+		return "", nil, errors.New("empty short URL value")
 	}
 
 	for _, v := range l.LinkedListStorage {
-		currentNode := v.Head
-		if currentNode.ShortURLValue == shortURLValue {
-			return currentNode.OriginalURLValue, nil
-		}
-		if currentNode == nil {
-			continue
-		}
-		for currentNode.Next != nil {
+		for currentNode := v.Head; currentNode != nil; currentNode = currentNode.Next {
 			if currentNode.ShortURLValue == shortURLValue {
-				return currentNode.OriginalURLValue, nil
+				longURL, err := currentNode.linkedURLValue()
+				return longURL, currentNode.ExpiresAt, err
 			}
-			currentNode = currentNode.Next
 		}
 	}
-	return "", nil
+	return "", nil, nil
 }
 
-// func GetUserURL get short URL value and original URL value pairs array created by user.
+// func GetUserURL get short URL value and original URL value pairs array created by user in the order of creation.
 func (l UsersLinkedListMemoryStorage) GetUserURL(ctx context.Context, prefix string, userID int32) ([]UserExportType, error) {
-	items := l.LinkedListStorage[userID]
-
-	result := []UserExportType{}
-	if items == nil || items.Head == nil {
-		return result, nil
-	}
-
-	currntItem := items.Head
-	for currntItem != nil {
-		item := &UserExportType{
-			ShortURL:    currntItem.ShortURLValue,
-			OriginalURL: currntItem.OriginalURLValue,
-		}
+	page, err := l.ListUserURL(ctx, prefix, userID, ListOptions{})
+	return page.Items, err
+}
 
-		if strings.TrimSpace(prefix) != "" {
-			item.ShortURL = prefix + "/" + item.ShortURL
+// func GetURLOwner get ID of the user created the short URL value from linked list storage.
+func (l UsersLinkedListMemoryStorage) GetURLOwner(ctx context.Context, shortURLValue string) (int32, error) {
+	for userID, list := range l.LinkedListStorage {
+		for currentItem := list.Head; currentItem != nil; currentItem = currentItem.Next {
+			if currentItem.ShortURLValue == shortURLValue {
+				return userID, nil
+			}
 		}
-		result = append(result, *item)
-
-		currntItem = currntItem.Next
 	}
-
-	return result, nil
+	return 0, ErrNotExistRecord
 }
 
 // func PostAPIBatch - group addition of short URL values to the linked list storage via api.
+// every item gets its own outcome.
 //
 // items - array of BatchRequest
 // prefix - shortener service name
 // userID - user ID
 func (l UsersLinkedListMemoryStorage) PostAPIBatch(ctx context.Context, items *BatchRequestArray, prefix string, userID int32) (*BatchResponseArray, error) {
+	now := time.Now()
+	plan := newBatchPlan(items, prefix, now)
+
 	list := l.LinkedListStorage[userID]
 	if list == nil {
 		list = &LinkedListURLItem{}
 	}
 
-	result := &BatchResponseArray{}
-	for _, v := range *items {
-		batchResponseItem := BatchResponse{
-			CorrelationID: v.CorrelationID,
-			ShortURL:      v.ShortURL,
+	originals := make(map[string]string)
+	for currentNode := list.Head; currentNode != nil; currentNode = currentNode.Next {
+		if currentNode.DeletedAt != nil {
+			continue
 		}
-		if strings.TrimSpace(prefix) != "" {
-			batchResponseItem.ShortURL = prefix + "/" + v.ShortURL
+		if _, ok := originals[currentNode.OriginalURLValue]; !ok {
+			originals[currentNode.OriginalURLValue] = currentNode.ShortURLValue
+		}
+	}
+
+	for _, i := range plan.pending {
+		v := (*items)[i]
+		if shortURL, ok := originals[v.OriginalURL]; ok {
+			plan.exists(i, shortURL)
+			continue
+		}
+		if l.exists(v.ShortURL) {
+			plan.fail(i, BatchStatusTaken, ErrShortURLTaken.Error())
+			continue
 		}
 
 		item := &URLItem{
 			ShortURLValue:    v.ShortURL,
 			OriginalURLValue: v.OriginalURL,
+			ExpiresAt:        plan.expiresAt[i],
+			PasswordHash:     plan.passwordHash[i],
+			ClicksLeft:       plan.clicksLeft[i],
+			CreatedAt:        now.UTC(),
+			UpdatedAt:        now.UTC(),
 			Next:             nil,
 		}
 
@@ -646,11 +1271,13 @@ func (l UsersLinkedListMemoryStorage) PostAPIBatch(ctx context.Context, items *B
 			currentNode.Next = item
 		}
 		list.Tail = item
-		*result = append(*result, batchResponseItem)
+		plan.created(i)
 	}
 
-	l.LinkedListStorage[userID] = list
-	return result, nil
+	if list.Head != nil {
+		l.LinkedListStorage[userID] = list
+	}
+	return plan.finish(), nil
 }
 
 // func Ping interface plug
@@ -658,45 +1285,53 @@ func (l UsersLinkedListMemoryStorage) Ping(ctx context.Context) error {
 	return nil
 }
 
-// func DeleteUserURL delete user URLs from linked list storage
+// func DeleteUserURL soft-deletes user URLs at linked list storage, the deleted items keep their short URL values.
 func (l UsersLinkedListMemoryStorage) DeleteUserURL(ctx context.Context, deletedURLs *DeletedShortURLValues) error {
-	userID := deletedURLs.UserIDValue
-	list := l.LinkedListStorage[userID]
-
-	if list == nil || list.Head == nil {
+	list := l.LinkedListStorage[deletedURLs.UserIDValue]
+	if list == nil {
 		return nil
 	}
 
-	for _, deletedShortURL := range deletedURLs.ShortURLValues {
-		currentItem := list.Head
-		if currentItem.ShortURLValue == deletedShortURL {
-			if currentItem.Next == nil {
-				list.Head = nil
-				list.Tail = nil
-				break
-			}
-			list.Head = currentItem.Next
-			continue
-		}
-		for currentItem.Next != nil {
-			if currentItem.Next.ShortURLValue == deletedShortURL {
-				if currentItem.Next.Next != nil {
-					tempItem := currentItem.Next.Next
-					currentItem.Next = tempItem
-				} else {
-					currentItem.Next = nil
-					list.Tail = currentItem
-				}
-				break
-			}
+	deleted := make(map[string]struct{}, len(deletedURLs.ShortURLValues))
+	for _, v := range deletedURLs.ShortURLValues {
+		deleted[v] = struct{}{}
+	}
 
-			currentItem = currentItem.Next
+	now := time.Now().UTC()
+	for currentItem := list.Head; currentItem != nil; currentItem = currentItem.Next {
+		if _, ok := deleted[currentItem.ShortURLValue]; ok && currentItem.DeletedAt == nil {
+			currentItem.DeletedAt = &now
+			currentItem.UpdatedAt = now
 		}
 	}
+	return nil
+}
 
-	l.LinkedListStorage[userID] = list
+// func DeleteExpiredURL evicts expired links from linked list storage,
+// the function returns count of evicted links.
+func (l UsersLinkedListMemoryStorage) DeleteExpiredURL(ctx context.Context) (int, error) {
+	now := time.Now()
+	counter := 0
+	for _, list := range l.LinkedListStorage {
+		var prevItem *URLItem
+		for currentItem := list.Head; currentItem != nil; currentItem = currentItem.Next {
+			if !isExpired(currentItem.ExpiresAt, now) {
+				prevItem = currentItem
+				continue
+			}
 
-	return nil
+			counter++
+			if prevItem == nil {
+				list.Head = currentItem.Next
+			} else {
+				prevItem.Next = currentItem.Next
+			}
+			if list.Tail == currentItem {
+				list.Tail = prevItem
+			}
+		}
+	}
+	return counter, nil
 }
 
 // func Close interface plug.
@@ -704,20 +1339,29 @@ func (l UsersLinkedListMemoryStorage) Close() error {
 	return nil
 }
 
-// func GetInternalStats counts the number of URLs and the number of users in the service.
+// func GetInternalStats counts the number of URLs and the number of users in the service,
+// the users without live links are not counted.
 func (l UsersLinkedListMemoryStorage) GetInternalStats(ctx context.Context) (InternalStats, error) {
+	now := time.Now()
 	counter := 0
+	users := 0
 	for _, v := range l.LinkedListStorage {
+		live := counter
 		currentItem := v.Head
 		for currentItem != nil {
-			counter++
+			if currentItem.DeletedAt == nil && !isExpired(currentItem.ExpiresAt, now) {
+				counter++
+			}
 			currentItem = currentItem.Next
 		}
+		if counter > live {
+			users++
+		}
 	}
 
 	internalStats := InternalStats{
 		URLs:  counter,
-		Users: len(l.LinkedListStorage),
+		Users: users,
 	}
 
 	return internalStats, nil
@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgtype"
+)
+
+// type AccessTrackingStorage is a storage decorator which keeps the last access time of the links.
+// the access times of the found links are collected in memory and written to the storage by the background worker,
+// so the lookups are not slowed down by the writes. The access times collected after the last flush are written on Close.
+type AccessTrackingStorage struct {
+	Storage
+	accessed map[string]time.Time
+	done     chan struct{}
+	mu       sync.Mutex
+}
+
+// func NewAccessTrackingStorage wraps the storage with the access tracking, the access times are written every interval.
+func NewAccessTrackingStorage(repo Storage, wg *sync.WaitGroup, interval time.Duration) *AccessTrackingStorage {
+	a := &AccessTrackingStorage{
+		Storage:  repo,
+		accessed: make(map[string]time.Time),
+		done:     make(chan struct{}),
+	}
+	startPeriodic(wg, a.done, interval, func() {
+		if err := a.Flush(context.Background()); err != nil {
+			log.Printf("links access tracking: %v", err)
+		}
+	})
+	return a
+}
+
+// func GetURL looks the link up in the storage and remembers the access time of the found link.
+// the lookups of the protected and the limited links are accesses too, the links redirect after the password check or the click.
+func (a *AccessTrackingStorage) GetURL(ctx context.Context, shortURLValue string) (string, error) {
+	longURL, err := a.Storage.GetURL(ctx, shortURLValue)
+	if (err == nil || errors.Is(err, ErrPasswordRequired) || errors.Is(err, ErrClicksLimited)) && longURL != "" {
+		a.touch(shortURLValue)
+	}
+	return longURL, err
+}
+
+// func CheckURLPassword checks the link password and remembers the access time of the resolved link.
+func (a *AccessTrackingStorage) CheckURLPassword(ctx context.Context, shortURLValue string, password string) (string, error) {
+	longURL, err := a.Storage.CheckURLPassword(ctx, shortURLValue, password)
+	if (err == nil || errors.Is(err, ErrClicksLimited)) && longURL != "" {
+		a.touch(shortURLValue)
+	}
+	return longURL, err
+}
+
+// func PeekURL looks the link up in the storage without remembering the access time.
+func (a *AccessTrackingStorage) PeekURL(ctx context.Context, shortURLValue string) (string, error) {
+	return a.Storage.GetURL(ctx, shortURLValue)
+}
+
+// type Peeker is an optional storage interface, the storages counting the lookups as the link accesses implement it.
+type Peeker interface {
+	PeekURL(ctx context.Context, shortURLValue string) (string, error)
+}
+
+// func PeekURL looks the link up the same way as GetURL, but the lookup is not the link access,
+// so showing the link, for example as QR code, does not make the idle link active.
+func PeekURL(ctx context.Context, repo Storage, shortURLValue string) (string, error) {
+	if peeker, ok := repo.(Peeker); ok {
+		return peeker.PeekURL(ctx, shortURLValue)
+	}
+	return repo.GetURL(ctx, shortURLValue)
+}
+
+// func touch remembers the access time of the link.
+func (a *AccessTrackingStorage) touch(shortURLValue string) {
+	now := time.Now().UTC()
+	a.mu.Lock()
+	a.accessed[shortURLValue] = now
+	a.mu.Unlock()
+}
+
+// func Flush writes the collected access times to the storage.
+func (a *AccessTrackingStorage) Flush(ctx context.Context) error {
+	a.mu.Lock()
+	accessed := a.accessed
+	a.accessed = make(map[string]time.Time)
+	a.mu.Unlock()
+
+	if len(accessed) == 0 {
+		return nil
+	}
+	return a.Storage.TouchURL(ctx, accessed)
+}
+
+// func Compact compacts the storage log if the storage supports it.
+func (a *AccessTrackingStorage) Compact(ctx context.Context) (int, error) {
+	compactor, ok := a.Storage.(Compactor)
+	if !ok {
+		return 0, ErrCompactionUnsupported
+	}
+	return compactor.Compact(ctx)
+}
+
+// func Close stops the background worker, writes the collected access times and closes the storage.
+func (a *AccessTrackingStorage) Close() error {
+	close(a.done)
+	if err := a.Flush(context.Background()); err != nil {
+		log.Printf("links access tracking: %v", err)
+	}
+	return a.Storage.Close()
+}
+
+// func laterTime returns the later of the times.
+func laterTime(t time.Time, other time.Time) time.Time {
+	if other.After(t) {
+		return other
+	}
+	return t
+}
+
+// func laterAccess returns the later of the access times, nil access time means that the link was never accessed.
+func laterAccess(accessedAt *time.Time, other time.Time) *time.Time {
+	if accessedAt != nil && !other.After(*accessedAt) {
+		return accessedAt
+	}
+	return &other
+}
+
+// func timeOrNil returns nil for the zero time, the time is unknown to the storage.
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// func TouchURL sets the last access time of the links at the postgres database, the later access time is kept.
+func (ps *PostgresStorage) TouchURL(ctx context.Context, accessed map[string]time.Time) error {
+	shortURLValues := make([]string, 0, len(accessed))
+	accessedAt := make([]time.Time, 0, len(accessed))
+	for shortURL, t := range accessed {
+		shortURLValues = append(shortURLValues, shortURL)
+		accessedAt = append(accessedAt, t)
+	}
+
+	shortURLArray := &pgtype.TextArray{}
+	if err := shortURLArray.Set(shortURLValues); err != nil {
+		return err
+	}
+	accessedAtArray := &pgtype.TimestamptzArray{}
+	if err := accessedAtArray.Set(accessedAt); err != nil {
+		return err
+	}
+
+	_, err := ps.db.ExecContext(ctx,
+		"UPDATE shortener AS s SET last_accessed_at = GREATEST(s.last_accessed_at, t.accessed_at) "+
+			"FROM unnest($1::text[], $2::timestamptz[]) AS t(short_url, accessed_at) "+
+			"WHERE s.short_url = t.short_url;",
+		shortURLArray, accessedAtArray)
+	return err
+}
+
+// func TouchURL sets the last access time of the links at memory storage, the later access time is kept.
+// the access times are written to the file after the shards are unlocked.
+func (d *Dictionary) TouchURL(ctx context.Context, accessed map[string]time.Time) error {
+	records := make([]*ItemType, 0, len(accessed))
+	for shortURL, t := range accessed {
+		shard := d.shards[shardIndex(shortURL)]
+		shard.Lock()
+		value, ok := shard.items[shortURL]
+		if ok {
+			value.accessedAt = laterAccess(value.accessedAt, t.UTC())
+			shard.put(shortURL, value)
+		}
+		shard.Unlock()
+
+		if ok {
+			accessedAt := t.UTC()
+			records = append(records, &ItemType{
+				Type:          ItemTypeAccess,
+				Timestamp:     &accessedAt,
+				ShortURLValue: shortURL,
+			})
+		}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	return d.writeFile(records...)
+}
+
+// func TouchURL sets the last access time of the links at linked list storage, the later access time is kept.
+func (l UsersLinkedListMemoryStorage) TouchURL(ctx context.Context, accessed map[string]time.Time) error {
+	for _, list := range l.LinkedListStorage {
+		for currentItem := list.Head; currentItem != nil; currentItem = currentItem.Next {
+			if t, ok := accessed[currentItem.ShortURLValue]; ok {
+				currentItem.LastAccessedAt = laterAccess(currentItem.LastAccessedAt, t.UTC())
+			}
+		}
+	}
+	return nil
+}
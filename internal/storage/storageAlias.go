@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	minAliasLengthConst = 3  // minimal length of the custom short URL value
+	maxAliasLengthConst = 32 // maximal length of the custom short URL value
+)
+
+// Short URL value validation errors.
+var (
+	ErrShortURLTaken = errors.New("short URL value already taken")      // short URL value used by another record
+	ErrAliasInvalid  = errors.New("short URL value has invalid format") // bad length or characters
+	ErrAliasReserved = errors.New("short URL value is a reserved word") // short URL value clashes with service paths
+)
+
+// reserved words list, these values are used by the service endpoints.
+var reservedAliasValues = map[string]struct{}{
+	"api":   {},
+	"ping":  {},
+	"debug": {},
+}
+
+// func ValidateShortURL checks the length, allowed character set and reserved words of the short URL value.
+func ValidateShortURL(shortURLValue string) error {
+	if len(shortURLValue) < minAliasLengthConst || len(shortURLValue) > maxAliasLengthConst {
+		return ErrAliasInvalid
+	}
+
+	for _, r := range shortURLValue {
+		switch {
+		case r >= '0' && r <= '9':
+		case r >= 'a' && r <= 'z':
+		case r >= 'A' && r <= 'Z':
+		case r == '-' || r == '_':
+		default:
+			return ErrAliasInvalid
+		}
+	}
+
+	if _, ok := reservedAliasValues[strings.ToLower(shortURLValue)]; ok {
+		return ErrAliasReserved
+	}
+	return nil
+}
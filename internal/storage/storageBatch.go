@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"strings"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+)
+
+// batch item statuses.
+const (
+	BatchStatusCreated = "created" // the link is created
+	BatchStatusExists  = "exists"  // the user has already shortened the original URL, the existing short URL is returned
+	BatchStatusInvalid = "invalid" // the original URL, the alias, the expiration, the password or the max clicks value is invalid
+	BatchStatusTaken   = "taken"   // the short URL value is used by another link
+)
+
+// type batchPlan is the per-item state of the batch request shared by the storages.
+// every item gets its own outcome, the storages insert the pending items only.
+type batchPlan struct {
+	items        *BatchRequestArray
+	result       BatchResponseArray
+	expiresAt    []*time.Time
+	passwordHash []string
+	clicksLeft   []*int64
+	pending      []int // indexes of the valid items, the first item of every original URL
+	sameAs       []int // index of the pending item with the same original URL, -1 if there is no such item
+	prefix       string
+}
+
+// func newBatchPlan validates the batch items, invalid items and the aliases repeated inside the batch get their outcome,
+// items which repeat the original URL of a previous item get the outcome of that item.
+func newBatchPlan(items *BatchRequestArray, prefix string, now time.Time) *batchPlan {
+	p := &batchPlan{
+		items:        items,
+		result:       make(BatchResponseArray, len(*items)),
+		expiresAt:    make([]*time.Time, len(*items)),
+		passwordHash: make([]string, len(*items)),
+		clicksLeft:   make([]*int64, len(*items)),
+		sameAs:       make([]int, len(*items)),
+		prefix:       prefix,
+	}
+
+	shortURLs := make(map[string]struct{}, len(*items))
+	originals := make(map[string]int, len(*items))
+	for i, v := range *items {
+		p.result[i].CorrelationID = v.CorrelationID
+		p.sameAs[i] = -1
+
+		if strings.TrimSpace(v.OriginalURL) == "" || !govalidator.IsURL(v.OriginalURL) {
+			p.fail(i, BatchStatusInvalid, ErrURLInvalid.Error())
+			continue
+		}
+		if err := ValidateShortURL(v.ShortURL); err != nil {
+			p.fail(i, BatchStatusInvalid, err.Error())
+			continue
+		}
+		expiresAt, err := v.Expiration(now)
+		if err != nil {
+			p.fail(i, BatchStatusInvalid, err.Error())
+			continue
+		}
+		p.expiresAt[i] = expiresAt
+		if p.passwordHash[i], err = v.PasswordHash(); err != nil {
+			p.fail(i, BatchStatusInvalid, err.Error())
+			continue
+		}
+		if p.clicksLeft[i], err = v.ClicksLeft(); err != nil {
+			p.fail(i, BatchStatusInvalid, err.Error())
+			continue
+		}
+
+		if first, ok := originals[v.OriginalURL]; ok {
+			p.sameAs[i] = first
+			continue
+		}
+		if _, ok := shortURLs[v.ShortURL]; ok {
+			p.fail(i, BatchStatusTaken, ErrShortURLTaken.Error())
+			continue
+		}
+		shortURLs[v.ShortURL] = struct{}{}
+		originals[v.OriginalURL] = i
+		p.pending = append(p.pending, i)
+	}
+	return p
+}
+
+// func created sets the created outcome of the item.
+func (p *batchPlan) created(i int) {
+	p.result[i].Status = BatchStatusCreated
+	p.result[i].ShortURL = p.shortURL((*p.items)[i].ShortURL)
+}
+
+// func exists sets the outcome of the item which original URL is already shortened by the user.
+func (p *batchPlan) exists(i int, shortURLValue string) {
+	p.result[i].Status = BatchStatusExists
+	p.result[i].ShortURL = p.shortURL(shortURLValue)
+}
+
+// func fail sets the outcome of the item which is not created.
+func (p *batchPlan) fail(i int, status string, message string) {
+	p.result[i].Status = status
+	p.result[i].Error = message
+}
+
+// func finish sets the outcome of the items which repeat the original URL of a pending item and returns the result.
+func (p *batchPlan) finish() *BatchResponseArray {
+	for i, first := range p.sameAs {
+		if first < 0 {
+			continue
+		}
+		switch p.result[first].Status {
+		case BatchStatusCreated, BatchStatusExists:
+			p.result[i].Status = BatchStatusExists
+			p.result[i].ShortURL = p.result[first].ShortURL
+		default:
+			p.fail(i, p.result[first].Status, p.result[first].Error)
+		}
+	}
+	return &p.result
+}
+
+// func shortURL returns the short URL value with the service prefix.
+func (p *batchPlan) shortURL(shortURLValue string) string {
+	if strings.TrimSpace(p.prefix) == "" {
+		return shortURLValue
+	}
+	return p.prefix + "/" + shortURLValue
+}
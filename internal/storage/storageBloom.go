@@ -0,0 +1,335 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const bloomMinBitsConst = 64 // the smallest filter size
+
+// Bloom filter errors.
+var (
+	ErrBloomUnsupported = errors.New("storage does not support short URL values listing")
+	ErrBloomInvalid     = errors.New("bloom filter settings are invalid")
+)
+
+// type ShortURLIterator is an optional storage interface, storages which can list all short URL values implement it.
+type ShortURLIterator interface {
+	// ForEachShortURL calls fn for every short URL value of the storage, deleted and expired ones too.
+	ForEachShortURL(ctx context.Context, fn func(shortURLValue string) error) error
+}
+
+type (
+	// type bloomFilter is a concurrency-safe Bloom filter of strings, the bits are set with atomic operations.
+	bloomFilter struct {
+		bits   []uint64
+		size   uint64 // count of bits
+		hashes uint64 // count of hash functions
+	}
+
+	// type BloomStorage is a storage decorator which answers the lookups of the short URL values
+	// which certainly do not exist without a storage call. The filter is built at the start,
+	// the links created through the decorator are added to it. Deleted links stay in the filter,
+	// their lookups go to the storage. Links created by other service instances are not seen
+	// until the filter is rebuilt, so the storage shared by the instances needs the periodic rebuild.
+	BloomStorage struct {
+		skipped uint64 // count of the lookups answered by the filter, first to be 64-bit aligned
+		Storage
+		filter        atomic.Value // *bloomFilter, replaced by the rebuild
+		next          *bloomFilter // the filter being rebuilt, nil between the rebuilds
+		mu            sync.RWMutex // the additions hold it for reading, the rebuild start and finish for writing
+		done          chan struct{}
+		memory        int
+		falsePositive float64
+		capacity      int
+	}
+)
+
+// func newBloomFilter creates the filter which uses at most memory bytes and has the false positive rate
+// until the capacity returned by the function is exceeded.
+func newBloomFilter(memory int, falsePositive float64) (*bloomFilter, int, error) {
+	if memory <= 0 || falsePositive <= 0 || falsePositive >= 1 {
+		return nil, 0, fmt.Errorf("%w: memory %d bytes, false positive rate %v", ErrBloomInvalid, memory, falsePositive)
+	}
+
+	size := uint64(memory) * 8
+	if size < bloomMinBitsConst {
+		size = bloomMinBitsConst
+	}
+	hashes := uint64(math.Ceil(-math.Log2(falsePositive)))
+	capacity := int(float64(size) * math.Ln2 * math.Ln2 / -math.Log(falsePositive))
+
+	return &bloomFilter{
+		bits:   make([]uint64, (size+63)/64),
+		size:   size,
+		hashes: hashes,
+	}, capacity, nil
+}
+
+// func add sets the bits of the value.
+func (f *bloomFilter) add(value string) {
+	h1, h2 := bloomHashes(value)
+	for i := uint64(0); i < f.hashes; i++ {
+		bit := (h1 + i*h2) % f.size
+		word := &f.bits[bit/64]
+		mask := uint64(1) << (bit % 64)
+		for {
+			old := atomic.LoadUint64(word)
+			if old&mask != 0 || atomic.CompareAndSwapUint64(word, old, old|mask) {
+				break
+			}
+		}
+	}
+}
+
+// func mayContain checks the bits of the value, false means the value was never added.
+func (f *bloomFilter) mayContain(value string) bool {
+	h1, h2 := bloomHashes(value)
+	for i := uint64(0); i < f.hashes; i++ {
+		bit := (h1 + i*h2) % f.size
+		if atomic.LoadUint64(&f.bits[bit/64])&(uint64(1)<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// func bloomHashes returns two hashes of the value for the double hashing,
+// FNV-1a hash and its SplitMix64 finalization are used.
+func bloomHashes(value string) (uint64, uint64) {
+	h1 := uint64(14695981039346656037)
+	for i := 0; i < len(value); i++ {
+		h1 ^= uint64(value[i])
+		h1 *= 1099511628211
+	}
+
+	h2 := h1 + 0x9e3779b97f4a7c15
+	h2 = (h2 ^ (h2 >> 30)) * 0xbf58476d1ce4e5b9
+	h2 = (h2 ^ (h2 >> 27)) * 0x94d049bb133111eb
+	h2 ^= h2 >> 31
+	return h1, h2 | 1
+}
+
+// func NewBloomStorage builds the filter of the storage short URL values and wraps the storage with it.
+// memory is the filter size in bytes, falsePositive is the rate of the unknown values passed to the storage.
+func NewBloomStorage(ctx context.Context, repo Storage, memory int, falsePositive float64) (*BloomStorage, error) {
+	iterator, ok := repo.(ShortURLIterator)
+	if !ok {
+		return nil, ErrBloomUnsupported
+	}
+
+	filter, capacity, err := newBloomFilter(memory, falsePositive)
+	if err != nil {
+		return nil, err
+	}
+	if err = fillBloomFilter(ctx, iterator, filter, capacity, falsePositive); err != nil {
+		return nil, err
+	}
+
+	b := &BloomStorage{
+		Storage:       repo,
+		done:          make(chan struct{}),
+		memory:        memory,
+		falsePositive: falsePositive,
+		capacity:      capacity,
+	}
+	b.filter.Store(filter)
+	return b, nil
+}
+
+// func fillBloomFilter adds the storage short URL values to the filter.
+func fillBloomFilter(ctx context.Context, iterator ShortURLIterator, filter *bloomFilter, capacity int, falsePositive float64) error {
+	count := 0
+	err := iterator.ForEachShortURL(ctx, func(shortURLValue string) error {
+		filter.add(shortURLValue)
+		count++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if count > capacity {
+		log.Printf("bloom filter: %d short URL values exceed the capacity %d, the false positive rate is higher than %v",
+			count, capacity, falsePositive)
+	}
+	return nil
+}
+
+// func startRebuild launches the worker which rebuilds the filter every interval until the storage is closed.
+// zero or negative interval disables the worker.
+func (b *BloomStorage) startRebuild(wg *sync.WaitGroup, interval time.Duration) {
+	startPeriodic(wg, b.done, interval, func() {
+		if err := b.Rebuild(context.Background()); err != nil {
+			log.Printf("bloom filter rebuild: %v", err)
+		}
+	})
+}
+
+// func Rebuild builds the new filter of the storage short URL values and replaces the current one with it,
+// so the links created by other service instances are found. The filter is kept if the rebuild fails.
+// the links created during the rebuild are added to both filters, the lookups use the current one until the end.
+func (b *BloomStorage) Rebuild(ctx context.Context) error {
+	next, _, err := newBloomFilter(b.memory, b.falsePositive)
+	if err != nil {
+		return err
+	}
+
+	// the additions started before are finished, the later ones see the new filter
+	b.mu.Lock()
+	b.next = next
+	b.mu.Unlock()
+
+	err = fillBloomFilter(ctx, b.Storage.(ShortURLIterator), next, b.capacity, b.falsePositive)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.next = nil
+	if err != nil {
+		return err
+	}
+	b.filter.Store(next)
+	return nil
+}
+
+// func mayContain checks the short URL value at the current filter.
+func (b *BloomStorage) mayContain(shortURLValue string) bool {
+	return b.filter.Load().(*bloomFilter).mayContain(shortURLValue)
+}
+
+// func add adds the short URL value to the current filter and to the filter being rebuilt, b.mu must be held for reading.
+func (b *BloomStorage) add(shortURLValue string) {
+	b.filter.Load().(*bloomFilter).add(shortURLValue)
+	if b.next != nil {
+		b.next.add(shortURLValue)
+	}
+}
+
+// func GetURL returns the empty value without the storage call if the short URL value certainly does not exist.
+func (b *BloomStorage) GetURL(ctx context.Context, shortURLValue string) (string, error) {
+	if !b.mayContain(shortURLValue) {
+		atomic.AddUint64(&b.skipped, 1)
+		return "", nil
+	}
+	return b.Storage.GetURL(ctx, shortURLValue)
+}
+
+// func LookupURL looks the link up with the link expiration time if the filter may contain the short URL value.
+func (b *BloomStorage) LookupURL(ctx context.Context, shortURLValue string) (string, *time.Time, error) {
+	if !b.mayContain(shortURLValue) {
+		atomic.AddUint64(&b.skipped, 1)
+		return "", nil, nil
+	}
+	return lookupURL(ctx, b.Storage, shortURLValue)
+}
+
+// func OnRemoved registers the function called after the links removal if the storage supports it.
+func (b *BloomStorage) OnRemoved(fn func()) {
+	if notifier, ok := b.Storage.(RemovalNotifier); ok {
+		notifier.OnRemoved(fn)
+	}
+}
+
+// func AddURL adds the short URL value to the filter and creates the link.
+// the value is added before the storage call, so a concurrent lookup never misses the created link.
+// the lock is held until the link is created, so the rebuild which starts later lists the link.
+func (b *BloomStorage) AddURL(ctx context.Context, longURLValue string, shortURLValue string, userID int32, opts URLOptions) (string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	b.add(shortURLValue)
+	return b.Storage.AddURL(ctx, longURLValue, shortURLValue, userID, opts)
+}
+
+// func PostAPIBatch adds the short URL values to the filter and creates the links.
+func (b *BloomStorage) PostAPIBatch(ctx context.Context, items *BatchRequestArray, prefix string, userID int32) (*BatchResponseArray, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, v := range *items {
+		b.add(v.ShortURL)
+	}
+	return b.Storage.PostAPIBatch(ctx, items, prefix, userID)
+}
+
+// func GetInternalStats adds the filter counters to the storage statistics.
+func (b *BloomStorage) GetInternalStats(ctx context.Context) (InternalStats, error) {
+	stats, err := b.Storage.GetInternalStats(ctx)
+	if err != nil {
+		return stats, err
+	}
+	stats.Bloom = &BloomStats{
+		Skipped:  atomic.LoadUint64(&b.skipped),
+		Capacity: b.capacity,
+	}
+	return stats, nil
+}
+
+// func Close stops the filter rebuild and closes the storage.
+func (b *BloomStorage) Close() error {
+	close(b.done)
+	return b.Storage.Close()
+}
+
+// func Compact compacts the storage log if the storage supports it.
+func (b *BloomStorage) Compact(ctx context.Context) (int, error) {
+	compactor, ok := b.Storage.(Compactor)
+	if !ok {
+		return 0, ErrCompactionUnsupported
+	}
+	return compactor.Compact(ctx)
+}
+
+// func ForEachShortURL lists the storage short URL values.
+func (b *BloomStorage) ForEachShortURL(ctx context.Context, fn func(shortURLValue string) error) error {
+	return b.Storage.(ShortURLIterator).ForEachShortURL(ctx, fn)
+}
+
+// func ForEachShortURL calls fn for every short URL value of the postgres database.
+func (ps *PostgresStorage) ForEachShortURL(ctx context.Context, fn func(shortURLValue string) error) error {
+	rows, err := ps.db.QueryContext(ctx, "SELECT short_url FROM shortener ;")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var shortURLValue string
+		if err = rows.Scan(&shortURLValue); err != nil {
+			return err
+		}
+		if err = fn(shortURLValue); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// func ForEachShortURL calls fn for every short URL value of the memory storage,
+// fn is called without the storage locks held.
+func (d *Dictionary) ForEachShortURL(ctx context.Context, fn func(shortURLValue string) error) error {
+	for _, shard := range d.shards {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		shard.RLock()
+		values := make([]string, 0, len(shard.items))
+		for shortURLValue := range shard.items {
+			values = append(values, shortURLValue)
+		}
+		shard.RUnlock()
+
+		for _, v := range values {
+			if err := fn(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
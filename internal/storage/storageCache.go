@@ -0,0 +1,269 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// type cacheEntry is a cached result of the short URL value lookup.
+	cacheEntry struct {
+		expiresAt     time.Time
+		err           error
+		shortURLValue string
+		longURL       string
+	}
+
+	// type cacheCall is a lookup in progress, concurrent misses of the same short URL value wait for it.
+	cacheCall struct {
+		wg      sync.WaitGroup
+		err     error
+		longURL string
+	}
+
+	// type CachedStorage is a storage decorator which caches the GetURL results in a size-bounded LRU list.
+	// Links not found are cached too, with their own TTL. Found links are not kept after their expiration time.
+	// Methods which are not cached pass through to the storage.
+	CachedStorage struct {
+		hits   uint64 // the counters are first to be 64-bit aligned for the atomic operations
+		misses uint64
+		Storage
+		entries     map[string]*list.Element
+		order       *list.List // the most recently used entry is at the front
+		calls       map[string]*cacheCall
+		now         func() time.Time
+		size        int
+		ttl         time.Duration
+		negativeTTL time.Duration
+		epoch       uint64     // incremented on every invalidation, lookups started before it are not cached
+		mu          sync.Mutex // guards entries, order, calls and epoch
+	}
+)
+
+// func NewCachedStorage wraps the storage with the cache of size entries.
+// found links are kept for ttl, not found ones for negativeTTL, zero negativeTTL disables the negative cache.
+// changes made by other service instances are seen after ttl. The cache is dropped when the storage reaps or purges links.
+func NewCachedStorage(repo Storage, size int, ttl time.Duration, negativeTTL time.Duration) *CachedStorage {
+	c := &CachedStorage{
+		Storage:     repo,
+		entries:     make(map[string]*list.Element, size),
+		order:       list.New(),
+		calls:       make(map[string]*cacheCall),
+		now:         time.Now,
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+	}
+	if notifier, ok := repo.(RemovalNotifier); ok {
+		notifier.OnRemoved(c.invalidateAll)
+	}
+	return c
+}
+
+// func GetURL returns the cached lookup result or looks the link up in the storage,
+// only one lookup of the short URL value runs at a time.
+func (c *CachedStorage) GetURL(ctx context.Context, shortURLValue string) (string, error) {
+	c.mu.Lock()
+	if element, ok := c.entries[shortURLValue]; ok {
+		entry := element.Value.(*cacheEntry)
+		if c.now().Before(entry.expiresAt) {
+			c.order.MoveToFront(element)
+			c.mu.Unlock()
+			atomic.AddUint64(&c.hits, 1)
+			return entry.longURL, entry.err
+		}
+		c.removeElement(element)
+	}
+	atomic.AddUint64(&c.misses, 1)
+
+	if call, ok := c.calls[shortURLValue]; ok {
+		c.mu.Unlock()
+		call.wg.Wait()
+		return call.longURL, call.err
+	}
+	call := &cacheCall{}
+	call.wg.Add(1)
+	c.calls[shortURLValue] = call
+	epoch := c.epoch
+	c.mu.Unlock()
+
+	var expiresAt *time.Time
+	call.longURL, expiresAt, call.err = lookupURL(ctx, c.Storage, shortURLValue)
+
+	c.mu.Lock()
+	if c.calls[shortURLValue] == call {
+		delete(c.calls, shortURLValue)
+	}
+	if c.epoch == epoch {
+		c.put(shortURLValue, call.longURL, expiresAt, call.err)
+	}
+	c.mu.Unlock()
+	call.wg.Done()
+
+	return call.longURL, call.err
+}
+
+// func AddURL creates the link and drops the cached not found result of its short URL value.
+func (c *CachedStorage) AddURL(ctx context.Context, longURLValue string, shortURLValue string, userID int32, opts URLOptions) (string, error) {
+	result, err := c.Storage.AddURL(ctx, longURLValue, shortURLValue, userID, opts)
+	c.invalidate(shortURLValue, result)
+	return result, err
+}
+
+// func PostAPIBatch creates the links and drops the cached not found results of their short URL values.
+func (c *CachedStorage) PostAPIBatch(ctx context.Context, items *BatchRequestArray, prefix string, userID int32) (*BatchResponseArray, error) {
+	result, err := c.Storage.PostAPIBatch(ctx, items, prefix, userID)
+	values := make([]string, 0, len(*items))
+	for _, v := range *items {
+		values = append(values, v.ShortURL)
+	}
+	c.invalidate(values...)
+	return result, err
+}
+
+// func DeleteUserURL deletes the user links and drops their cached lookup results.
+func (c *CachedStorage) DeleteUserURL(ctx context.Context, deletedURLs *DeletedShortURLValues) error {
+	err := c.Storage.DeleteUserURL(ctx, deletedURLs)
+	c.invalidate(deletedURLs.ShortURLValues...)
+	return err
+}
+
+// func RestoreUserURL restores the user links and drops their cached lookup results.
+func (c *CachedStorage) RestoreUserURL(ctx context.Context, restoredURLs *DeletedShortURLValues) ([]string, error) {
+	restored, err := c.Storage.RestoreUserURL(ctx, restoredURLs)
+	c.invalidate(restored...)
+	return restored, err
+}
+
+// func UpdateURL edits the link and drops its cached lookup result.
+func (c *CachedStorage) UpdateURL(ctx context.Context, shortURLValue string, longURLValue string, userID int32) (URLVersion, error) {
+	version, err := c.Storage.UpdateURL(ctx, shortURLValue, longURLValue, userID)
+	c.invalidate(shortURLValue)
+	return version, err
+}
+
+// func GetInternalStats adds the cache counters to the storage statistics.
+func (c *CachedStorage) GetInternalStats(ctx context.Context) (InternalStats, error) {
+	stats, err := c.Storage.GetInternalStats(ctx)
+	if err != nil {
+		return stats, err
+	}
+
+	c.mu.Lock()
+	size := c.order.Len()
+	c.mu.Unlock()
+
+	stats.Cache = &CacheStats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+		Size:   size,
+	}
+	return stats, nil
+}
+
+// func Compact compacts the storage log if the storage supports it.
+func (c *CachedStorage) Compact(ctx context.Context) (int, error) {
+	compactor, ok := c.Storage.(Compactor)
+	if !ok {
+		return 0, ErrCompactionUnsupported
+	}
+	return compactor.Compact(ctx)
+}
+
+// func invalidate drops the cached results of the short URL values, lookups in progress are not cached.
+func (c *CachedStorage) invalidate(shortURLValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.epoch++
+	for _, v := range shortURLValues {
+		if element, ok := c.entries[v]; ok {
+			c.removeElement(element)
+		}
+		delete(c.calls, v)
+	}
+}
+
+// func invalidateAll drops all cached results, lookups in progress are not cached.
+func (c *CachedStorage) invalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.epoch++
+	c.entries = make(map[string]*list.Element, c.size)
+	c.order.Init()
+	c.calls = make(map[string]*cacheCall)
+}
+
+// func put caches the lookup result, errors other than not found are not cached.
+// the found link is not kept after its expiration time. mu must be held.
+func (c *CachedStorage) put(shortURLValue string, longURL string, linkExpiresAt *time.Time, err error) {
+	ttl := c.ttl
+	notFound := isNotFound(longURL, err)
+	if notFound {
+		ttl = c.negativeTTL
+	} else if err != nil {
+		return
+	}
+	if ttl <= 0 || c.size <= 0 {
+		return
+	}
+	expiresAt := c.now().Add(ttl)
+	if !notFound && linkExpiresAt != nil && linkExpiresAt.Before(expiresAt) {
+		expiresAt = *linkExpiresAt
+	}
+	if !c.now().Before(expiresAt) {
+		return
+	}
+
+	if element, ok := c.entries[shortURLValue]; ok {
+		c.removeElement(element)
+	}
+	for c.order.Len() >= c.size {
+		c.removeElement(c.order.Back())
+	}
+
+	c.entries[shortURLValue] = c.order.PushFront(&cacheEntry{
+		expiresAt:     expiresAt,
+		err:           err,
+		shortURLValue: shortURLValue,
+		longURL:       longURL,
+	})
+}
+
+// type ExpiringLookup is an optional storage interface, storages which know the link expiration time on lookup implement it.
+type ExpiringLookup interface {
+	// LookupURL looks the link up the same way as GetURL and returns the link expiration time, nil if the link does not expire.
+	LookupURL(ctx context.Context, shortURLValue string) (string, *time.Time, error)
+}
+
+// func lookupURL looks the link up with the link expiration time if the storage supports it.
+func lookupURL(ctx context.Context, repo Storage, shortURLValue string) (string, *time.Time, error) {
+	if lookup, ok := repo.(ExpiringLookup); ok {
+		return lookup.LookupURL(ctx, shortURLValue)
+	}
+	longURL, err := repo.GetURL(ctx, shortURLValue)
+	return longURL, nil, err
+}
+
+// func removeElement removes the entry from the cache, mu must be held.
+func (c *CachedStorage) removeElement(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).shortURLValue)
+}
+
+// func isNotFound checks that the lookup result means the link does not exist or is not available anymore.
+func isNotFound(longURL string, err error) bool {
+	if err == nil {
+		return longURL == ""
+	}
+	return errors.Is(err, ErrNotExistRecord) ||
+		errors.Is(err, ErrExpiredRecord) ||
+		errors.Is(err, ErrClicksExhausted) ||
+		errors.Is(err, sql.ErrNoRows)
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// link click limit errors.
+var (
+	ErrClicksLimited    = errors.New("link has limited clicks")          // the link resolves after its click is consumed by ConsumeClick
+	ErrClicksExhausted  = errors.New("link clicks are used up")          // the link does not resolve anymore
+	ErrMaxClicksInvalid = errors.New("link max clicks value is invalid") // the max clicks value is negative
+)
+
+// func ClicksLeft returns the clicks left of the new link, nil value means that the link is not limited.
+func (o URLOptions) ClicksLeft() (*int64, error) {
+	if o.MaxClicks < 0 {
+		return nil, ErrMaxClicksInvalid
+	}
+	if o.MaxClicks == 0 {
+		return nil, nil
+	}
+	clicksLeft := o.MaxClicks
+	return &clicksLeft, nil
+}
+
+// func availableURL returns the original URL value of the live link with the error which tells how the link resolves:
+// the used up link does not resolve, the protected link resolves after the password is checked,
+// the limited link resolves after its click is consumed.
+func availableURL(longURL string, passwordHash string, clicksLeft *int64) (string, error) {
+	if clicksLeft != nil && *clicksLeft <= 0 {
+		return longURL, ErrClicksExhausted
+	}
+	if passwordHash != "" {
+		return longURL, ErrPasswordRequired
+	}
+	return limitedURL(longURL, clicksLeft)
+}
+
+// func limitedURL returns ErrClicksLimited for the link with the click limit.
+func limitedURL(longURL string, clicksLeft *int64) (string, error) {
+	if clicksLeft != nil {
+		return longURL, ErrClicksLimited
+	}
+	return longURL, nil
+}
+
+// func copyCount returns the copy of the count, the copies of the link never share the clicks left.
+func copyCount(count *int64) *int64 {
+	if count == nil {
+		return nil
+	}
+	value := *count
+	return &value
+}
+
+// func sameCount checks that the counts are both unknown or equal.
+func sameCount(count *int64, other *int64) bool {
+	if count == nil || other == nil {
+		return count == nil && other == nil
+	}
+	return *count == *other
+}
+
+// func ConsumeClick uses up one click of the limited link at the postgres database, the links without limit are not changed.
+// the clicks left are decremented by the conditional update, so the concurrent clicks never use up more than the limit.
+func (ps *PostgresStorage) ConsumeClick(ctx context.Context, shortURLValue string) error {
+	cTag, err := ps.db.ExecContext(ctx,
+		"UPDATE shortener SET clicks_left = clicks_left - 1 "+
+			"WHERE short_url = $1 AND clicks_left > 0 AND deleted_at IS NULL AND (expires_at IS NULL OR expires_at > now()) ;",
+		shortURLValue)
+	if err != nil {
+		return err
+	}
+	cnt, err := cTag.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if cnt > 0 {
+		return nil
+	}
+
+	// the link is not limited or is not available, the clicks left never grow, so the used up link stays used up
+	var deletedAt *time.Time
+	var expiresAt *time.Time
+	var clicksLeft *int64
+	err = ps.db.QueryRowContext(ctx,
+		"SELECT deleted_at, expires_at, clicks_left "+
+			"FROM shortener "+
+			"WHERE short_url = $1 ;",
+		shortURLValue).Scan(&deletedAt, &expiresAt, &clicksLeft)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotExistRecord
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case deletedAt != nil:
+		return ErrNotExistRecord
+	case isExpired(expiresAt, time.Now()):
+		return ErrExpiredRecord
+	case clicksLeft != nil:
+		return ErrClicksExhausted
+	}
+	return nil
+}
+
+// func ConsumeClick uses up one click of the limited link at memory storage, the links without limit are not changed.
+// the clicks left are decremented under the shard lock and the click record keeps the clicks left after the click,
+// the record is appended before the shard is unlocked.
+func (d *Dictionary) ConsumeClick(ctx context.Context, shortURLValue string) error {
+	now := time.Now().UTC()
+	shard := d.shards[shardIndex(shortURLValue)]
+	shard.Lock()
+	item, ok := shard.items[shortURLValue]
+	err := clickError(ok, item.deletedAt, item.expiresAt, item.clicksLeft, now)
+	if err != nil || item.clicksLeft == nil {
+		shard.Unlock()
+		return err
+	}
+	clicksLeft := *item.clicksLeft - 1
+	seq, err := d.appendFile(&ItemType{
+		Type:          ItemTypeClick,
+		Timestamp:     &now,
+		ShortURLValue: shortURLValue,
+		ClicksLeft:    &clicksLeft,
+	})
+	if err != nil {
+		shard.Unlock()
+		return err
+	}
+	item.clicksLeft = &clicksLeft
+	shard.put(shortURLValue, item)
+	shard.Unlock()
+
+	return d.commitFile(seq)
+}
+
+// func ConsumeClick uses up one click of the limited link at linked list storage, the links without limit are not changed.
+func (l UsersLinkedListMemoryStorage) ConsumeClick(ctx context.Context, shortURLValue string) error {
+	_, item := l.find(shortURLValue)
+	if item == nil {
+		return ErrNotExistRecord
+	}
+	if err := clickError(true, item.DeletedAt, item.ExpiresAt, item.ClicksLeft, time.Now()); err != nil || item.ClicksLeft == nil {
+		return err
+	}
+	clicksLeft := *item.ClicksLeft - 1
+	item.ClicksLeft = &clicksLeft
+	return nil
+}
+
+// func clickError returns the error of the click of the unavailable or used up link.
+func clickError(found bool, deletedAt *time.Time, expiresAt *time.Time, clicksLeft *int64, now time.Time) error {
+	switch {
+	case !found || deletedAt != nil:
+		return ErrNotExistRecord
+	case isExpired(expiresAt, now):
+		return ErrExpiredRecord
+	case clicksLeft != nil && *clicksLeft <= 0:
+		return ErrClicksExhausted
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"context"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Short URL value generation strategies.
+const (
+	CodeStrategyRandom   = "random"   // random values from the crypto seeded source
+	CodeStrategySequence = "sequence" // base62 encoded counter
+	CodeStrategyHash     = "hash"     // deterministic hash of the original URL value
+
+	maxGenerateAttemptsConst = 10 // attempts to generate a free short URL value
+
+	DefaultCodeLength = minShortURLLengthConst // length of the generated values if the length is not configured
+)
+
+// base62 alphabet of the generated short URL values.
+const codeAlphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// ErrUnknownCodeStrategy - short URL value generation strategy is not supported.
+var ErrUnknownCodeStrategy = errors.New("unknown short URL generation strategy")
+
+// type CodeGenerator represents short URL values generator interface.
+// attempt is the number of the previous collisions for the same original URL value.
+type CodeGenerator interface {
+	Generate(longURLValue string, attempt int) string
+}
+
+// defaultCodeGenerator is used by ShortURLGenerator func.
+var defaultCodeGenerator = NewRandomCodeGenerator(DefaultCodeLength)
+
+// func NewCodeGenerator creates a generator by the strategy name, empty strategy means random values.
+// length less than or equal to zero means the default length.
+func NewCodeGenerator(strategy string, length int) (CodeGenerator, error) {
+	if length <= 0 {
+		length = DefaultCodeLength
+	}
+	if length < minAliasLengthConst || length > maxAliasLengthConst {
+		return nil, fmt.Errorf("%w: length %d", ErrAliasInvalid, length)
+	}
+
+	switch strategy {
+	case "", CodeStrategyRandom:
+		return NewRandomCodeGenerator(length), nil
+	case CodeStrategySequence:
+		return NewSequenceCodeGenerator(length), nil
+	case CodeStrategyHash:
+		return NewHashCodeGenerator(length), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownCodeStrategy, strategy)
+}
+
+// type RandomCodeGenerator generates random values, the source is seeded once by crypto/rand.
+type RandomCodeGenerator struct {
+	mu     sync.Mutex
+	source *rand.Rand
+	length int
+}
+
+// func NewRandomCodeGenerator creates a new random values generator.
+func NewRandomCodeGenerator(length int) *RandomCodeGenerator {
+	return &RandomCodeGenerator{
+		source: rand.New(rand.NewSource(cryptoSeed())),
+		length: length,
+	}
+}
+
+// func Generate returns a random value, arguments are not used.
+func (g *RandomCodeGenerator) Generate(string, int) string {
+	b := make([]byte, g.length)
+
+	g.mu.Lock()
+	for i := range b {
+		b[i] = codeAlphabet[g.source.Intn(len(codeAlphabet))]
+	}
+	g.mu.Unlock()
+
+	return string(b)
+}
+
+// type SequenceCodeGenerator generates base62 encoded counter values.
+// the counter starts from a random offset, so restarted instances do not repeat the previous values.
+type SequenceCodeGenerator struct {
+	counter uint64
+	space   uint64 // count of values with the length, 0 if it overflows uint64
+	length  int
+}
+
+// func NewSequenceCodeGenerator creates a new counter values generator.
+func NewSequenceCodeGenerator(length int) *SequenceCodeGenerator {
+	g := &SequenceCodeGenerator{
+		length: length,
+	}
+	if space := math.Pow(float64(len(codeAlphabet)), float64(length)); space < math.MaxUint64 {
+		g.space = uint64(space)
+	}
+
+	g.counter = uint64(cryptoSeed())
+	if g.space != 0 {
+		g.counter %= g.space
+	}
+	return g
+}
+
+// func Generate returns the next counter value, arguments are not used.
+func (g *SequenceCodeGenerator) Generate(string, int) string {
+	value := atomic.AddUint64(&g.counter, 1)
+	if g.space != 0 {
+		value %= g.space
+	}
+	return encodeBase62(new(big.Int).SetUint64(value), g.length)
+}
+
+// type HashCodeGenerator generates values from the sha256 hash of the original URL value.
+// the same URL value always gives the same short URL value, the attempt number salts the hash on collision.
+type HashCodeGenerator struct {
+	length int
+}
+
+// func NewHashCodeGenerator creates a new hash values generator.
+func NewHashCodeGenerator(length int) *HashCodeGenerator {
+	return &HashCodeGenerator{
+		length: length,
+	}
+}
+
+// func Generate returns the hash value of the original URL value.
+func (g *HashCodeGenerator) Generate(longURLValue string, attempt int) string {
+	data := longURLValue
+	if attempt > 0 {
+		data += "#" + strconv.Itoa(attempt)
+	}
+	sum := sha256.Sum256([]byte(data))
+	return encodeBase62(new(big.Int).SetBytes(sum[:]), g.length)
+}
+
+// func encodeBase62 encodes the lowest digits of the value, the result is padded with zeros to the length.
+func encodeBase62(value *big.Int, length int) string {
+	b := make([]byte, length)
+	base := big.NewInt(int64(len(codeAlphabet)))
+	mod := new(big.Int)
+	for i := length - 1; i >= 0; i-- {
+		value.DivMod(value, base, mod)
+		b[i] = codeAlphabet[mod.Int64()]
+	}
+	return string(b)
+}
+
+// func cryptoSeed returns the random seed value, falls back to the zero seed if crypto/rand fails.
+func cryptoSeed() int64 {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint64(b[:]) & math.MaxInt64)
+}
+
+// func nextFreeShortURL generates a short URL value valid for the service, reserved words are skipped.
+func nextFreeShortURL(gen CodeGenerator, longURLValue string, attempt *int) string {
+	for {
+		value := gen.Generate(longURLValue, *attempt)
+		*attempt++
+		if ValidateShortURL(value) == nil || *attempt >= maxGenerateAttemptsConst {
+			return value
+		}
+	}
+}
+
+// func AddGeneratedURL adds original URL value with the generated short URL value to the storage.
+// the value is generated again if it is already taken.
+func AddGeneratedURL(ctx context.Context, repo Storage, gen CodeGenerator, longURLValue string, userID int32, opts URLOptions) (string, error) {
+	var err error
+	var result string
+	for attempt := 0; attempt < maxGenerateAttemptsConst; {
+		result, err = repo.AddURL(ctx, longURLValue, nextFreeShortURL(gen, longURLValue, &attempt), userID, opts)
+		if !errors.Is(err, ErrShortURLTaken) {
+			return result, err
+		}
+	}
+	return "", err
+}
+
+// func PostGeneratedBatch adds batch items to the storage, items without alias get generated short URL values.
+// the items with generated values which are already taken get new values and are sent again.
+func PostGeneratedBatch(ctx context.Context, repo Storage, gen CodeGenerator, items *BatchRequestArray, prefix string, userID int32) (*BatchResponseArray, error) {
+	for i := range *items {
+		item := &(*items)[i]
+		item.ShortURL = item.Alias
+		if item.ShortURL == "" {
+			attempt := 0
+			item.ShortURL = nextFreeShortURL(gen, item.OriginalURL, &attempt)
+		}
+	}
+
+	result, err := repo.PostAPIBatch(ctx, items, prefix, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; attempt < maxGenerateAttemptsConst; attempt++ {
+		var retry BatchRequestArray
+		var positions []int
+		for i, v := range *result {
+			item := &(*items)[i]
+			if v.Status != BatchStatusTaken || item.Alias != "" {
+				continue
+			}
+			itemAttempt := attempt
+			item.ShortURL = nextFreeShortURL(gen, item.OriginalURL, &itemAttempt)
+			retry = append(retry, *item)
+			positions = append(positions, i)
+		}
+		if len(retry) == 0 {
+			break
+		}
+
+		retried, err := repo.PostAPIBatch(ctx, &retry, prefix, userID)
+		if err != nil {
+			return nil, err
+		}
+		for j, i := range positions {
+			(*result)[i] = (*retried)[j]
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,216 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const snapshotSuffixConst = ".snapshot" // snapshot file name suffix, the snapshot is kept beside the log
+
+// ErrCompactionUnsupported - the storage does not keep a log to compact.
+var ErrCompactionUnsupported = errors.New("storage does not support compaction")
+
+// type Compactor is an optional storage interface, storages with an append-only log implement it.
+type Compactor interface {
+	// Compact writes the snapshot of live records and starts a new empty log,
+	// the function returns count of records in the snapshot.
+	Compact(ctx context.Context) (int, error)
+}
+
+// func SnapshotPath returns the snapshot file name of the log.
+func SnapshotPath(fileStoragePath string) string {
+	return fileStoragePath + snapshotSuffixConst
+}
+
+// func loadLog replays all records of the file, missing file is not an error.
+// with tail recovery the corrupted records at the end of the file are truncated,
+// otherwise the corrupted record returns ErrCorruptedLog.
+func (d *Dictionary) loadLog(filename string, now time.Time, tailRecovery bool) error {
+	if _, err := os.Stat(filename); err != nil {
+		return nil
+	}
+
+	consumerItem, err := NewConsumer(filename)
+	if err != nil {
+		return err
+	}
+	defer consumerItem.Close()
+
+	for {
+		item, err := consumerItem.ReadItem()
+		if err == io.EOF {
+			return nil
+		}
+		if errors.Is(err, ErrCorruptedRecord) {
+			if !tailRecovery {
+				return fmt.Errorf("%w: %s: %v", ErrCorruptedLog, filename, err)
+			}
+			return recoverTail(filename)
+		}
+		if err != nil {
+			return err
+		}
+		d.replay(item, now)
+	}
+}
+
+// func Compact writes live links to the snapshot file and replaces the log with a new empty segment.
+// the log writes are blocked while compaction runs. Both files are replaced by rename,
+// so a crash leaves either the old or the new file. Replay is idempotent: if the crash happens
+// after the snapshot rename, the old log records are applied over the snapshot with the same result.
+// the shards are locked before the log as the writers lock them, the writers append the records under the shard locks.
+func (d *Dictionary) Compact(ctx context.Context) (int, error) {
+	if d.writer == nil {
+		return 0, ErrCompactionUnsupported
+	}
+
+	unlock := d.rlockShards()
+	defer unlock()
+
+	var count int
+	err := d.writer.rotate(func() error {
+		var err error
+		count, err = d.writeSnapshot(ctx, SnapshotPath(d.fileStoragePath))
+		if err != nil {
+			return err
+		}
+		return replaceFile(d.fileStoragePath, func(io.Writer) error { return nil })
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// func writeSnapshot writes creation records of the live links to the file, the edited links are followed
+// by their edit records, the soft-deleted links are followed by their deletion records, the retired short URL values are kept by the retirement records.
+// the shards must be locked.
+func (d *Dictionary) writeSnapshot(ctx context.Context, filename string) (int, error) {
+	count := 0
+	now := time.Now()
+	err := replaceFile(filename, func(w io.Writer) error {
+		for _, shard := range d.shards {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			records := make([]ItemType, 0, len(shard.items)+len(shard.deleted)+len(shard.retired))
+			for shortURL, item := range shard.items {
+				if isExpired(item.expiresAt, now) {
+					continue
+				}
+				records = append(records, itemRecords(shortURL, item)...)
+			}
+			for shortURL := range shard.retired {
+				records = append(records, ItemType{
+					Type:          ItemTypeRetire,
+					ShortURLValue: shortURL,
+				})
+			}
+
+			for i := range records {
+				line, err := encodeRecord(&records[i])
+				if err != nil {
+					return err
+				}
+				if _, err = w.Write(line); err != nil {
+					return err
+				}
+			}
+			count += len(records)
+		}
+		return nil
+	})
+	return count, err
+}
+
+// func itemRecords returns the log records which restore the link: the creation record followed by the edit records
+// of the edited link and by the deletion record of the soft-deleted link.
+func itemRecords(shortURL string, item dictionaryItem) []ItemType {
+	record := ItemType{
+		Type:          ItemTypeCreate,
+		ShortURLValue: shortURL,
+		LongURLValue:  item.longURL,
+		UserID:        item.userID,
+		ExpiresAt:     item.expiresAt,
+		PasswordHash:  item.passwordHash,
+		ClicksLeft:    copyCount(item.clicksLeft),
+	}
+	if !item.owned {
+		record.Type = ""
+	}
+	if !item.createdAt.IsZero() {
+		createdAt, updatedAt := item.createdAt, item.updatedAt
+		record.Timestamp, record.UpdatedAt = &createdAt, &updatedAt
+	}
+	record.AccessedAt = item.accessedAt
+	if len(item.versions) > 0 {
+		record.LongURLValue = item.versions[0].OriginalURL
+	}
+
+	records := []ItemType{record}
+	for i := 1; i < len(item.versions); i++ {
+		v := item.versions[i]
+		records = append(records, ItemType{
+			Type:          ItemTypeUpdate,
+			Timestamp:     v.EditedAt,
+			ShortURLValue: shortURL,
+			LongURLValue:  v.OriginalURL,
+			UserID:        v.EditedBy,
+			Version:       v.Version,
+		})
+	}
+	if item.deletedAt != nil {
+		records = append(records, ItemType{
+			Type:          ItemTypeDelete,
+			Timestamp:     item.deletedAt,
+			ShortURLValue: shortURL,
+			UserID:        item.userID,
+		})
+	}
+	return records
+}
+
+// func replaceFile writes the content to the temporary file, syncs it and renames it over the file.
+func replaceFile(filename string, write func(w io.Writer) error) error {
+	tmp := filename + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if err = write(file); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// func startCompactor launches the worker which periodically compacts the storage log.
+// the worker stops when the done channel is closed.
+func startCompactor(c Compactor, wg *sync.WaitGroup, done chan struct{}, interval time.Duration) {
+	startPeriodic(wg, done, interval, func() {
+		cnt, err := c.Compact(context.Background())
+		if err != nil {
+			log.Printf("storage compaction: %v", err)
+			return
+		}
+		log.Printf("storage compaction: %d records in the snapshot", cnt)
+	})
+}
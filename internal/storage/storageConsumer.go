@@ -1,13 +1,19 @@
 package storage
 
 import (
-	"encoding/json"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
 	"os"
 )
 
 type consumer struct {
-	file    *os.File
-	decoder *json.Decoder
+	file     *os.File
+	reader   *bufio.Reader
+	offset   int64 // offset of the last read line
+	next     int64 // offset of the next line
+	checksum bool  // the last read record has a checksum
 }
 
 func NewConsumer(filename string) (*consumer, error) {
@@ -17,17 +23,48 @@ func NewConsumer(filename string) (*consumer, error) {
 	}
 
 	return &consumer{
-		file:    file,
-		decoder: json.NewDecoder(file),
+		file:   file,
+		reader: bufio.NewReader(file),
 	}, nil
 }
 
+// func ReadItem reads the next record of the file, empty lines are skipped.
+// the corrupted record or the last line without the new line returns ErrCorruptedRecord,
+// the reading may be continued after the error with the next line.
 func (c *consumer) ReadItem() (*ItemType, error) {
-	item := &ItemType{}
-	if err := c.decoder.Decode(&item); err != nil {
-		return nil, err
+	for {
+		line, err := c.reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if err == io.EOF && len(line) == 0 {
+			return nil, io.EOF
+		}
+
+		c.offset = c.next
+		c.next += int64(len(line))
+
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: offset %d: incomplete record", ErrCorruptedRecord, c.offset)
+		}
+
+		line = bytes.TrimRight(line, "\r\n")
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		item, checksum, err := decodeRecord(line)
+		if err != nil {
+			return nil, fmt.Errorf("%w: offset %d: %v", ErrCorruptedRecord, c.offset, err)
+		}
+		c.checksum = checksum
+		return item, nil
 	}
-	return item, nil
+}
+
+// func Offset returns the offset of the last read record, the corrupted one too.
+func (c *consumer) Offset() int64 {
+	return c.offset
 }
 
 func (c *consumer) Close() error {
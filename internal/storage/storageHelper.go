@@ -1,16 +1,59 @@
 package storage
 
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// file storage log record types, the record without type is a link created by the old versions.
+const (
+	ItemTypeCreate  = "create"  // link creation record
+	ItemTypeDelete  = "delete"  // link soft deletion record, the record timestamp is the deletion time
+	ItemTypeRestore = "restore" // soft-deleted link restoration record
+	ItemTypeUpdate  = "update"  // link destination edit record, the record user is the editing user
+	ItemTypePurge   = "purge"   // soft-deleted link hard deletion record, the short URL value may be used again
+	ItemTypeRetire  = "retire"  // soft-deleted link hard deletion record, the short URL value is never used again
+	ItemTypeAccess  = "access"  // link access record, the record timestamp is the last access time
+	ItemTypeClick   = "click"   // limited link click record, the record keeps the clicks left after the click
+)
+
+// ErrExpirationInvalid - link expiration time is in the past or TTL value is negative or too large.
+var ErrExpirationInvalid = errors.New("link expiration value is invalid")
+
 type (
-	// short URL value and original URL value pairs
+	// optional link settings set on link creation.
+	URLOptions struct {
+		ExpiresAt *time.Time `json:"expires_at,omitempty"` // link expiration time
+		TTL       int64      `json:"ttl,omitempty"`        // link time to live in seconds, used if ExpiresAt is not set
+		Password  string     `json:"password,omitempty"`   // the link resolves after the password is entered, only its salted hash is stored
+		MaxClicks int64      `json:"max_clicks,omitempty"` // the link resolves the given count of times, zero means no limit
+	}
+
+	// file storage log record, short URL value and original URL value pairs with the owner
 	ItemType struct {
-		ShortURLValue string `json:"shortURLValue"`
-		LongURLValue  string `json:"longURLValue"`
+		ExpiresAt     *time.Time `json:"expiresAt,omitempty"`
+		Timestamp     *time.Time `json:"timestamp,omitempty"`
+		Type          string     `json:"type,omitempty"`
+		ShortURLValue string     `json:"shortURLValue"`
+		LongURLValue  string     `json:"longURLValue,omitempty"`
+		UserID        int32      `json:"userID,omitempty"`
+		Version       int        `json:"version,omitempty"`      // link version set by the edit record
+		UpdatedAt     *time.Time `json:"updatedAt,omitempty"`    // link modification time kept by the snapshot creation record
+		AccessedAt    *time.Time `json:"accessedAt,omitempty"`   // link last access time kept by the snapshot creation record
+		PasswordHash  string     `json:"passwordHash,omitempty"` // salted password hash kept by the creation record of the protected link
+		ClicksLeft    *int64     `json:"clicksLeft,omitempty"`   // clicks left of the limited link kept by the creation and click records
 	}
 
 	// short URL value and original URL value pairs
+	// with the link creation, modification and last access times, the times unknown to the storage are omitted
 	UserExportType struct {
-		ShortURL    string `json:"short_url"`
-		OriginalURL string `json:"original_url"`
+		CreatedAt      *time.Time `json:"created_at,omitempty"`
+		UpdatedAt      *time.Time `json:"updated_at,omitempty"`
+		LastAccessedAt *time.Time `json:"last_accessed_at,omitempty"`
+		ClicksLeft     *int64     `json:"clicks_left,omitempty"` // clicks left of the limited link, nil if the link is not limited
+		ShortURL       string     `json:"short_url"`
+		OriginalURL    string     `json:"original_url"`
 	}
 
 	// array of BatchRequest.
@@ -20,21 +63,67 @@ type (
 	BatchRequest struct {
 		CorrelationID string `json:"correlation_id"`
 		OriginalURL   string `json:"original_url"`
+		Alias         string `json:"alias,omitempty"`
 		ShortURL      string `json:"-"`
+		URLOptions
 	}
 
 	// array of BatchResponse
 	BatchResponseArray []BatchResponse
 
-	// Batch response struct to marshal json and response.
+	// Batch response struct to marshal json and response, every item has its own status.
 	BatchResponse struct {
 		CorrelationID string `json:"correlation_id"`
 		ShortURL      string `json:"short_url"`
+		Status        string `json:"status"`
+		Error         string `json:"error,omitempty"`
 	}
 
 	// InternalStats struct to marshal json and response.
 	InternalStats struct {
-		URLs  int `json:"urls"`
-		Users int `json:"users"`
+		Cache *CacheStats `json:"cache,omitempty"`
+		Bloom *BloomStats `json:"bloom,omitempty"`
+		URLs  int         `json:"urls"`
+		Users int         `json:"users"`
+	}
+
+	// BloomStats struct is the Bloom filter counters, it is set if the filter is enabled.
+	BloomStats struct {
+		Skipped  uint64 `json:"skipped"`  // count of the lookups answered without the storage call
+		Capacity int    `json:"capacity"` // count of the values the filter keeps with the configured false positive rate
+	}
+
+	// CacheStats struct is the links cache counters, it is set if the cache is enabled.
+	CacheStats struct {
+		Hits   uint64 `json:"hits"`
+		Misses uint64 `json:"misses"`
+		Size   int    `json:"size"`
 	}
 )
+
+// func Expiration returns the link expiration time calculated from the ExpiresAt and TTL values,
+// nil value means that the link never expires.
+func (o URLOptions) Expiration(now time.Time) (*time.Time, error) {
+	if o.ExpiresAt != nil {
+		if !o.ExpiresAt.After(now) {
+			return nil, ErrExpirationInvalid
+		}
+		expiresAt := o.ExpiresAt.UTC()
+		return &expiresAt, nil
+	}
+
+	// the TTL which does not fit the duration would wrap to an arbitrary time
+	if o.TTL < 0 || o.TTL > math.MaxInt64/int64(time.Second) {
+		return nil, ErrExpirationInvalid
+	}
+	if o.TTL == 0 {
+		return nil, nil
+	}
+	expiresAt := now.Add(time.Duration(o.TTL) * time.Second).UTC()
+	return &expiresAt, nil
+}
+
+// func isExpired checks that the link expiration time has come.
+func isExpired(expiresAt *time.Time, now time.Time) bool {
+	return expiresAt != nil && !expiresAt.After(now)
+}
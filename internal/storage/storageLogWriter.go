@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// file storage fsync policies.
+const (
+	SyncPolicyAlways   = "always"   // every write is flushed and synced before the call returns
+	SyncPolicyInterval = "interval" // writes are buffered, flushed and synced periodically
+	SyncPolicyNever    = "never"    // writes are buffered, the file is synced only on rotation and close
+
+	logWriterBufferSizeConst = 64 * 1024 // file storage write buffer size
+)
+
+// file storage writer errors.
+var (
+	ErrWriterClosed      = errors.New("file storage writer is closed")
+	ErrUnknownSyncPolicy = errors.New("unknown file storage sync policy")
+)
+
+// type logWriter is a long-lived buffered writer of the file storage log.
+// concurrent writers with the always policy share one fsync call (group commit).
+type logWriter struct {
+	file     *os.File
+	buf      *bufio.Writer
+	done     chan struct{}
+	filename string
+	policy   string
+	wg       sync.WaitGroup
+	mu       sync.Mutex // guards the file and the buffer
+	syncMu   sync.Mutex // only one writer syncs the file at a time
+	written  uint64     // sequence number of the last buffered record
+	synced   uint64     // sequence number of the last synced record, guarded by syncMu
+	closed   bool
+}
+
+// func newLogWriter opens the file storage log for appending.
+// interval is used with the interval policy only.
+func newLogWriter(filename string, policy string, interval time.Duration) (*logWriter, error) {
+	switch policy {
+	case "":
+		policy = SyncPolicyInterval
+	case SyncPolicyAlways, SyncPolicyInterval, SyncPolicyNever:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSyncPolicy, policy)
+	}
+	if policy == SyncPolicyInterval && interval <= 0 {
+		interval = 100 * time.Millisecond
+	}
+
+	w := &logWriter{
+		filename: filename,
+		policy:   policy,
+		done:     make(chan struct{}),
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+
+	if policy == SyncPolicyInterval {
+		w.wg.Add(1)
+		go w.syncWorker(interval)
+	}
+	return w, nil
+}
+
+// func open opens the file and resets the buffer, mu must be held or the writer not shared yet.
+func (w *logWriter) open() error {
+	file, err := os.OpenFile(w.filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.buf = bufio.NewWriterSize(file, logWriterBufferSizeConst)
+	return nil
+}
+
+// func Write appends the records to the log and commits them.
+func (w *logWriter) Write(items ...*ItemType) error {
+	seq, err := w.Append(items...)
+	if err != nil {
+		return err
+	}
+	return w.Commit(seq)
+}
+
+// func Append buffers the records, the function returns the sequence number to commit them with.
+// Append does not wait for the disk, so it may be called while the locks of the changed links are held.
+// the records are encoded before they are buffered, so none of them is written if one can not be encoded.
+// the records are not atomic: a crash may keep the first records of the call, every record is applied on its own.
+func (w *logWriter) Append(items ...*ItemType) (uint64, error) {
+	var lines []byte
+	for _, item := range items {
+		line, err := encodeRecord(item)
+		if err != nil {
+			return 0, err
+		}
+		lines = append(lines, line...)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, ErrWriterClosed
+	}
+	if _, err := w.buf.Write(lines); err != nil {
+		return 0, err
+	}
+	w.written++
+	return w.written, nil
+}
+
+// func Commit syncs the records of the sequence number with the always policy, other policies sync them later.
+func (w *logWriter) Commit(seq uint64) error {
+	if w.policy != SyncPolicyAlways {
+		return nil
+	}
+	return w.sync(seq)
+}
+
+// func sync flushes and syncs the file if the record with the sequence number is not synced yet.
+// the writer which takes syncMu first syncs the records of all writers waiting behind it.
+func (w *logWriter) sync(seq uint64) error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+
+	if w.synced >= seq {
+		return nil
+	}
+
+	w.mu.Lock()
+	last := w.written
+	err := w.buf.Flush()
+	file := w.file
+	w.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err = file.Sync(); err != nil {
+		return err
+	}
+	w.synced = last
+	return nil
+}
+
+// func syncWorker flushes and syncs the buffered records every interval.
+func (w *logWriter) syncWorker(interval time.Duration) {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			seq := w.written
+			closed := w.closed
+			w.mu.Unlock()
+			if closed {
+				return
+			}
+			if err := w.sync(seq); err != nil {
+				log.Printf("file storage sync: %v", err)
+			}
+		}
+	}
+}
+
+// func rotate flushes and closes the file, runs fn and opens the file again.
+// writes are blocked while fn runs, so fn may replace the file.
+func (w *logWriter) rotate(fn func() error) error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrWriterClosed
+	}
+
+	if err := w.flushAndClose(); err != nil {
+		return err
+	}
+	w.synced = w.written
+
+	err := fn()
+	if openErr := w.open(); openErr != nil {
+		w.closed = true
+		return openErr
+	}
+	return err
+}
+
+// func flushAndClose writes the buffer, syncs and closes the file, mu must be held.
+func (w *logWriter) flushAndClose() error {
+	if err := w.buf.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// func Close flushes the buffered records, syncs and closes the file.
+func (w *logWriter) Close() error {
+	w.syncMu.Lock()
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		w.syncMu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.done)
+	err := w.flushAndClose()
+	w.mu.Unlock()
+	w.syncMu.Unlock()
+
+	w.wg.Wait()
+	return err
+}
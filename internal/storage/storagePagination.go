@@ -0,0 +1,323 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// link listing sort orders.
+const (
+	ListSortCreated  = "created_at" // the links are sorted by creation time, the short URL value breaks ties
+	ListSortShortURL = "short_url"  // the links are sorted by short URL value
+
+	// postgres expression of the original URL host, the host of the value without scheme is NULL
+	pgHostExpression = "lower(substring(original_url from '^[a-zA-Z][a-zA-Z0-9+.-]*://(?:[^/?#@]*@)?([^/?#:]+)'))"
+)
+
+// Link listing errors.
+var (
+	ErrListSortInvalid = errors.New("unknown listing sort order") // the sort order is not supported
+	ErrCursorInvalid   = errors.New("listing cursor is invalid")  // the cursor is damaged or belongs to another sort order
+)
+
+type (
+	// type ListOptions represents the user links listing settings.
+	// the zero Limit returns all links after the cursor, Domain matches the host of the original URL and its subdomains,
+	// Contains matches a case-insensitive substring of the original URL.
+	ListOptions struct {
+		Cursor   string
+		Sort     string
+		Domain   string
+		Contains string
+		Limit    int
+		Desc     bool
+	}
+
+	// type UserURLPage represents a page of the user links, NextCursor is empty on the last page.
+	UserURLPage struct {
+		Items      []UserExportType `json:"items"`
+		NextCursor string           `json:"next_cursor,omitempty"`
+	}
+
+	// type listCursor is the position after the last link of the page, it is bound to the sort order.
+	listCursor struct {
+		CreatedAt time.Time `json:"c"`
+		ShortURL  string    `json:"s"`
+		Sort      string    `json:"o"`
+		Desc      bool      `json:"d,omitempty"`
+	}
+
+	// type listEntry is a link of the listing.
+	listEntry struct {
+		createdAt  time.Time
+		updatedAt  time.Time
+		accessedAt *time.Time
+		clicksLeft *int64
+		shortURL   string
+		longURL    string
+	}
+)
+
+// func normalize checks the listing settings and decodes the cursor, the default sort order is the creation time.
+func (o *ListOptions) normalize() (*listCursor, error) {
+	if o.Sort == "" {
+		o.Sort = ListSortCreated
+	}
+	if o.Sort != ListSortCreated && o.Sort != ListSortShortURL {
+		return nil, ErrListSortInvalid
+	}
+	if o.Limit < 0 {
+		return nil, fmt.Errorf("listing limit %d is negative", o.Limit)
+	}
+	o.Domain = strings.Trim(strings.ToLower(strings.TrimSpace(o.Domain)), ".")
+	o.Contains = strings.ToLower(o.Contains)
+
+	if o.Cursor == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(o.Cursor)
+	if err != nil {
+		return nil, ErrCursorInvalid
+	}
+	cursor := &listCursor{}
+	if err = json.Unmarshal(raw, cursor); err != nil || cursor.Sort != o.Sort || cursor.Desc != o.Desc {
+		return nil, ErrCursorInvalid
+	}
+	return cursor, nil
+}
+
+// func encode returns the opaque cursor value.
+func (c listCursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// func matches checks the original URL value against the listing filters.
+func (o *ListOptions) matches(longURLValue string) bool {
+	if o.Contains != "" && !strings.Contains(strings.ToLower(longURLValue), o.Contains) {
+		return false
+	}
+	if o.Domain == "" {
+		return true
+	}
+	u, err := url.Parse(longURLValue)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	return host == o.Domain || strings.HasSuffix(host, "."+o.Domain)
+}
+
+// func compare compares the links in the listing sort order, the result is negative if the first link goes first.
+func (o *ListOptions) compare(a listEntry, b listEntry) int {
+	result := 0
+	if o.Sort == ListSortCreated {
+		switch {
+		case a.createdAt.Before(b.createdAt):
+			result = -1
+		case a.createdAt.After(b.createdAt):
+			result = 1
+		}
+	}
+	if result == 0 {
+		result = strings.Compare(a.shortURL, b.shortURL)
+	}
+	if o.Desc {
+		return -result
+	}
+	return result
+}
+
+// func newPage builds the page of the sorted links, the cursor is set if one more link follows the page.
+func (o *ListOptions) newPage(entries []listEntry, prefix string, more bool) UserURLPage {
+	page := UserURLPage{Items: make([]UserExportType, 0, len(entries))}
+	for _, v := range entries {
+		page.Items = append(page.Items, v.export(prefix))
+	}
+	if more && len(entries) > 0 {
+		last := entries[len(entries)-1]
+		page.NextCursor = listCursor{
+			CreatedAt: last.createdAt,
+			ShortURL:  last.shortURL,
+			Sort:      o.Sort,
+			Desc:      o.Desc,
+		}.encode()
+	}
+	return page
+}
+
+// func listPage returns the page of the memory storage links, the entries are filtered, sorted and cut after the cursor.
+func listPage(entries []listEntry, prefix string, opts ListOptions) (UserURLPage, error) {
+	cursor, err := opts.normalize()
+	if err != nil {
+		return UserURLPage{}, err
+	}
+
+	filtered := entries[:0]
+	for _, v := range entries {
+		if !opts.matches(v.longURL) {
+			continue
+		}
+		if cursor != nil && opts.compare(listEntry{createdAt: cursor.CreatedAt, shortURL: cursor.ShortURL}, v) >= 0 {
+			continue
+		}
+		filtered = append(filtered, v)
+	}
+	sort.Slice(filtered, func(i, j int) bool {
+		return opts.compare(filtered[i], filtered[j]) < 0
+	})
+
+	more := opts.Limit > 0 && len(filtered) > opts.Limit
+	if more {
+		filtered = filtered[:opts.Limit]
+	}
+	return opts.newPage(filtered, prefix, more), nil
+}
+
+// func export returns the link with the prefixed short URL value.
+func (v listEntry) export(prefix string) UserExportType {
+	return UserExportType{
+		ShortURL:       exportShortURL(prefix, v.shortURL),
+		OriginalURL:    v.longURL,
+		CreatedAt:      timeOrNil(v.createdAt),
+		UpdatedAt:      timeOrNil(v.updatedAt),
+		LastAccessedAt: v.accessedAt,
+		ClicksLeft:     v.clicksLeft,
+	}
+}
+
+// func exportShortURL returns the short URL value with the prefix.
+func exportShortURL(prefix string, shortURLValue string) string {
+	if strings.TrimSpace(prefix) == "" {
+		return shortURLValue
+	}
+	return prefix + "/" + shortURLValue
+}
+
+// func ListUserURL returns the page of the live user links from the postgres database.
+// keyset pagination is used, the page query does not depend on the count of the skipped links.
+func (ps *PostgresStorage) ListUserURL(ctx context.Context, prefix string, userID int32, opts ListOptions) (UserURLPage, error) {
+	cursor, err := opts.normalize()
+	if err != nil {
+		return UserURLPage{}, err
+	}
+
+	query := strings.Builder{}
+	query.WriteString("SELECT short_url, original_url, created_at, updated_at, last_accessed_at, clicks_left FROM shortener " +
+		"WHERE user_id = $1 AND deleted_at IS NULL AND (expires_at IS NULL OR expires_at > now())")
+	args := []interface{}{userID}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if opts.Contains != "" {
+		query.WriteString(" AND strpos(lower(original_url), " + arg(opts.Contains) + ") > 0")
+	}
+	if opts.Domain != "" {
+		domain := arg(opts.Domain)
+		query.WriteString(" AND (" + pgHostExpression + " = " + domain +
+			" OR right(" + pgHostExpression + ", length(" + domain + ") + 1) = ('.' || " + domain + "))")
+	}
+
+	operator, direction := ">", ""
+	if opts.Desc {
+		operator, direction = "<", " DESC"
+	}
+	if cursor != nil {
+		if opts.Sort == ListSortCreated {
+			query.WriteString(" AND (created_at, short_url COLLATE \"C\") " + operator + " (" + arg(cursor.CreatedAt) + ", " + arg(cursor.ShortURL) + ")")
+		} else {
+			query.WriteString(" AND short_url COLLATE \"C\" " + operator + " " + arg(cursor.ShortURL))
+		}
+	}
+
+	if opts.Sort == ListSortCreated {
+		query.WriteString(" ORDER BY created_at" + direction + ", short_url COLLATE \"C\"" + direction)
+	} else {
+		query.WriteString(" ORDER BY short_url COLLATE \"C\"" + direction)
+	}
+	if opts.Limit > 0 {
+		query.WriteString(" LIMIT " + arg(opts.Limit+1))
+	}
+	query.WriteString(" ;")
+
+	rows, err := ps.db.QueryContext(ctx, query.String(), args...)
+	if err != nil {
+		return UserURLPage{}, err
+	}
+	defer rows.Close()
+
+	entries := []listEntry{}
+	for rows.Next() {
+		v := listEntry{}
+		if err = rows.Scan(&v.shortURL, &v.longURL, &v.createdAt, &v.updatedAt, &v.accessedAt, &v.clicksLeft); err != nil {
+			return UserURLPage{}, err
+		}
+		entries = append(entries, v)
+	}
+	if err = rows.Err(); err != nil {
+		return UserURLPage{}, err
+	}
+
+	more := opts.Limit > 0 && len(entries) > opts.Limit
+	if more {
+		entries = entries[:opts.Limit]
+	}
+	return opts.newPage(entries, prefix, more), nil
+}
+
+// func ListUserURL returns the page of the live user links from memory storage.
+func (d *Dictionary) ListUserURL(ctx context.Context, prefix string, userID int32, opts ListOptions) (UserURLPage, error) {
+	now := time.Now()
+	values := d.users[userShardIndex(userID)].list(userID)
+	entries := make([]listEntry, 0, len(values))
+	for _, v := range values {
+		shard := d.shards[shardIndex(v)]
+		shard.RLock()
+		item, ok := shard.items[v]
+		shard.RUnlock()
+
+		if !ok || item.deletedAt != nil || isExpired(item.expiresAt, now) {
+			continue
+		}
+		entries = append(entries, listEntry{
+			createdAt:  item.createdAt,
+			updatedAt:  item.updatedAt,
+			accessedAt: item.accessedAt,
+			clicksLeft: copyCount(item.clicksLeft),
+			shortURL:   v,
+			longURL:    item.longURL,
+		})
+	}
+	return listPage(entries, prefix, opts)
+}
+
+// func ListUserURL returns the page of the live user links from linked list storage.
+func (l UsersLinkedListMemoryStorage) ListUserURL(ctx context.Context, prefix string, userID int32, opts ListOptions) (UserURLPage, error) {
+	now := time.Now()
+	entries := []listEntry{}
+	if list := l.LinkedListStorage[userID]; list != nil {
+		for currentItem := list.Head; currentItem != nil; currentItem = currentItem.Next {
+			if currentItem.DeletedAt != nil || isExpired(currentItem.ExpiresAt, now) {
+				continue
+			}
+			entries = append(entries, listEntry{
+				createdAt:  currentItem.CreatedAt,
+				updatedAt:  currentItem.UpdatedAt,
+				accessedAt: currentItem.LastAccessedAt,
+				clicksLeft: copyCount(currentItem.ClicksLeft),
+				shortURL:   currentItem.ShortURLValue,
+				longURL:    currentItem.OriginalURLValue,
+			})
+		}
+	}
+	return listPage(entries, prefix, opts)
+}
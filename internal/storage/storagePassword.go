@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const maxPasswordLengthConst = 72 // bcrypt uses the first 72 bytes of the password only
+
+// link password errors.
+var (
+	ErrPasswordRequired = errors.New("link is protected by password") // the link resolves after the password is checked
+	ErrPasswordMismatch = errors.New("link password is wrong")
+	ErrPasswordInvalid  = errors.New("link password is invalid") // the password is longer than 72 bytes
+)
+
+// func PasswordHash returns the salted hash of the link password, empty value means that the link is not protected.
+func (o URLOptions) PasswordHash() (string, error) {
+	if o.Password == "" {
+		return "", nil
+	}
+	if len(o.Password) > maxPasswordLengthConst {
+		return "", ErrPasswordInvalid
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(o.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// func checkPassword compares the password with the link password hash.
+func checkPassword(passwordHash string, password string) error {
+	if passwordHash == "" {
+		return nil
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)); err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrPasswordMismatch
+		}
+		return err
+	}
+	return nil
+}
+
+// func nullString returns nil for the empty value, the postgres database keeps NULL password hash of the links without password.
+func nullString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// func CheckURLPassword checks the password of the link at the postgres database, the function returns the original URL value.
+// the links without password are resolved as by GetURL, ErrClicksLimited is returned for the limited link with the right password.
+func (ps *PostgresStorage) CheckURLPassword(ctx context.Context, shortURLValue string, password string) (string, error) {
+	var longURL string
+	var deletedAt *time.Time
+	var expiresAt *time.Time
+	var passwordHash sql.NullString
+	var clicksLeft *int64
+
+	err := ps.db.QueryRowContext(ctx,
+		"SELECT original_url, deleted_at, expires_at, password_hash, clicks_left "+
+			"FROM shortener "+
+			"WHERE short_url = $1 ;",
+		shortURLValue).Scan(&longURL, &deletedAt, &expiresAt, &passwordHash, &clicksLeft)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if deletedAt != nil {
+		return longURL, ErrNotExistRecord
+	}
+	if isExpired(expiresAt, time.Now()) {
+		return longURL, ErrExpiredRecord
+	}
+	if clicksLeft != nil && *clicksLeft <= 0 {
+		return longURL, ErrClicksExhausted
+	}
+	if err = checkPassword(passwordHash.String, password); err != nil {
+		return "", err
+	}
+	return limitedURL(longURL, clicksLeft)
+}
+
+// func CheckURLPassword checks the password of the link at memory storage, the function returns the original URL value.
+// the links without password are resolved as by GetURL, ErrClicksLimited is returned for the limited link with the right password.
+func (d *Dictionary) CheckURLPassword(ctx context.Context, shortURLValue string, password string) (string, error) {
+	longURL, err := d.GetURL(ctx, shortURLValue)
+	if !errors.Is(err, ErrPasswordRequired) {
+		return longURL, err
+	}
+
+	shard := d.shards[shardIndex(shortURLValue)]
+	shard.RLock()
+	item := shard.items[shortURLValue]
+	shard.RUnlock()
+
+	if err = checkPassword(item.passwordHash, password); err != nil {
+		return "", err
+	}
+	return limitedURL(longURL, item.clicksLeft)
+}
+
+// func CheckURLPassword checks the password of the link at linked list storage, the function returns the original URL value.
+// the links without password are resolved as by GetURL, ErrClicksLimited is returned for the limited link with the right password.
+func (l UsersLinkedListMemoryStorage) CheckURLPassword(ctx context.Context, shortURLValue string, password string) (string, error) {
+	_, item := l.find(shortURLValue)
+	if item == nil {
+		return "", nil
+	}
+
+	longURL, err := item.linkedURLValue()
+	if !errors.Is(err, ErrPasswordRequired) {
+		return longURL, err
+	}
+	if err = checkPassword(item.PasswordHash, password); err != nil {
+		return "", err
+	}
+	return limitedURL(longURL, item.ClicksLeft)
+}
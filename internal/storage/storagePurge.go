@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const defaultPurgeBatchSizeConst = 1000 // count of the links deleted by one purge statement if the batch size is not set
+
+// type Purger is an optional storage interface, storages which keep the soft-deleted links implement it.
+type Purger interface {
+	// PurgeDeleted hard-deletes the links soft-deleted before the retention period, the function returns count of purged links.
+	PurgeDeleted(ctx context.Context) (int, error)
+}
+
+// type RemovalNotifier is an optional storage interface, storages which remove the links by the background workers implement it.
+type RemovalNotifier interface {
+	// OnRemoved registers the function called after the expired links are reaped or the soft-deleted links are purged.
+	OnRemoved(fn func())
+}
+
+// type removalListeners keeps the functions called after the links removal, the decorators drop the results they keep.
+type removalListeners struct {
+	listeners []func()
+	mu        sync.Mutex
+}
+
+// func OnRemoved registers the function called after the links removal.
+func (r *removalListeners) OnRemoved(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.listeners = append(r.listeners, fn)
+}
+
+// func notifyRemoved calls the registered functions.
+func (r *removalListeners) notifyRemoved() {
+	r.mu.Lock()
+	listeners := r.listeners
+	r.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn()
+	}
+}
+
+// type PurgePolicy is the hard deletion policy of the soft-deleted links.
+type PurgePolicy struct {
+	Retention time.Duration // soft-deleted links are kept for the retention period, zero or negative value disables the purge
+	Interval  time.Duration // the purge job period
+	BatchSize int           // count of the links deleted by one statement, a batch locks its rows only
+	ReuseURLs bool          // the purged short URL values may be used by new links, otherwise they are retired forever
+}
+
+// func PurgeDeleted hard-deletes the links of the postgres database soft-deleted before the retention period,
+// the function returns count of purged links. The links are deleted in batches, the rows locked by concurrent transactions are skipped until the next run.
+// the purged short URL values are retired unless the policy allows their reuse, then the clicks of the values are deleted too,
+// so a new link does not inherit the statistics.
+func (ps *PostgresStorage) PurgeDeleted(ctx context.Context) (int, error) {
+	if ps.purge.Retention <= 0 {
+		return 0, nil
+	}
+	batchSize := ps.purge.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultPurgeBatchSizeConst
+	}
+
+	// the purged values are either retired or their clicks are deleted by the last statement of the query.
+	policy := "retired AS (INSERT INTO retired_short_urls (short_url) SELECT short_url FROM purged ON CONFLICT DO NOTHING) "
+	if ps.purge.ReuseURLs {
+		policy = "clicks_purged AS (DELETE FROM clicks WHERE short_url IN (SELECT short_url FROM purged)) "
+	}
+	query := "WITH purged AS (" +
+		"DELETE FROM shortener WHERE ctid = ANY(ARRAY(" +
+		"SELECT ctid FROM shortener " +
+		"WHERE deleted_at IS NOT NULL AND deleted_at < now() - make_interval(secs => $1) " +
+		"LIMIT $2 FOR UPDATE SKIP LOCKED)) " +
+		"RETURNING short_url), " +
+		"versions_purged AS (DELETE FROM url_versions WHERE short_url IN (SELECT short_url FROM purged)), " +
+		policy +
+		"SELECT COUNT(*) FROM purged;"
+
+	total := 0
+	defer func() {
+		if total > 0 {
+			ps.notifyRemoved()
+		}
+	}()
+	for {
+		var cnt int
+		err := ps.db.QueryRowContext(ctx, query, ps.purge.Retention.Seconds(), batchSize).Scan(&cnt)
+		if err != nil {
+			return total, err
+		}
+		total += cnt
+		if cnt < batchSize {
+			return total, nil
+		}
+	}
+}
+
+// func restorable checks that the soft-deleted link may be restored: it is deleted within the retention period and is not expired.
+func (p PurgePolicy) restorable(deletedAt *time.Time, expiresAt *time.Time, now time.Time) bool {
+	if deletedAt == nil || isExpired(expiresAt, now) {
+		return false
+	}
+	return p.Retention <= 0 || deletedAt.After(now.Add(-p.Retention))
+}
+
+// func PurgeDeleted hard-deletes the links of memory storage soft-deleted before the retention period,
+// the function returns count of purged links. The purged short URL values are retired unless the policy allows their reuse.
+// the values stay retired until the purge records are written, so a new link record never precedes the purge record of its value.
+func (d *Dictionary) PurgeDeleted(ctx context.Context) (int, error) {
+	if d.purge.Retention <= 0 {
+		return 0, nil
+	}
+	recordType := ItemTypeRetire
+	if d.purge.ReuseURLs {
+		recordType = ItemTypePurge
+	}
+
+	now := time.Now().UTC()
+	cutoff := now.Add(-d.purge.Retention)
+	count := 0
+	defer func() {
+		if count > 0 {
+			d.notifyRemoved()
+		}
+	}()
+	for _, shard := range d.shards {
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+
+		var records []*ItemType
+		shard.Lock()
+		for shortURL, deletedAt := range shard.deleted {
+			if !deletedAt.Before(cutoff) {
+				continue
+			}
+			shard.remove(shortURL)
+			shard.retired[shortURL] = struct{}{}
+			records = append(records, &ItemType{
+				Type:          recordType,
+				Timestamp:     &now,
+				ShortURLValue: shortURL,
+			})
+		}
+		shard.Unlock()
+		if len(records) == 0 {
+			continue
+		}
+
+		if err := d.writeFile(records...); err != nil {
+			return count, err
+		}
+		if d.purge.ReuseURLs {
+			shard.Lock()
+			for _, v := range records {
+				delete(shard.retired, v.ShortURLValue)
+			}
+			shard.Unlock()
+		}
+		count += len(records)
+	}
+	return count, nil
+}
+
+// func startPurger launches the worker which periodically hard-deletes the soft-deleted links of the storage.
+// the worker stops when the done channel is closed.
+func startPurger(p Purger, wg *sync.WaitGroup, done chan struct{}, interval time.Duration) {
+	startPeriodic(wg, done, interval, func() {
+		cnt, err := p.PurgeDeleted(context.Background())
+		if err != nil {
+			log.Printf("deleted links purge: %v", err)
+			return
+		}
+		if cnt > 0 {
+			log.Printf("deleted links purge: %d links removed", cnt)
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+)
+
+const (
+	recordChecksumSeparatorConst = '\t' // separates the record JSON value and its checksum, JSON escapes tabs in strings
+	recordChecksumLengthConst    = 8    // length of the hex encoded CRC-32 checksum
+)
+
+// file storage record errors.
+var (
+	ErrCorruptedRecord = errors.New("file storage record is corrupted")
+	ErrCorruptedLog    = errors.New("file storage is corrupted")
+)
+
+var recordChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// func encodeRecord returns the log line of the record:
+// the JSON value, the tab, hex encoded CRC-32C checksum of the JSON value and the new line.
+func encodeRecord(item *ItemType) ([]byte, error) {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+
+	line := make([]byte, 0, len(data)+recordChecksumLengthConst+2)
+	line = append(line, data...)
+	line = append(line, recordChecksumSeparatorConst)
+	line = appendChecksum(line, data)
+	return append(line, '\n'), nil
+}
+
+// func decodeRecord parses the log line without the new line.
+// lines without checksum are written by the old versions, they are accepted if the JSON value is valid.
+func decodeRecord(line []byte) (*ItemType, bool, error) {
+	data := line
+	checksummed := false
+	if i := bytes.LastIndexByte(line, recordChecksumSeparatorConst); i >= 0 {
+		data = line[:i]
+		checksum := line[i+1:]
+		if !bytes.Equal(checksum, appendChecksum(nil, data)) {
+			return nil, false, errors.New("checksum mismatch")
+		}
+		checksummed = true
+	}
+
+	item := &ItemType{}
+	if err := json.Unmarshal(data, item); err != nil {
+		return nil, false, err
+	}
+	return item, checksummed, nil
+}
+
+// func appendChecksum appends hex encoded checksum of the data to the buffer.
+func appendChecksum(buf []byte, data []byte) []byte {
+	sum := crc32.Checksum(data, recordChecksumTable)
+	var raw [4]byte
+	binary.BigEndian.PutUint32(raw[:], sum)
+	encoded := make([]byte, recordChecksumLengthConst)
+	hex.Encode(encoded, raw[:])
+	return append(buf, encoded...)
+}
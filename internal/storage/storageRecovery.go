@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// type LogReport is the result of the file storage file check.
+type LogReport struct {
+	Records   int   // count of the valid records
+	Legacy    int   // count of the valid records without checksum
+	Corrupted int   // count of the corrupted records
+	Size      int64 // file size
+	ValidSize int64 // size of the file part before the first corrupted record
+	TailOnly  bool  // all corrupted records are at the end of the file, truncation at ValidSize repairs the file
+}
+
+// func CheckLog reads all records of the file storage file and counts the valid and the corrupted records.
+func CheckLog(filename string) (LogReport, error) {
+	return scanLog(filename, nil)
+}
+
+// func RepairLog removes the corrupted records of the file storage file, the function returns the report
+// of the file before the repair. The corrupted tail is truncated, otherwise the file is rewritten
+// with the valid records only. The file must not be written while the function runs.
+func RepairLog(filename string) (LogReport, error) {
+	report, err := CheckLog(filename)
+	if err != nil || report.Corrupted == 0 {
+		return report, err
+	}
+
+	if report.TailOnly {
+		return report, os.Truncate(filename, report.ValidSize)
+	}
+
+	err = replaceFile(filename, func(w io.Writer) error {
+		_, err := scanLog(filename, func(item *ItemType) error {
+			line, err := encodeRecord(item)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(line)
+			return err
+		})
+		return err
+	})
+	return report, err
+}
+
+// func scanLog reads all records of the file, fills the report and calls fn for every valid record.
+func scanLog(filename string, fn func(item *ItemType) error) (LogReport, error) {
+	report := LogReport{}
+	stat, err := os.Stat(filename)
+	if err != nil {
+		return report, err
+	}
+	report.Size = stat.Size()
+	report.ValidSize = report.Size
+	report.TailOnly = true
+
+	consumerItem, err := NewConsumer(filename)
+	if err != nil {
+		return report, err
+	}
+	defer consumerItem.Close()
+
+	for {
+		item, err := consumerItem.ReadItem()
+		if err == io.EOF {
+			return report, nil
+		}
+		if errors.Is(err, ErrCorruptedRecord) {
+			if report.Corrupted == 0 {
+				report.ValidSize = consumerItem.Offset()
+			}
+			report.Corrupted++
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+
+		report.Records++
+		if !consumerItem.checksum {
+			report.Legacy++
+		}
+		if report.Corrupted > 0 {
+			report.TailOnly = false
+		}
+		if fn != nil {
+			if err = fn(item); err != nil {
+				return report, err
+			}
+		}
+	}
+}
+
+// func recoverTail truncates the corrupted tail of the file storage file, it is left by the crash in the middle of a write.
+// corrupted records followed by valid ones are not truncated, such file must be repaired by the fsck command.
+func recoverTail(filename string) error {
+	report, err := CheckLog(filename)
+	if err != nil {
+		return err
+	}
+	if !report.TailOnly {
+		return fmt.Errorf("%w: %s: %d corrupted records, run fsck to repair", ErrCorruptedLog, filename, report.Corrupted)
+	}
+
+	if err = os.Truncate(filename, report.ValidSize); err != nil {
+		return err
+	}
+	log.Printf("file storage %s: dropped corrupted tail of %d bytes, %d records",
+		filename, report.Size-report.ValidSize, report.Corrupted)
+	return nil
+}
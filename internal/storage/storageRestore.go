@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgtype"
+)
+
+// func RestoreUserURL clears the soft deletion of the user links at the postgres database,
+// only the links deleted within the retention period and not expired are restored.
+// the function returns the restored short URL values.
+func (ps *PostgresStorage) RestoreUserURL(ctx context.Context, restoredURLs *DeletedShortURLValues) ([]string, error) {
+	idsArray := &pgtype.TextArray{}
+	if err := idsArray.Set(restoredURLs.ShortURLValues); err != nil {
+		return nil, err
+	}
+
+	rows, err := ps.db.QueryContext(ctx,
+		"UPDATE shortener SET deleted_at = NULL, updated_at = now() "+
+			"WHERE user_id = $1 AND short_url = ANY($2) AND deleted_at IS NOT NULL "+
+			"AND ($3::float8 <= 0 OR deleted_at >= now() - make_interval(secs => $3::float8)) "+
+			"AND (expires_at IS NULL OR expires_at > now()) "+
+			"RETURNING short_url;",
+		restoredURLs.UserIDValue, idsArray, ps.purge.Retention.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	restored := []string{}
+	for rows.Next() {
+		var shortURLValue string
+		if err = rows.Scan(&shortURLValue); err != nil {
+			return nil, err
+		}
+		restored = append(restored, shortURLValue)
+	}
+	return restored, rows.Err()
+}
+
+// func RestoreUserURL clears the soft deletion of the user links at memory storage,
+// only the links deleted within the retention period and not expired are restored.
+// the link is not restored if the user has another live link of the same original URL.
+// the function returns the restored short URL values.
+func (d *Dictionary) RestoreUserURL(ctx context.Context, restoredURLs *DeletedShortURLValues) ([]string, error) {
+	restored := []string{}
+	if restoredURLs == nil {
+		return restored, nil
+	}
+
+	// the user index is locked before the links shards as AddURL locks them,
+	// so the original URL value can not be shortened again while the link is restored
+	users := d.users[userShardIndex(restoredURLs.UserIDValue)]
+	users.Lock()
+	defer users.Unlock()
+	index := users.index(restoredURLs.UserIDValue)
+
+	now := time.Now().UTC()
+	records := make([]*ItemType, 0, len(restoredURLs.ShortURLValues))
+	previous := make([]dictionaryItem, 0, len(restoredURLs.ShortURLValues))
+	unlock := d.lockShards(restoredURLs.ShortURLValues)
+	for _, item := range restoredURLs.ShortURLValues {
+		shard := d.shards[shardIndex(item)]
+		value, ok := shard.items[item]
+		if !ok || !value.owned || value.userID != restoredURLs.UserIDValue ||
+			!d.purge.restorable(value.deletedAt, value.expiresAt, now) {
+			continue
+		}
+		if other, ok := index.originals[value.longURL]; ok && other != item {
+			continue
+		}
+		previous = append(previous, value)
+		value.deletedAt = nil
+		value.updatedAt = now
+		shard.put(item, value)
+		index.add(item, value.longURL)
+
+		records = append(records, &ItemType{
+			Type:          ItemTypeRestore,
+			Timestamp:     &now,
+			ShortURLValue: item,
+			UserID:        restoredURLs.UserIDValue,
+		})
+	}
+	if len(records) == 0 {
+		unlock()
+		if len(index.values) == 0 {
+			delete(users.users, restoredURLs.UserIDValue)
+		}
+		return restored, nil
+	}
+	// the batch is appended with one call and synced once, the same way as the batch deletion.
+	// the records are appended before the links are unlocked, the links are rolled back if the log fails
+	seq, err := d.appendFile(records...)
+	if err != nil {
+		for i, v := range records {
+			d.shards[shardIndex(v.ShortURLValue)].put(v.ShortURLValue, previous[i])
+			index.remove(v.ShortURLValue, previous[i].longURL)
+		}
+		unlock()
+		if len(index.values) == 0 {
+			delete(users.users, restoredURLs.UserIDValue)
+		}
+		return restored, err
+	}
+	unlock()
+
+	for _, v := range records {
+		restored = append(restored, v.ShortURLValue)
+	}
+	return restored, d.commitFile(seq)
+}
+
+// func RestoreUserURL clears the soft deletion of the user links at linked list storage, expired links are not restored.
+// the linked list storage does not purge the deleted links, so they may be restored at any time.
+// the link is not restored if the user has another live link of the same original URL.
+// the function returns the restored short URL values.
+func (l UsersLinkedListMemoryStorage) RestoreUserURL(ctx context.Context, restoredURLs *DeletedShortURLValues) ([]string, error) {
+	restored := []string{}
+	list := l.LinkedListStorage[restoredURLs.UserIDValue]
+	if list == nil {
+		return restored, nil
+	}
+
+	values := make(map[string]struct{}, len(restoredURLs.ShortURLValues))
+	for _, v := range restoredURLs.ShortURLValues {
+		values[v] = struct{}{}
+	}
+
+	live := make(map[string]struct{})
+	for currentItem := list.Head; currentItem != nil; currentItem = currentItem.Next {
+		if currentItem.DeletedAt == nil {
+			live[currentItem.OriginalURLValue] = struct{}{}
+		}
+	}
+
+	now := time.Now()
+	for currentItem := list.Head; currentItem != nil; currentItem = currentItem.Next {
+		if _, ok := values[currentItem.ShortURLValue]; !ok {
+			continue
+		}
+		if !(PurgePolicy{}).restorable(currentItem.DeletedAt, currentItem.ExpiresAt, now) {
+			continue
+		}
+		if _, ok := live[currentItem.OriginalURLValue]; ok {
+			continue
+		}
+		currentItem.DeletedAt = nil
+		currentItem.UpdatedAt = now.UTC()
+		live[currentItem.OriginalURLValue] = struct{}{}
+		restored = append(restored, currentItem.ShortURLValue)
+	}
+	return restored, nil
+}
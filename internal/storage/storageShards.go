@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const dictionaryShardsConst = 64 // count of memory storage shards, must be a power of two
+
+type (
+	// type dictionaryItem is a memory storage link record.
+	dictionaryItem struct {
+		createdAt    time.Time  // zero for the records written by the old versions
+		updatedAt    time.Time  // last destination edit, deletion or restoration time, the creation time if the link was never modified
+		accessedAt   *time.Time // last access time, nil if the link was never accessed
+		expiresAt    *time.Time
+		deletedAt    *time.Time   // soft deletion time, the deleted link keeps its short URL value until it is purged
+		versions     []URLVersion // destinations of the edited link, empty if the link was never edited
+		longURL      string
+		userID       int32
+		owned        bool   // false for the records loaded from the file, the file does not keep the owner
+		passwordHash string // salted password hash, empty if the link is not protected
+		clicksLeft   *int64 // clicks left of the limited link, nil if the link is not limited
+	}
+
+	// type dictionaryShard is a part of the links guarded by its own lock.
+	dictionaryShard struct {
+		items    map[string]dictionaryItem
+		expiring map[string]time.Time
+		deleted  map[string]time.Time
+		retired  map[string]struct{} // short URL values of the purged links which are never used again
+		sync.RWMutex
+	}
+
+	// type userIndex keeps the user short URL values, positions allow to remove a value in O(1).
+	// the removal moves the last value to the place of the removed one, so the values are not ordered,
+	// the listing sorts the links itself. originals maps the original URL values to the short URL values.
+	userIndex struct {
+		positions map[string]int
+		originals map[string]string
+		values    []string
+	}
+
+	// type userShard is a part of the per-user index guarded by its own lock.
+	userShard struct {
+		users map[int32]*userIndex
+		sync.RWMutex
+	}
+)
+
+func newDictionaryShard() *dictionaryShard {
+	return &dictionaryShard{
+		items:    make(map[string]dictionaryItem),
+		expiring: make(map[string]time.Time),
+		deleted:  make(map[string]time.Time),
+		retired:  make(map[string]struct{}),
+	}
+}
+
+// func put saves the record, the shard must be locked for writing.
+func (s *dictionaryShard) put(shortURLValue string, item dictionaryItem) {
+	s.items[shortURLValue] = item
+	if item.expiresAt != nil {
+		s.expiring[shortURLValue] = *item.expiresAt
+	}
+	if item.deletedAt != nil {
+		s.deleted[shortURLValue] = *item.deletedAt
+	} else {
+		delete(s.deleted, shortURLValue)
+	}
+}
+
+// func remove deletes the record, the shard must be locked for writing.
+func (s *dictionaryShard) remove(shortURLValue string) {
+	delete(s.items, shortURLValue)
+	delete(s.expiring, shortURLValue)
+	delete(s.deleted, shortURLValue)
+}
+
+// func taken checks that the short URL value is used by a link or is retired, the shard must be locked.
+func (s *dictionaryShard) taken(shortURLValue string) bool {
+	if _, ok := s.items[shortURLValue]; ok {
+		return true
+	}
+	_, ok := s.retired[shortURLValue]
+	return ok
+}
+
+func newUserShard() *userShard {
+	return &userShard{
+		users: make(map[int32]*userIndex),
+	}
+}
+
+// func add appends the short URL value to the user index.
+func (s *userShard) add(userID int32, shortURLValue string, longURLValue string) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.index(userID).add(shortURLValue, longURLValue)
+}
+
+// func remove deletes the short URL value from the user index.
+func (s *userShard) remove(userID int32, shortURLValue string, longURLValue string) {
+	s.Lock()
+	defer s.Unlock()
+
+	index, ok := s.users[userID]
+	if !ok {
+		return
+	}
+	index.remove(shortURLValue, longURLValue)
+	if len(index.values) == 0 {
+		delete(s.users, userID)
+	}
+}
+
+// func index returns the user index, the empty index is created if the user has no links. The shard must be locked for writing.
+func (s *userShard) index(userID int32) *userIndex {
+	index, ok := s.users[userID]
+	if !ok {
+		index = &userIndex{
+			positions: make(map[string]int),
+			originals: make(map[string]string),
+		}
+		s.users[userID] = index
+	}
+	return index
+}
+
+// func add appends the short URL value to the index.
+func (index *userIndex) add(shortURLValue string, longURLValue string) {
+	if _, ok := index.positions[shortURLValue]; ok {
+		return
+	}
+	index.positions[shortURLValue] = len(index.values)
+	index.values = append(index.values, shortURLValue)
+	if _, ok := index.originals[longURLValue]; !ok {
+		index.originals[longURLValue] = shortURLValue
+	}
+}
+
+// func remove deletes the short URL value from the index, the last value takes its place.
+func (index *userIndex) remove(shortURLValue string, longURLValue string) {
+	i, ok := index.positions[shortURLValue]
+	if !ok {
+		return
+	}
+
+	last := len(index.values) - 1
+	index.values[i] = index.values[last]
+	index.positions[index.values[i]] = i
+	index.values[last] = ""
+	index.values = index.values[:last]
+	delete(index.positions, shortURLValue)
+	if index.originals[longURLValue] == shortURLValue {
+		delete(index.originals, longURLValue)
+	}
+}
+
+// func rename replaces the original URL value of the short URL value at the index.
+func (index *userIndex) rename(shortURLValue string, previousURL string, longURLValue string) {
+	if index.originals[previousURL] == shortURLValue {
+		delete(index.originals, previousURL)
+	}
+	if _, ok := index.originals[longURLValue]; !ok {
+		index.originals[longURLValue] = shortURLValue
+	}
+}
+
+// func list returns a copy of the user short URL values.
+func (s *userShard) list(userID int32) []string {
+	s.RLock()
+	defer s.RUnlock()
+
+	index, ok := s.users[userID]
+	if !ok {
+		return nil
+	}
+	return append([]string(nil), index.values...)
+}
+
+// func shardIndex returns the shard number of the short URL value, FNV-1a hash is used.
+func shardIndex(shortURLValue string) int {
+	hash := uint32(2166136261)
+	for i := 0; i < len(shortURLValue); i++ {
+		hash ^= uint32(shortURLValue[i])
+		hash *= 16777619
+	}
+	return int(hash & (dictionaryShardsConst - 1))
+}
+
+// func userShardIndex returns the user index shard number of the user.
+func userShardIndex(userID int32) int {
+	return int(uint32(userID) & (dictionaryShardsConst - 1))
+}
+
+// func lockShards locks for writing the shards of the short URL values in ascending order,
+// the order prevents deadlocks of the concurrent batches. The function returns the unlock function.
+func (d *Dictionary) lockShards(shortURLValues []string) func() {
+	indexes := make([]int, 0, len(shortURLValues))
+	seen := make(map[int]struct{}, len(shortURLValues))
+	for _, v := range shortURLValues {
+		i := shardIndex(v)
+		if _, ok := seen[i]; ok {
+			continue
+		}
+		seen[i] = struct{}{}
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	for _, i := range indexes {
+		d.shards[i].Lock()
+	}
+	return func() {
+		for j := len(indexes) - 1; j >= 0; j-- {
+			d.shards[indexes[j]].Unlock()
+		}
+	}
+}
+
+// func rlockShards locks all shards for reading in ascending order, the function returns the unlock function.
+func (d *Dictionary) rlockShards() func() {
+	for _, shard := range d.shards {
+		shard.RLock()
+	}
+	return func() {
+		for j := len(d.shards) - 1; j >= 0; j-- {
+			d.shards[j].RUnlock()
+		}
+	}
+}
@@ -0,0 +1,608 @@
+package storage
+
+import (
+	"container/heap"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgtype"
+)
+
+// ErrTransferUnsupported - the storage can not list or take the links with their full state.
+var ErrTransferUnsupported = errors.New("storage does not support data migration")
+
+// type LinkTransfer is an optional storage interface, storages which can copy the links with their full state
+// to another storage implement it. The links are listed in ascending order of the short URL values,
+// so the copy can be continued after the last copied value.
+type LinkTransfer interface {
+	// ExportLinks returns at most limit links with the short URL values greater than after,
+	// the expired links are skipped. The zero limit returns all links after the value.
+	ExportLinks(ctx context.Context, after string, limit int) ([]LinkRecord, error)
+	// ImportLinks saves the links as they are, the function returns count of saved links.
+	// the link which short URL value is taken or which repeats the original URL of the same user is skipped,
+	// so the import of the same links may be repeated. The links without owner are not checked for the original URL.
+	ImportLinks(ctx context.Context, links []LinkRecord) (int, error)
+	// GetLink returns the link with its full state, ErrNotExistRecord is returned if there is no such link.
+	GetLink(ctx context.Context, shortURLValue string) (LinkRecord, error)
+	// CountLinks returns count of not expired links and retired short URL values.
+	CountLinks(ctx context.Context) (int, error)
+}
+
+// type LinkRecord is the full state of the link, the times unknown to the storage are nil.
+// the retired record keeps the short URL value of the purged link which is never used again, it has no other fields.
+type LinkRecord struct {
+	CreatedAt    *time.Time   `json:"created_at,omitempty"`
+	UpdatedAt    *time.Time   `json:"updated_at,omitempty"`
+	AccessedAt   *time.Time   `json:"last_accessed_at,omitempty"`
+	ExpiresAt    *time.Time   `json:"expires_at,omitempty"`
+	DeletedAt    *time.Time   `json:"deleted_at,omitempty"` // soft deletion time, nil for the live link
+	Versions     []URLVersion `json:"versions,omitempty"`   // destinations of the edited link, empty if the link was never edited
+	ShortURL     string       `json:"short_url"`
+	OriginalURL  string       `json:"original_url,omitempty"`
+	PasswordHash string       `json:"password_hash,omitempty"` // salted password hash of the protected link
+	ClicksLeft   *int64       `json:"clicks_left,omitempty"`   // clicks left of the limited link
+	UserID       int32        `json:"user_id"`
+	Owned        bool         `json:"owned,omitempty"` // false for the legacy link without owner, its user ID is zero
+	Retired      bool         `json:"retired,omitempty"`
+}
+
+// type shortURLHeap is a max-heap of the short URL values, it keeps the least values seen.
+type shortURLHeap []string
+
+func (h shortURLHeap) Len() int            { return len(h) }
+func (h shortURLHeap) Less(i, j int) bool  { return h[i] > h[j] }
+func (h shortURLHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *shortURLHeap) Push(x interface{}) { *h = append(*h, x.(string)) }
+func (h *shortURLHeap) Pop() interface{} {
+	old := *h
+	v := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return v
+}
+
+// func offer keeps the value if it is one of the limit least values, the zero limit keeps all values.
+func (h *shortURLHeap) offer(shortURLValue string, limit int) {
+	if limit <= 0 || h.Len() < limit {
+		heap.Push(h, shortURLValue)
+		return
+	}
+	if shortURLValue < (*h)[0] {
+		(*h)[0] = shortURLValue
+		heap.Fix(h, 0)
+	}
+}
+
+// func ExportLinks returns the links of the memory storage in ascending order of the short URL values,
+// the retired short URL values are returned as the retired records.
+func (d *Dictionary) ExportLinks(ctx context.Context, after string, limit int) ([]LinkRecord, error) {
+	now := time.Now()
+	least := &shortURLHeap{}
+	for _, shard := range d.shards {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		shard.RLock()
+		for shortURL, item := range shard.items {
+			if shortURL > after && !isExpired(item.expiresAt, now) {
+				least.offer(shortURL, limit)
+			}
+		}
+		for shortURL := range shard.retired {
+			if shortURL > after {
+				least.offer(shortURL, limit)
+			}
+		}
+		shard.RUnlock()
+	}
+
+	values := []string(*least)
+	sort.Strings(values)
+	result := make([]LinkRecord, 0, len(values))
+	for _, v := range values {
+		link, err := d.GetLink(ctx, v)
+		if errors.Is(err, ErrNotExistRecord) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, link)
+	}
+	return result, nil
+}
+
+// func GetLink returns the link of the memory storage with its full state.
+func (d *Dictionary) GetLink(ctx context.Context, shortURLValue string) (LinkRecord, error) {
+	shard := d.shards[shardIndex(shortURLValue)]
+	shard.RLock()
+	defer shard.RUnlock()
+
+	item, ok := shard.items[shortURLValue]
+	if !ok {
+		if _, retired := shard.retired[shortURLValue]; retired {
+			return LinkRecord{ShortURL: shortURLValue, Retired: true}, nil
+		}
+		return LinkRecord{}, ErrNotExistRecord
+	}
+	if isExpired(item.expiresAt, time.Now()) {
+		return LinkRecord{}, ErrNotExistRecord
+	}
+
+	return LinkRecord{
+		ShortURL:     shortURLValue,
+		OriginalURL:  item.longURL,
+		UserID:       item.userID,
+		Owned:        item.owned,
+		CreatedAt:    timeOrNil(item.createdAt),
+		UpdatedAt:    timeOrNil(item.updatedAt),
+		AccessedAt:   item.accessedAt,
+		ExpiresAt:    item.expiresAt,
+		DeletedAt:    item.deletedAt,
+		Versions:     append([]URLVersion(nil), item.versions...),
+		PasswordHash: item.passwordHash,
+		ClicksLeft:   copyCount(item.clicksLeft),
+	}, nil
+}
+
+// func ImportLinks saves the links to the memory storage and to the file storage log,
+// the link is written as the snapshot writes it, so the next start restores the same state.
+func (d *Dictionary) ImportLinks(ctx context.Context, links []LinkRecord) (int, error) {
+	count := 0
+	for _, link := range links {
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+
+		saved, err := d.importLink(link)
+		if err != nil {
+			return count, err
+		}
+		if saved {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// func importLink saves the link, the user index is locked before the links shard as AddURL locks them.
+// the link without owner is saved as the legacy record, it is not added to the user index.
+func (d *Dictionary) importLink(link LinkRecord) (bool, error) {
+	var users *userShard
+	if link.Owned && !link.Retired {
+		users = d.users[userShardIndex(link.UserID)]
+		users.Lock()
+		defer users.Unlock()
+	}
+
+	shard := d.shards[shardIndex(link.ShortURL)]
+	shard.Lock()
+	defer shard.Unlock()
+
+	if shard.taken(link.ShortURL) {
+		return false, nil
+	}
+
+	if link.Retired {
+		if err := d.writeFile(&ItemType{Type: ItemTypeRetire, ShortURLValue: link.ShortURL}); err != nil {
+			return false, err
+		}
+		shard.retired[link.ShortURL] = struct{}{}
+		return true, nil
+	}
+
+	var index *userIndex
+	if users != nil {
+		index = users.index(link.UserID)
+		if _, ok := index.originals[link.OriginalURL]; ok && link.DeletedAt == nil {
+			return false, nil
+		}
+	}
+
+	item := dictionaryItem{
+		longURL:      link.OriginalURL,
+		userID:       link.UserID,
+		owned:        users != nil,
+		expiresAt:    utcOrNil(link.ExpiresAt),
+		deletedAt:    utcOrNil(link.DeletedAt),
+		accessedAt:   utcOrNil(link.AccessedAt),
+		versions:     append([]URLVersion(nil), link.Versions...),
+		passwordHash: link.PasswordHash,
+		clicksLeft:   copyCount(link.ClicksLeft),
+	}
+	if link.CreatedAt != nil {
+		item.createdAt = link.CreatedAt.UTC()
+		item.updatedAt = item.createdAt
+	}
+	if link.UpdatedAt != nil {
+		item.updatedAt = link.UpdatedAt.UTC()
+	}
+
+	records := itemRecords(link.ShortURL, item)
+	pointers := make([]*ItemType, len(records))
+	for i := range records {
+		pointers[i] = &records[i]
+	}
+	if err := d.writeFile(pointers...); err != nil {
+		return false, err
+	}
+
+	shard.put(link.ShortURL, item)
+	switch {
+	case index == nil:
+	case item.deletedAt == nil:
+		index.add(link.ShortURL, link.OriginalURL)
+	case len(index.values) == 0:
+		delete(users.users, link.UserID)
+	}
+	return true, nil
+}
+
+// func CountLinks returns count of not expired links and retired short URL values of the memory storage.
+func (d *Dictionary) CountLinks(ctx context.Context) (int, error) {
+	now := time.Now()
+	count := 0
+	for _, shard := range d.shards {
+		shard.RLock()
+		for _, item := range shard.items {
+			if !isExpired(item.expiresAt, now) {
+				count++
+			}
+		}
+		count += len(shard.retired)
+		shard.RUnlock()
+	}
+	return count, nil
+}
+
+// postgres links export query, the short URL values are compared byte by byte as the Go strings are.
+const pgExportLinksQuery = "SELECT short_url, original_url, user_id, created_at, updated_at, last_accessed_at, expires_at, deleted_at, password_hash, clicks_left, retired " +
+	"FROM (" +
+	"SELECT short_url, original_url, user_id, created_at, updated_at, last_accessed_at, expires_at, deleted_at, password_hash, clicks_left, false AS retired " +
+	"FROM shortener WHERE short_url COLLATE \"C\" > $1 AND (expires_at IS NULL OR expires_at > now()) " +
+	"UNION ALL " +
+	"SELECT short_url, '', NULL, NULL, NULL, NULL, NULL, NULL, NULL, NULL, true FROM retired_short_urls WHERE short_url COLLATE \"C\" > $1" +
+	") AS links "
+
+// func ExportLinks returns the links of the postgres database in ascending order of the short URL values,
+// the retired short URL values are returned as the retired records.
+func (ps *PostgresStorage) ExportLinks(ctx context.Context, after string, limit int) ([]LinkRecord, error) {
+	query := pgExportLinksQuery + "ORDER BY short_url COLLATE \"C\" ;"
+	args := []interface{}{after}
+	if limit > 0 {
+		query = pgExportLinksQuery + "ORDER BY short_url COLLATE \"C\" LIMIT $2 ;"
+		args = append(args, limit)
+	}
+
+	rows, err := ps.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	links, err := scanLinks(rows)
+	if err != nil {
+		return nil, err
+	}
+	return links, ps.linkVersions(ctx, links)
+}
+
+// func GetLink returns the link of the postgres database with its full state.
+func (ps *PostgresStorage) GetLink(ctx context.Context, shortURLValue string) (LinkRecord, error) {
+	rows, err := ps.db.QueryContext(ctx,
+		"SELECT short_url, original_url, user_id, created_at, updated_at, last_accessed_at, expires_at, deleted_at, password_hash, clicks_left, false "+
+			"FROM shortener WHERE short_url = $1 AND (expires_at IS NULL OR expires_at > now()) "+
+			"UNION ALL "+
+			"SELECT short_url, '', NULL, NULL, NULL, NULL, NULL, NULL, NULL, NULL, true FROM retired_short_urls WHERE short_url = $1 ;",
+		shortURLValue)
+	if err != nil {
+		return LinkRecord{}, err
+	}
+	links, err := scanLinks(rows)
+	if err != nil {
+		return LinkRecord{}, err
+	}
+	if len(links) == 0 {
+		return LinkRecord{}, ErrNotExistRecord
+	}
+	if err = ps.linkVersions(ctx, links[:1]); err != nil {
+		return LinkRecord{}, err
+	}
+	return links[0], nil
+}
+
+// func ImportLinks saves the links to the postgres database in one transaction, the taken short URL values are skipped.
+// the links without owner are saved with NULL user ID as the legacy rows are.
+func (ps *PostgresStorage) ImportLinks(ctx context.Context, links []LinkRecord) (int, error) {
+	tx, err := ps.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	insertLink, err := tx.PrepareContext(ctx,
+		"INSERT INTO shortener (user_id, short_url, original_url, expires_at, deleted_at, created_at, updated_at, last_accessed_at, password_hash, clicks_left) "+
+			"SELECT $1::integer, $2::varchar, $3::varchar, $4::timestamptz, $5::timestamp, "+
+			"COALESCE($6::timestamptz, now()), COALESCE($7::timestamptz, $6::timestamptz, now()), $8::timestamptz, $9::text, $10::bigint "+
+			"WHERE NOT EXISTS (SELECT 1 FROM retired_short_urls WHERE short_url = $2) "+
+			"ON CONFLICT DO NOTHING;")
+	if err != nil {
+		return 0, err
+	}
+	defer insertLink.Close()
+
+	insertVersion, err := tx.PrepareContext(ctx,
+		"INSERT INTO url_versions (short_url, version, original_url, edited_by, edited_at) "+
+			"VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING;")
+	if err != nil {
+		return 0, err
+	}
+	defer insertVersion.Close()
+
+	insertRetired, err := tx.PrepareContext(ctx,
+		"INSERT INTO retired_short_urls (short_url) "+
+			"SELECT $1::varchar WHERE NOT EXISTS (SELECT 1 FROM shortener WHERE short_url = $1) "+
+			"ON CONFLICT DO NOTHING;")
+	if err != nil {
+		return 0, err
+	}
+	defer insertRetired.Close()
+
+	count := 0
+	for _, link := range links {
+		var cTag sql.Result
+		if link.Retired {
+			cTag, err = insertRetired.ExecContext(ctx, link.ShortURL)
+		} else {
+			userID := sql.NullInt32{Int32: link.UserID, Valid: link.Owned}
+			cTag, err = insertLink.ExecContext(ctx, userID, link.ShortURL, link.OriginalURL,
+				utcOrNil(link.ExpiresAt), utcOrNil(link.DeletedAt), utcOrNil(link.CreatedAt), utcOrNil(link.UpdatedAt), utcOrNil(link.AccessedAt),
+				nullString(link.PasswordHash), link.ClicksLeft)
+		}
+		if err != nil {
+			return 0, err
+		}
+		cnt, err := cTag.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		if cnt == 0 {
+			continue
+		}
+		count++
+
+		for _, v := range link.Versions {
+			if _, err = insertVersion.ExecContext(ctx, link.ShortURL, v.Version, v.OriginalURL, v.EditedBy, utcOrNil(v.EditedAt)); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// func CountLinks returns count of not expired links and retired short URL values of the postgres database.
+func (ps *PostgresStorage) CountLinks(ctx context.Context) (int, error) {
+	var count int
+	err := ps.db.QueryRowContext(ctx,
+		"SELECT (SELECT COUNT(*) FROM shortener WHERE expires_at IS NULL OR expires_at > now()) + "+
+			"(SELECT COUNT(*) FROM retired_short_urls) ;").Scan(&count)
+	return count, err
+}
+
+// func linkVersions reads the destinations of the edited links.
+func (ps *PostgresStorage) linkVersions(ctx context.Context, links []LinkRecord) error {
+	if len(links) == 0 {
+		return nil
+	}
+
+	positions := make(map[string]int, len(links))
+	values := make([]string, 0, len(links))
+	for i, v := range links {
+		if !v.Retired {
+			positions[v.ShortURL] = i
+			values = append(values, v.ShortURL)
+		}
+	}
+	shortURLs := &pgtype.TextArray{}
+	if err := shortURLs.Set(values); err != nil {
+		return err
+	}
+
+	rows, err := ps.db.QueryContext(ctx,
+		"SELECT short_url, version, original_url, edited_by, edited_at FROM url_versions "+
+			"WHERE short_url = ANY($1) ORDER BY short_url, version ;",
+		shortURLs)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var shortURL string
+		var editedBy sql.NullInt32
+		v := URLVersion{}
+		if err = rows.Scan(&shortURL, &v.Version, &v.OriginalURL, &editedBy, &v.EditedAt); err != nil {
+			return err
+		}
+		v.EditedBy = editedBy.Int32
+		if i, ok := positions[shortURL]; ok {
+			links[i].Versions = append(links[i].Versions, v)
+		}
+	}
+	return rows.Err()
+}
+
+// func scanLinks reads the links rows and closes them.
+func scanLinks(rows *sql.Rows) ([]LinkRecord, error) {
+	defer rows.Close()
+
+	result := []LinkRecord{}
+	for rows.Next() {
+		link := LinkRecord{}
+		var userID sql.NullInt32
+		var passwordHash sql.NullString
+		if err := rows.Scan(&link.ShortURL, &link.OriginalURL, &userID, &link.CreatedAt, &link.UpdatedAt,
+			&link.AccessedAt, &link.ExpiresAt, &link.DeletedAt, &passwordHash, &link.ClicksLeft, &link.Retired); err != nil {
+			return nil, err
+		}
+		link.UserID = userID.Int32
+		link.Owned = userID.Valid
+		link.PasswordHash = passwordHash.String
+		result = append(result, link)
+	}
+	return result, rows.Err()
+}
+
+// func utcOrNil returns the time in UTC, nil time stays nil.
+func utcOrNil(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	value := t.UTC()
+	return &value
+}
+
+// type TransferReport is the result of the data migration verification.
+type TransferReport struct {
+	Mismatches  []string // differences of the sampled links
+	SourceCount int
+	TargetCount int
+	Sampled     int
+}
+
+// func CopyLinks copies the links with the short URL values greater than after from one storage to another by batches.
+// progress is called after every batch with the last copied short URL value, count of saved and skipped links,
+// the copy may be continued after the value.
+func CopyLinks(ctx context.Context, from LinkTransfer, to LinkTransfer, after string, batchSize int,
+	progress func(last string, saved int, skipped int) error) error {
+	if batchSize <= 0 {
+		return fmt.Errorf("migration batch size %d must be positive", batchSize)
+	}
+
+	for {
+		links, err := from.ExportLinks(ctx, after, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(links) == 0 {
+			return nil
+		}
+
+		saved, err := to.ImportLinks(ctx, links)
+		if err != nil {
+			return err
+		}
+		after = links[len(links)-1].ShortURL
+		if err = progress(after, saved, len(links)-saved); err != nil {
+			return err
+		}
+	}
+}
+
+// func VerifyLinks compares the counts of links of the storages and the random sample of the source links with the target ones.
+// the target may keep more links than the source, if the target was not empty before the migration.
+func VerifyLinks(ctx context.Context, from LinkTransfer, to LinkTransfer, batchSize int, samples int) (TransferReport, error) {
+	report := TransferReport{}
+	if batchSize <= 0 {
+		return report, fmt.Errorf("migration batch size %d must be positive", batchSize)
+	}
+
+	var err error
+	if report.SourceCount, err = from.CountLinks(ctx); err != nil {
+		return report, err
+	}
+	if report.TargetCount, err = to.CountLinks(ctx); err != nil {
+		return report, err
+	}
+
+	// reservoir sampling of the source links
+	sample := make([]LinkRecord, 0, samples)
+	seen := 0
+	after := ""
+	for samples > 0 {
+		links, err := from.ExportLinks(ctx, after, batchSize)
+		if err != nil {
+			return report, err
+		}
+		if len(links) == 0 {
+			break
+		}
+		for _, v := range links {
+			seen++
+			if len(sample) < samples {
+				sample = append(sample, v)
+			} else if i := rand.Intn(seen); i < samples {
+				sample[i] = v
+			}
+		}
+		after = links[len(links)-1].ShortURL
+	}
+
+	for _, v := range sample {
+		link, err := to.GetLink(ctx, v.ShortURL)
+		if errors.Is(err, ErrNotExistRecord) {
+			report.Mismatches = append(report.Mismatches, v.ShortURL+": missing at the target")
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		if diff := linkDiff(v, link); diff != "" {
+			report.Mismatches = append(report.Mismatches, v.ShortURL+": "+diff)
+		}
+	}
+	report.Sampled = len(sample)
+	return report, nil
+}
+
+// func Failed checks that the target lost some links of the source.
+func (r TransferReport) Failed() bool {
+	return r.TargetCount < r.SourceCount || len(r.Mismatches) > 0
+}
+
+// func linkDiff describes the difference of the copied link, the empty value means the same link.
+// the times are compared with the millisecond precision, postgres keeps the microseconds only.
+// the creation time unknown to the source is set by the target.
+func linkDiff(source LinkRecord, target LinkRecord) string {
+	switch {
+	case source.Retired != target.Retired:
+		return "retired state differs"
+	case source.Retired:
+		return ""
+	case source.OriginalURL != target.OriginalURL:
+		return fmt.Sprintf("original URL %q differs from %q", target.OriginalURL, source.OriginalURL)
+	case source.Owned != target.Owned:
+		return "owner state differs"
+	case source.UserID != target.UserID:
+		return fmt.Sprintf("owner %d differs from %d", target.UserID, source.UserID)
+	case (source.DeletedAt == nil) != (target.DeletedAt == nil):
+		return "deletion state differs"
+	case !sameTime(source.ExpiresAt, target.ExpiresAt):
+		return "expiration time differs"
+	case source.PasswordHash != target.PasswordHash:
+		return "password differs"
+	case !sameCount(source.ClicksLeft, target.ClicksLeft):
+		return "clicks left differ"
+	case source.CreatedAt != nil && !sameTime(source.CreatedAt, target.CreatedAt):
+		return "creation time differs"
+	case len(source.Versions) != len(target.Versions):
+		return fmt.Sprintf("%d versions differ from %d", len(target.Versions), len(source.Versions))
+	}
+	return ""
+}
+
+// func sameTime checks that the times are both unknown or differ less than a millisecond.
+func sameTime(t *time.Time, other *time.Time) bool {
+	if t == nil || other == nil {
+		return t == nil && other == nil
+	}
+	diff := t.Sub(*other)
+	return diff < time.Millisecond && diff > -time.Millisecond
+}
@@ -0,0 +1,333 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/jackc/pgconn"
+)
+
+// Link editing errors.
+var (
+	ErrURLInvalid      = errors.New("original URL is invalid")       // the new destination is not a URL
+	ErrNotOwner        = errors.New("link is owned by another user") // only the link owner edits it
+	ErrVersionNotExist = errors.New("link version not exist")        // the link has no such version
+)
+
+// type URLVersion is a destination of the link, versions are numbered from one in the order of editing.
+// the first version is the destination set on creation, its edit time is unknown.
+type URLVersion struct {
+	EditedAt    *time.Time `json:"edited_at,omitempty"`
+	OriginalURL string     `json:"original_url"`
+	Version     int        `json:"version"`
+	EditedBy    int32      `json:"edited_by"`
+}
+
+// func validateOriginalURL checks the new destination of the link.
+func validateOriginalURL(longURLValue string) error {
+	if strings.TrimSpace(longURLValue) == "" || !govalidator.IsURL(longURLValue) {
+		return ErrURLInvalid
+	}
+	return nil
+}
+
+// func appendVersion adds the new destination to the link versions, the history of the link which was never edited
+// starts with the destination set by the owner on creation.
+func appendVersion(versions []URLVersion, currentURL string, ownerID int32,
+	longURLValue string, userID int32, editedAt time.Time) []URLVersion {
+	if len(versions) == 0 {
+		versions = []URLVersion{{Version: 1, OriginalURL: currentURL, EditedBy: ownerID}}
+	}
+	return append(versions, URLVersion{
+		Version:     len(versions) + 1,
+		OriginalURL: longURLValue,
+		EditedBy:    userID,
+		EditedAt:    &editedAt,
+	})
+}
+
+// func linkHistory returns a copy of the link versions, the link which was never edited has the only version.
+func linkHistory(versions []URLVersion, currentURL string, ownerID int32) []URLVersion {
+	if len(versions) == 0 {
+		return []URLVersion{{Version: 1, OriginalURL: currentURL, EditedBy: ownerID}}
+	}
+	return append([]URLVersion(nil), versions...)
+}
+
+// func RollbackURL sets the destination of the earlier link version as the new version of the link.
+// the history is kept, the rollback is recorded as a new version.
+func RollbackURL(ctx context.Context, repo Storage, shortURLValue string, version int, userID int32) (URLVersion, error) {
+	history, err := repo.GetURLHistory(ctx, shortURLValue, userID)
+	if err != nil {
+		return URLVersion{}, err
+	}
+	for _, v := range history {
+		if v.Version == version {
+			return repo.UpdateURL(ctx, shortURLValue, v.OriginalURL, userID)
+		}
+	}
+	return URLVersion{}, ErrVersionNotExist
+}
+
+// func UpdateURL points the user link of the postgres database at the new original URL value,
+// the previous destinations are kept at the url_versions table. The function returns the new version.
+func (ps *PostgresStorage) UpdateURL(ctx context.Context, shortURLValue string, longURLValue string, userID int32) (URLVersion, error) {
+	if err := validateOriginalURL(longURLValue); err != nil {
+		return URLVersion{}, err
+	}
+
+	tx, err := ps.db.BeginTx(ctx, nil)
+	if err != nil {
+		return URLVersion{}, err
+	}
+	defer tx.Rollback()
+
+	var ownerID int32
+	var currentURL string
+	var deletedAt *time.Time
+	err = tx.QueryRowContext(ctx,
+		"SELECT user_id, original_url, deleted_at FROM shortener WHERE short_url = $1 FOR UPDATE;",
+		shortURLValue).Scan(&ownerID, &currentURL, &deletedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return URLVersion{}, ErrNotExistRecord
+	}
+	if err != nil {
+		return URLVersion{}, err
+	}
+	if deletedAt != nil {
+		return URLVersion{}, ErrNotExistRecord
+	}
+	if ownerID != userID {
+		return URLVersion{}, ErrNotOwner
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE shortener SET original_url = $2, updated_at = now() WHERE short_url = $1;",
+		shortURLValue, longURLValue)
+	if err != nil {
+		// the short URL value is not changed, so the violation is the user original URL uniqueness
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolationCode {
+			return URLVersion{}, ErrDuplicateRecord
+		}
+		return URLVersion{}, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO url_versions (short_url, version, original_url, edited_by) "+
+			"SELECT $1, 1, $2, $3 "+
+			"WHERE NOT EXISTS (SELECT 1 FROM url_versions WHERE short_url = $1);",
+		shortURLValue, currentURL, ownerID)
+	if err != nil {
+		return URLVersion{}, err
+	}
+
+	version := URLVersion{OriginalURL: longURLValue, EditedBy: userID}
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO url_versions (short_url, version, original_url, edited_by, edited_at) "+
+			"SELECT $1, MAX(version) + 1, $2, $3, now() FROM url_versions WHERE short_url = $1 "+
+			"RETURNING version, edited_at;",
+		shortURLValue, longURLValue, userID).Scan(&version.Version, &version.EditedAt)
+	if err != nil {
+		return URLVersion{}, err
+	}
+	return version, tx.Commit()
+}
+
+// func GetURLHistory returns the versions of the user link from the postgres database, soft-deleted links have history too.
+func (ps *PostgresStorage) GetURLHistory(ctx context.Context, shortURLValue string, userID int32) ([]URLVersion, error) {
+	var ownerID int32
+	var currentURL string
+	err := ps.db.QueryRowContext(ctx,
+		"SELECT user_id, original_url FROM shortener WHERE short_url = $1 ;",
+		shortURLValue).Scan(&ownerID, &currentURL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotExistRecord
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ownerID != userID {
+		return nil, ErrNotOwner
+	}
+
+	rows, err := ps.db.QueryContext(ctx,
+		"SELECT version, original_url, edited_by, edited_at FROM url_versions WHERE short_url = $1 ORDER BY version ;",
+		shortURLValue)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []URLVersion
+	for rows.Next() {
+		v := URLVersion{}
+		if err = rows.Scan(&v.Version, &v.OriginalURL, &v.EditedBy, &v.EditedAt); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return linkHistory(versions, currentURL, ownerID), nil
+}
+
+// func UpdateURL points the user link of memory storage at the new original URL value, the function returns the new version.
+// the edit record is appended while the link is locked, so the changes of the link are written to the file in their order.
+func (d *Dictionary) UpdateURL(ctx context.Context, shortURLValue string, longURLValue string, userID int32) (URLVersion, error) {
+	if err := validateOriginalURL(longURLValue); err != nil {
+		return URLVersion{}, err
+	}
+
+	version, seq, err := d.updateURL(shortURLValue, longURLValue, userID)
+	if err != nil {
+		return URLVersion{}, err
+	}
+	if err = d.commitFile(seq); err != nil {
+		return URLVersion{}, err
+	}
+	return version, nil
+}
+
+// func updateURL edits the link under the user index and the shard locks,
+// the function returns the new version and the sequence number of the appended edit record.
+func (d *Dictionary) updateURL(shortURLValue string, longURLValue string, userID int32) (URLVersion, uint64, error) {
+	users := d.users[userShardIndex(userID)]
+	users.Lock()
+	defer users.Unlock()
+
+	shard := d.shards[shardIndex(shortURLValue)]
+	shard.Lock()
+	defer shard.Unlock()
+
+	item, ok := shard.items[shortURLValue]
+	if !ok || item.deletedAt != nil {
+		return URLVersion{}, 0, ErrNotExistRecord
+	}
+	if !item.owned || item.userID != userID {
+		return URLVersion{}, 0, ErrNotOwner
+	}
+	index := users.index(userID)
+	if other, ok := index.originals[longURLValue]; ok && other != shortURLValue {
+		return URLVersion{}, 0, ErrDuplicateRecord
+	}
+
+	now := time.Now().UTC()
+	versions := appendVersion(item.versions, item.longURL, item.userID, longURLValue, userID, now)
+	version := versions[len(versions)-1]
+	seq, err := d.appendFile(&ItemType{
+		Type:          ItemTypeUpdate,
+		Timestamp:     &now,
+		ShortURLValue: shortURLValue,
+		LongURLValue:  longURLValue,
+		UserID:        userID,
+		Version:       version.Version,
+	})
+	if err != nil {
+		return URLVersion{}, 0, err
+	}
+
+	previousURL := item.longURL
+	item.versions = versions
+	item.longURL = longURLValue
+	item.updatedAt = now
+	shard.put(shortURLValue, item)
+	index.rename(shortURLValue, previousURL, longURLValue)
+	return version, seq, nil
+}
+
+// func GetURLHistory returns the versions of the user link from memory storage, soft-deleted links have history too.
+func (d *Dictionary) GetURLHistory(ctx context.Context, shortURLValue string, userID int32) ([]URLVersion, error) {
+	shard := d.shards[shardIndex(shortURLValue)]
+	shard.RLock()
+	defer shard.RUnlock()
+
+	item, ok := shard.items[shortURLValue]
+	if !ok || !item.owned {
+		return nil, ErrNotExistRecord
+	}
+	if item.userID != userID {
+		return nil, ErrNotOwner
+	}
+	return linkHistory(item.versions, item.longURL, item.userID), nil
+}
+
+// func replayUpdate applies the link edit record, the record of the version which is already applied is skipped.
+func (d *Dictionary) replayUpdate(shard *dictionaryShard, item *ItemType) {
+	value, ok := shard.items[item.ShortURLValue]
+	if !ok || !value.owned || len(value.versions) >= item.Version {
+		return
+	}
+
+	editedAt := time.Now().UTC()
+	if item.Timestamp != nil {
+		editedAt = item.Timestamp.UTC()
+	}
+	previousURL := value.longURL
+	value.versions = appendVersion(value.versions, value.longURL, value.userID, item.LongURLValue, item.UserID, editedAt)
+	value.longURL = item.LongURLValue
+	value.updatedAt = laterTime(value.updatedAt, editedAt)
+	shard.put(item.ShortURLValue, value)
+
+	users := d.users[userShardIndex(value.userID)]
+	users.Lock()
+	if index, ok := users.users[value.userID]; ok {
+		index.rename(item.ShortURLValue, previousURL, item.LongURLValue)
+	}
+	users.Unlock()
+}
+
+// func UpdateURL points the user link of linked list storage at the new original URL value, the function returns the new version.
+func (l UsersLinkedListMemoryStorage) UpdateURL(ctx context.Context, shortURLValue string, longURLValue string, userID int32) (URLVersion, error) {
+	if err := validateOriginalURL(longURLValue); err != nil {
+		return URLVersion{}, err
+	}
+
+	ownerID, item := l.find(shortURLValue)
+	if item == nil || item.DeletedAt != nil {
+		return URLVersion{}, ErrNotExistRecord
+	}
+	if ownerID != userID {
+		return URLVersion{}, ErrNotOwner
+	}
+	for currentItem := l.LinkedListStorage[userID].Head; currentItem != nil; currentItem = currentItem.Next {
+		if currentItem != item && currentItem.DeletedAt == nil && currentItem.OriginalURLValue == longURLValue {
+			return URLVersion{}, ErrDuplicateRecord
+		}
+	}
+
+	now := time.Now().UTC()
+	item.Versions = appendVersion(item.Versions, item.OriginalURLValue, ownerID, longURLValue, userID, now)
+	item.OriginalURLValue = longURLValue
+	item.UpdatedAt = now
+	return item.Versions[len(item.Versions)-1], nil
+}
+
+// func GetURLHistory returns the versions of the user link from linked list storage, soft-deleted links have history too.
+func (l UsersLinkedListMemoryStorage) GetURLHistory(ctx context.Context, shortURLValue string, userID int32) ([]URLVersion, error) {
+	ownerID, item := l.find(shortURLValue)
+	if item == nil {
+		return nil, ErrNotExistRecord
+	}
+	if ownerID != userID {
+		return nil, ErrNotOwner
+	}
+	return linkHistory(item.Versions, item.OriginalURLValue, ownerID), nil
+}
+
+// func find returns the item of the short URL value and its owner, nil item means that the value is not used.
+func (l UsersLinkedListMemoryStorage) find(shortURLValue string) (int32, *URLItem) {
+	for userID, list := range l.LinkedListStorage {
+		for currentItem := list.Head; currentItem != nil; currentItem = currentItem.Next {
+			if currentItem.ShortURLValue == shortURLValue {
+				return userID, currentItem
+			}
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,386 @@
+// package storagetest provides the conformance test suite of the storage implementations.
+// the suite defines the behaviour every backend shares: duplicates, deletions, isolation between users, batches, passwords, click limits and stats.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alexkopcak/shortener/internal/storage"
+)
+
+const prefix = "http://localhost:8080" // short URL prefix of the batches and the user links
+
+// type Factory returns a new empty storage, the factory closes the storage when the test ends.
+// the factory may skip the test if the backend is not available.
+type Factory func(t *testing.T) storage.Storage
+
+// func Run runs the conformance suite against the storages created by the factory, every subtest gets its own storage.
+func Run(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repo storage.Storage)
+	}{
+		{name: "add and get", test: testAddGet},
+		{name: "short URL taken", test: testShortURLTaken},
+		{name: "duplicate original URL", test: testDuplicate},
+		{name: "delete", test: testDelete},
+		{name: "delete by another user", test: testDeleteNotOwner},
+		{name: "restore", test: testRestore},
+		{name: "restore of shortened again original URL", test: testRestoreDuplicate},
+		{name: "isolation between users", test: testIsolation},
+		{name: "batch", test: testBatch},
+		{name: "password", test: testPassword},
+		{name: "click limit", test: testClickLimit},
+		{name: "stats", test: testStats},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, factory(t))
+		})
+	}
+}
+
+// func add adds the link and checks that the storage accepted it.
+func add(t *testing.T, repo storage.Storage, longURLValue string, shortURLValue string, userID int32) {
+	t.Helper()
+	got, err := repo.AddURL(context.Background(), longURLValue, shortURLValue, userID, storage.URLOptions{})
+	require.NoError(t, err)
+	require.Equal(t, shortURLValue, got)
+}
+
+// func userLinks returns the short URL values of the user links.
+func userLinks(t *testing.T, repo storage.Storage, userID int32) []string {
+	t.Helper()
+	items, err := repo.GetUserURL(context.Background(), "", userID)
+	require.NoError(t, err)
+	result := make([]string, 0, len(items))
+	for _, v := range items {
+		result = append(result, v.ShortURL)
+	}
+	return result
+}
+
+func testAddGet(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+	add(t, repo, "http://conformance.test/1", "conf-1", 1)
+	add(t, repo, "http://conformance.test/2", "conf-2", 1)
+
+	got, err := repo.GetURL(ctx, "conf-1")
+	require.NoError(t, err)
+	assert.Equal(t, "http://conformance.test/1", got)
+
+	got, err = repo.GetURL(ctx, "conf-2")
+	require.NoError(t, err)
+	assert.Equal(t, "http://conformance.test/2", got, "the last link of the user is found")
+
+	got, err = repo.GetURL(ctx, "conf-unknown")
+	require.NoError(t, err, "unknown short URL value is not an error")
+	assert.Empty(t, got)
+
+	owner, err := repo.GetURLOwner(ctx, "conf-2")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), owner)
+
+	_, err = repo.GetURLOwner(ctx, "conf-unknown")
+	assert.ErrorIs(t, err, storage.ErrNotExistRecord)
+
+	assert.Equal(t, []string{"conf-1", "conf-2"}, userLinks(t, repo, 1))
+}
+
+func testShortURLTaken(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+	add(t, repo, "http://conformance.test/1", "conf-1", 1)
+
+	_, err := repo.AddURL(ctx, "http://conformance.test/2", "conf-1", 2, storage.URLOptions{})
+	assert.ErrorIs(t, err, storage.ErrShortURLTaken)
+
+	got, err := repo.GetURL(ctx, "conf-1")
+	require.NoError(t, err)
+	assert.Equal(t, "http://conformance.test/1", got, "the taken link keeps its original URL")
+	assert.Empty(t, userLinks(t, repo, 2))
+}
+
+func testDuplicate(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+	add(t, repo, "http://conformance.test/1", "conf-1", 1)
+
+	got, err := repo.AddURL(ctx, "http://conformance.test/1", "conf-2", 1, storage.URLOptions{})
+	assert.ErrorIs(t, err, storage.ErrDuplicateRecord)
+	assert.Equal(t, "conf-1", got, "the existing short URL value is returned")
+
+	longURL, err := repo.GetURL(ctx, "conf-2")
+	require.NoError(t, err)
+	assert.Empty(t, longURL, "the duplicate link is not created")
+	assert.Equal(t, []string{"conf-1"}, userLinks(t, repo, 1))
+
+	add(t, repo, "http://conformance.test/1", "conf-3", 2)
+	assert.Equal(t, []string{"conf-3"}, userLinks(t, repo, 2), "other users shorten the same original URL")
+}
+
+func testDelete(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+	add(t, repo, "http://conformance.test/1", "conf-1", 1)
+	add(t, repo, "http://conformance.test/2", "conf-2", 1)
+
+	require.NoError(t, repo.DeleteUserURL(ctx, &storage.DeletedShortURLValues{
+		ShortURLValues: []string{"conf-1", "conf-unknown"},
+		UserIDValue:    1,
+	}))
+
+	_, err := repo.GetURL(ctx, "conf-1")
+	assert.ErrorIs(t, err, storage.ErrNotExistRecord)
+	assert.Equal(t, []string{"conf-2"}, userLinks(t, repo, 1))
+
+	owner, err := repo.GetURLOwner(ctx, "conf-1")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), owner, "the deleted link keeps its owner")
+
+	_, err = repo.AddURL(ctx, "http://conformance.test/3", "conf-1", 2, storage.URLOptions{})
+	assert.ErrorIs(t, err, storage.ErrShortURLTaken, "the deleted link keeps its short URL value")
+
+	require.NoError(t, repo.DeleteUserURL(ctx, &storage.DeletedShortURLValues{
+		ShortURLValues: []string{"conf-1"},
+		UserIDValue:    1,
+	}), "the second deletion is not an error")
+}
+
+func testDeleteNotOwner(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+	add(t, repo, "http://conformance.test/1", "conf-1", 1)
+
+	require.NoError(t, repo.DeleteUserURL(ctx, &storage.DeletedShortURLValues{
+		ShortURLValues: []string{"conf-1"},
+		UserIDValue:    2,
+	}))
+
+	got, err := repo.GetURL(ctx, "conf-1")
+	require.NoError(t, err)
+	assert.Equal(t, "http://conformance.test/1", got)
+	assert.Equal(t, []string{"conf-1"}, userLinks(t, repo, 1))
+}
+
+func testRestore(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+	add(t, repo, "http://conformance.test/1", "conf-1", 1)
+	require.NoError(t, repo.DeleteUserURL(ctx, &storage.DeletedShortURLValues{
+		ShortURLValues: []string{"conf-1"},
+		UserIDValue:    1,
+	}))
+
+	restored, err := repo.RestoreUserURL(ctx, &storage.DeletedShortURLValues{
+		ShortURLValues: []string{"conf-1"},
+		UserIDValue:    2,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, restored, "only the owner restores the link")
+
+	restored, err = repo.RestoreUserURL(ctx, &storage.DeletedShortURLValues{
+		ShortURLValues: []string{"conf-1"},
+		UserIDValue:    1,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"conf-1"}, restored)
+
+	got, err := repo.GetURL(ctx, "conf-1")
+	require.NoError(t, err)
+	assert.Equal(t, "http://conformance.test/1", got)
+	assert.Equal(t, []string{"conf-1"}, userLinks(t, repo, 1))
+}
+
+func testRestoreDuplicate(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+	add(t, repo, "http://conformance.test/1", "conf-1", 1)
+	require.NoError(t, repo.DeleteUserURL(ctx, &storage.DeletedShortURLValues{
+		ShortURLValues: []string{"conf-1"},
+		UserIDValue:    1,
+	}))
+
+	// the backends which keep the original URL of the deleted link taken refuse to shorten it again
+	_, err := repo.AddURL(ctx, "http://conformance.test/1", "conf-2", 1, storage.URLOptions{})
+	if errors.Is(err, storage.ErrDuplicateRecord) {
+		t.Skip("the original URL of the deleted link is not shortened again")
+	}
+	require.NoError(t, err)
+
+	restored, err := repo.RestoreUserURL(ctx, &storage.DeletedShortURLValues{
+		ShortURLValues: []string{"conf-1"},
+		UserIDValue:    1,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, restored, "the user keeps one live link of the original URL")
+
+	_, err = repo.GetURL(ctx, "conf-1")
+	assert.ErrorIs(t, err, storage.ErrNotExistRecord)
+	assert.Equal(t, []string{"conf-2"}, userLinks(t, repo, 1))
+}
+
+func testIsolation(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+	add(t, repo, "http://conformance.test/1", "conf-1", 1)
+	add(t, repo, "http://conformance.test/2", "conf-2", 2)
+
+	assert.Equal(t, []string{"conf-1"}, userLinks(t, repo, 1))
+	assert.Equal(t, []string{"conf-2"}, userLinks(t, repo, 2))
+	assert.Empty(t, userLinks(t, repo, 3))
+
+	_, err := repo.UpdateURL(ctx, "conf-1", "http://conformance.test/3", 2)
+	assert.ErrorIs(t, err, storage.ErrNotOwner)
+	_, err = repo.GetURLHistory(ctx, "conf-1", 2)
+	assert.ErrorIs(t, err, storage.ErrNotOwner)
+
+	got, err := repo.GetURL(ctx, "conf-1")
+	require.NoError(t, err)
+	assert.Equal(t, "http://conformance.test/1", got)
+
+	page, err := repo.ListUserURL(ctx, "", 2, storage.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1)
+	assert.Equal(t, "conf-2", page.Items[0].ShortURL)
+}
+
+func testBatch(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+	add(t, repo, "http://conformance.test/1", "conf-1", 1)
+	add(t, repo, "http://conformance.test/2", "conf-2", 2)
+
+	got, err := repo.PostAPIBatch(ctx, &storage.BatchRequestArray{
+		{CorrelationID: "created", OriginalURL: "http://conformance.test/3", ShortURL: "conf-3"},
+		{CorrelationID: "exists", OriginalURL: "http://conformance.test/1", ShortURL: "conf-4"},
+		{CorrelationID: "taken", OriginalURL: "http://conformance.test/5", ShortURL: "conf-2"},
+		{CorrelationID: "invalid", OriginalURL: "not a url", ShortURL: "conf-6"},
+		{CorrelationID: "repeated", OriginalURL: "http://conformance.test/3", ShortURL: "conf-7"},
+	}, prefix, 1)
+	require.NoError(t, err)
+	assert.Equal(t, storage.BatchResponseArray{
+		{CorrelationID: "created", ShortURL: prefix + "/conf-3", Status: storage.BatchStatusCreated},
+		{CorrelationID: "exists", ShortURL: prefix + "/conf-1", Status: storage.BatchStatusExists},
+		{CorrelationID: "taken", Status: storage.BatchStatusTaken, Error: storage.ErrShortURLTaken.Error()},
+		{CorrelationID: "invalid", Status: storage.BatchStatusInvalid, Error: storage.ErrURLInvalid.Error()},
+		{CorrelationID: "repeated", ShortURL: prefix + "/conf-3", Status: storage.BatchStatusExists},
+	}, *got)
+
+	assert.Equal(t, []string{"conf-1", "conf-3"}, userLinks(t, repo, 1))
+	longURL, err := repo.GetURL(ctx, "conf-2")
+	require.NoError(t, err)
+	assert.Equal(t, "http://conformance.test/2", longURL, "the taken link keeps its original URL")
+}
+
+func testPassword(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+	got, err := repo.AddURL(ctx, "http://conformance.test/1", "conf-1", 1, storage.URLOptions{Password: "secret"})
+	require.NoError(t, err)
+	require.Equal(t, "conf-1", got)
+	add(t, repo, "http://conformance.test/2", "conf-2", 1)
+
+	_, err = repo.GetURL(ctx, "conf-1")
+	assert.ErrorIs(t, err, storage.ErrPasswordRequired)
+
+	_, err = repo.CheckURLPassword(ctx, "conf-1", "wrong")
+	assert.ErrorIs(t, err, storage.ErrPasswordMismatch)
+	longURL, err := repo.CheckURLPassword(ctx, "conf-1", "secret")
+	require.NoError(t, err)
+	assert.Equal(t, "http://conformance.test/1", longURL)
+
+	longURL, err = repo.CheckURLPassword(ctx, "conf-2", "any")
+	require.NoError(t, err)
+	assert.Equal(t, "http://conformance.test/2", longURL, "the link without password is resolved with any password")
+
+	_, err = repo.AddURL(ctx, "http://conformance.test/3", "conf-3", 1, storage.URLOptions{Password: strings.Repeat("p", 73)})
+	assert.ErrorIs(t, err, storage.ErrPasswordInvalid)
+
+	batch, err := repo.PostAPIBatch(ctx, &storage.BatchRequestArray{
+		{CorrelationID: "protected", OriginalURL: "http://conformance.test/4", ShortURL: "conf-4", URLOptions: storage.URLOptions{Password: "secret"}},
+	}, prefix, 1)
+	require.NoError(t, err)
+	require.Equal(t, storage.BatchStatusCreated, (*batch)[0].Status)
+	_, err = repo.GetURL(ctx, "conf-4")
+	assert.ErrorIs(t, err, storage.ErrPasswordRequired)
+
+	require.NoError(t, repo.DeleteUserURL(ctx, &storage.DeletedShortURLValues{
+		ShortURLValues: []string{"conf-1"},
+		UserIDValue:    1,
+	}))
+	_, err = repo.CheckURLPassword(ctx, "conf-1", "secret")
+	assert.ErrorIs(t, err, storage.ErrNotExistRecord, "the deleted link is not resolved with the password")
+}
+
+func testClickLimit(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+	got, err := repo.AddURL(ctx, "http://conformance.test/1", "conf-1", 1, storage.URLOptions{MaxClicks: 2})
+	require.NoError(t, err)
+	require.Equal(t, "conf-1", got)
+	add(t, repo, "http://conformance.test/2", "conf-2", 1)
+
+	for i := 0; i < 2; i++ {
+		longURL, err := repo.GetURL(ctx, "conf-1")
+		assert.ErrorIs(t, err, storage.ErrClicksLimited)
+		assert.Equal(t, "http://conformance.test/1", longURL)
+		require.NoError(t, repo.ConsumeClick(ctx, "conf-1"))
+	}
+	assert.ErrorIs(t, repo.ConsumeClick(ctx, "conf-1"), storage.ErrClicksExhausted)
+	_, err = repo.GetURL(ctx, "conf-1")
+	assert.ErrorIs(t, err, storage.ErrClicksExhausted)
+
+	require.NoError(t, repo.ConsumeClick(ctx, "conf-2"), "the link without limit is not changed")
+	_, err = repo.GetURL(ctx, "conf-2")
+	require.NoError(t, err)
+
+	items, err := repo.GetUserURL(ctx, "", 1)
+	require.NoError(t, err)
+	clicksLeft := make(map[string]*int64)
+	for _, v := range items {
+		clicksLeft[v.ShortURL] = v.ClicksLeft
+	}
+	require.Contains(t, clicksLeft, "conf-1", "the used up link is listed to the owner")
+	require.NotNil(t, clicksLeft["conf-1"])
+	assert.Equal(t, int64(0), *clicksLeft["conf-1"])
+	assert.Nil(t, clicksLeft["conf-2"])
+
+	_, err = repo.AddURL(ctx, "http://conformance.test/3", "conf-3", 1, storage.URLOptions{MaxClicks: -1})
+	assert.ErrorIs(t, err, storage.ErrMaxClicksInvalid)
+
+	batch, err := repo.PostAPIBatch(ctx, &storage.BatchRequestArray{
+		{CorrelationID: "limited", OriginalURL: "http://conformance.test/4", ShortURL: "conf-4", URLOptions: storage.URLOptions{MaxClicks: 1, Password: "secret"}},
+	}, prefix, 1)
+	require.NoError(t, err)
+	require.Equal(t, storage.BatchStatusCreated, (*batch)[0].Status)
+	_, err = repo.GetURL(ctx, "conf-4")
+	assert.ErrorIs(t, err, storage.ErrPasswordRequired, "the password is checked before the click")
+	_, err = repo.CheckURLPassword(ctx, "conf-4", "secret")
+	assert.ErrorIs(t, err, storage.ErrClicksLimited)
+	require.NoError(t, repo.ConsumeClick(ctx, "conf-4"))
+	_, err = repo.CheckURLPassword(ctx, "conf-4", "secret")
+	assert.ErrorIs(t, err, storage.ErrClicksExhausted)
+
+	assert.ErrorIs(t, repo.ConsumeClick(ctx, "conf-unknown"), storage.ErrNotExistRecord)
+}
+
+func testStats(t *testing.T, repo storage.Storage) {
+	ctx := context.Background()
+	stats, err := repo.GetInternalStats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, storage.InternalStats{}, stats)
+
+	add(t, repo, "http://conformance.test/1", "conf-1", 1)
+	add(t, repo, "http://conformance.test/2", "conf-2", 1)
+	add(t, repo, "http://conformance.test/3", "conf-3", 2)
+
+	stats, err = repo.GetInternalStats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, storage.InternalStats{URLs: 3, Users: 2}, stats)
+
+	require.NoError(t, repo.DeleteUserURL(ctx, &storage.DeletedShortURLValues{
+		ShortURLValues: []string{"conf-2", "conf-3"},
+		UserIDValue:    2,
+	}))
+	stats, err = repo.GetInternalStats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, storage.InternalStats{URLs: 2, Users: 1}, stats, "the deleted links and their users are not counted")
+}